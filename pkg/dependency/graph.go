@@ -0,0 +1,168 @@
+package dependency
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// CycleError is returned when the dependencies between the probe types form a cycle
+type CycleError struct {
+	// Cycle lists the probe types along the cycle, starting and ending with the same probe type
+	Cycle []string
+}
+
+// Error lists the probe types along the cycle
+func (e *CycleError) Error() string {
+	return fmt.Sprintf("dependency cycle among probe types: %v", strings.Join(e.Cycle, " -> "))
+}
+
+// Graph keeps the dependencies between probe types: a probe type depends on its prerequisites, which must be running
+// for its probe to work, e.g. an application probe on the matching infrastructure probe.  A graph is free of cycles.
+type Graph struct {
+	// prerequisites maps each probe type to the sorted probe types it directly depends on
+	prerequisites map[string][]string
+	// dependents maps each probe type to the sorted probe types directly depending on it
+	dependents map[string][]string
+}
+
+// NewGraph constructs a graph from the given prerequisites of each probe type.  It returns a CycleError if a probe type
+// depends on itself, directly or through other probe types.
+func NewGraph(prerequisites map[string][]string) (*Graph, error) {
+	g := &Graph{prerequisites: map[string][]string{}, dependents: map[string][]string{}}
+	for probeType, direct := range prerequisites {
+		for _, prerequisite := range direct {
+			g.prerequisites[probeType] = appendUnique(g.prerequisites[probeType], prerequisite)
+			g.dependents[prerequisite] = appendUnique(g.dependents[prerequisite], probeType)
+		}
+	}
+	for _, edges := range []map[string][]string{g.prerequisites, g.dependents} {
+		for _, probeTypes := range edges {
+			sort.Strings(probeTypes)
+		}
+	}
+	if cycle := g.findCycle(); cycle != nil {
+		return nil, &CycleError{Cycle: cycle}
+	}
+	return g, nil
+}
+
+// LoadGraph constructs a graph from a YAML file mapping each probe type to the list of its prerequisites, e.g.
+//
+//	appdynamics: [vcenter]
+//	vcenter-browsing: [vcenter]
+func LoadGraph(path string) (*Graph, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the probe dependencies from %v\n%v", path, err)
+	}
+	prerequisites := map[string][]string{}
+	if err := yaml.UnmarshalStrict(content, &prerequisites); err != nil {
+		return nil, fmt.Errorf("failed to decode the probe dependencies from %v\n%v", path, err)
+	}
+	g, err := NewGraph(prerequisites)
+	if err != nil {
+		return nil, fmt.Errorf("invalid probe dependencies in %v\n%v", path, err)
+	}
+	return g, nil
+}
+
+// Prerequisites returns the probe types the given probe type depends on, directly or through other probe types, in
+// topological order, i.e. each probe type comes after all of its own prerequisites
+func (g *Graph) Prerequisites(probeType string) []string {
+	var ordered []string
+	visited := map[string]bool{}
+	var visit func(string)
+	visit = func(current string) {
+		for _, prerequisite := range g.prerequisites[current] {
+			if !visited[prerequisite] {
+				visited[prerequisite] = true
+				visit(prerequisite)
+				ordered = append(ordered, prerequisite)
+			}
+		}
+	}
+	visit(probeType)
+	return ordered
+}
+
+// Dependents returns the sorted probe types depending on the given probe type, directly or through other probe types
+func (g *Graph) Dependents(probeType string) []string {
+	visited := map[string]bool{}
+	pending := []string{probeType}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dependent := range g.dependents[current] {
+			if !visited[dependent] {
+				visited[dependent] = true
+				pending = append(pending, dependent)
+			}
+		}
+	}
+	dependents := make([]string, 0, len(visited))
+	for dependent := range visited {
+		dependents = append(dependents, dependent)
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// findCycle returns the probe types along a cycle, starting and ending with the same probe type, or nil if the graph
+// has no cycle
+func (g *Graph) findCycle() []string {
+	const (
+		unvisited = iota
+		inProgress
+		done
+	)
+	states := map[string]int{}
+	var path []string
+	var visit func(string) []string
+	visit = func(probeType string) []string {
+		states[probeType] = inProgress
+		path = append(path, probeType)
+		for _, prerequisite := range g.prerequisites[probeType] {
+			switch states[prerequisite] {
+			case inProgress:
+				for i, onPath := range path {
+					if onPath == prerequisite {
+						return append(append([]string{}, path[i:]...), prerequisite)
+					}
+				}
+			case unvisited:
+				if cycle := visit(prerequisite); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		states[probeType] = done
+		return nil
+	}
+	probeTypes := make([]string, 0, len(g.prerequisites))
+	for probeType := range g.prerequisites {
+		probeTypes = append(probeTypes, probeType)
+	}
+	sort.Strings(probeTypes)
+	for _, probeType := range probeTypes {
+		if states[probeType] == unvisited {
+			if cycle := visit(probeType); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// appendUnique appends the given probe type unless already listed
+func appendUnique(probeTypes []string, probeType string) []string {
+	for _, listed := range probeTypes {
+		if listed == probeType {
+			return probeTypes
+		}
+	}
+	return append(probeTypes, probeType)
+}
@@ -0,0 +1,61 @@
+package dependency_test
+
+import (
+	goerrors "errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/dependency"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Dependency graph", func() {
+	It("orders the prerequisites topologically and lists the dependents", func() {
+		g, err := dependency.NewGraph(map[string][]string{
+			"appdynamics":      {"vcenter", "pure"},
+			"pure":             {"vcenter"},
+			"vcenter-browsing": {"vcenter"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(g.Prerequisites("appdynamics")).To(Equal([]string{"vcenter", "pure"}))
+		Expect(g.Prerequisites("vcenter-browsing")).To(Equal([]string{"vcenter"}))
+		Expect(g.Prerequisites("vcenter")).To(BeEmpty())
+		Expect(g.Dependents("vcenter")).To(Equal([]string{"appdynamics", "pure", "vcenter-browsing"}))
+		Expect(g.Dependents("pure")).To(Equal([]string{"appdynamics"}))
+		Expect(g.Dependents("aws")).To(BeEmpty())
+	})
+
+	DescribeTable("rejecting dependency cycles",
+		func(prerequisites map[string][]string, expectedCycle []string) {
+			_, err := dependency.NewGraph(prerequisites)
+			var cycleErr *dependency.CycleError
+			Expect(goerrors.As(err, &cycleErr)).To(BeTrue())
+			Expect(cycleErr.Cycle).To(Equal(expectedCycle))
+		},
+		Entry("a probe type depending on itself", map[string][]string{"vcenter": {"vcenter"}},
+			[]string{"vcenter", "vcenter"}),
+		Entry("two probe types depending on each other", map[string][]string{"a": {"b"}, "b": {"a"}},
+			[]string{"a", "b", "a"}),
+		Entry("a longer cycle", map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"d", "a"}},
+			[]string{"a", "b", "c", "a"}),
+	)
+
+	It("loads the dependencies from a YAML file", func() {
+		dir, err := ioutil.TempDir("", "dependencies-")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "dependencies.yaml")
+
+		Expect(ioutil.WriteFile(path, []byte("appdynamics: [vcenter]\nvcenter-browsing: [vcenter]\n"), 0600)).To(Succeed())
+		g, err := dependency.LoadGraph(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(g.Dependents("vcenter")).To(Equal([]string{"appdynamics", "vcenter-browsing"}))
+
+		Expect(ioutil.WriteFile(path, []byte("a: [b]\nb: [a]\n"), 0600)).To(Succeed())
+		_, err = dependency.LoadGraph(path)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("a -> b -> a"))
+	})
+})
@@ -0,0 +1,13 @@
+package fault_injection_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFaultInjection(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Fault Injection Suite")
+}
@@ -0,0 +1,123 @@
+package fault_injection
+
+import (
+	"sync"
+	"time"
+)
+
+// AnyMethod can be passed in place of a method name to inject a fault into the calls to every method
+const AnyMethod = "*"
+
+// Call records a single call made to a component with fault injection
+type Call struct {
+	// Method is the name of the method called
+	Method string
+	// Args lists the arguments of the call that identify what it applies to, such as the probe type and the target id
+	Args []string
+	// Err is the error injected into this call, if any
+	Err error
+}
+
+// FaultInjector records the calls made to a component and injects faults into them: failing the nth call of a
+// method, failing every call of a method, or delaying the calls.  It is meant to be embedded in test implementations
+// and is safe for concurrent use.
+type FaultInjector struct {
+	lock    sync.Mutex
+	calls   []Call
+	counts  map[string]int
+	failNth map[string]map[int]error
+	failAll map[string]error
+	latency map[string]time.Duration
+}
+
+// NewFaultInjector constructs a FaultInjector that injects no fault until told to
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		counts:  map[string]int{},
+		failNth: map[string]map[int]error{},
+		failAll: map[string]error{},
+		latency: map[string]time.Duration{},
+	}
+}
+
+// FailNthCall makes the nth call (counting from 1, including the calls already made) to the given method fail with
+// the given error
+func (f *FaultInjector) FailNthCall(method string, n int, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.failNth[method] == nil {
+		f.failNth[method] = map[int]error{}
+	}
+	f.failNth[method][n] = err
+}
+
+// FailAllCalls makes every subsequent call to the given method fail with the given error; a nil error lifts the fault
+func (f *FaultInjector) FailAllCalls(method string, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if err == nil {
+		delete(f.failAll, method)
+	} else {
+		f.failAll[method] = err
+	}
+}
+
+// SetLatency delays every subsequent call to the given method by the given duration
+func (f *FaultInjector) SetLatency(method string, latency time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.latency[method] = latency
+}
+
+// Calls returns the history of all the calls made so far, in order
+func (f *FaultInjector) Calls() []Call {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]Call(nil), f.calls...)
+}
+
+// CallsTo returns the history of the calls made so far to the given method, in order
+func (f *FaultInjector) CallsTo(method string) []Call {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var calls []Call
+	for _, call := range f.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// ResetCalls clears the call history and the call counts, while keeping the faults to inject
+func (f *FaultInjector) ResetCalls() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.calls = nil
+	f.counts = map[string]int{}
+}
+
+// Inject records a call to the given method, applies the configured latency and returns the error to fail the call
+// with, or nil if the call should proceed
+func (f *FaultInjector) Inject(method string, args ...string) error {
+	f.lock.Lock()
+	f.counts[method]++
+	err := f.failNth[method][f.counts[method]]
+	if err == nil {
+		err = f.failAll[method]
+	}
+	if err == nil {
+		err = f.failAll[AnyMethod]
+	}
+	latency := f.latency[method]
+	if latency == 0 {
+		latency = f.latency[AnyMethod]
+	}
+	f.calls = append(f.calls, Call{Method: method, Args: args, Err: err})
+	f.lock.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	return err
+}
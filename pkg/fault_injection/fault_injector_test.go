@@ -0,0 +1,61 @@
+package fault_injection_test
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/fault_injection"
+)
+
+var _ = Describe("Test fault injector", func() {
+	var injector *fault_injection.FaultInjector
+
+	BeforeEach(func() {
+		injector = fault_injection.NewFaultInjector()
+	})
+
+	It("records every call in order", func() {
+		Expect(injector.Inject("StartProbe", "vcenter")).To(Succeed())
+		Expect(injector.Inject("StopProbe", "pure")).To(Succeed())
+		Expect(injector.Inject("StartProbe", "pure")).To(Succeed())
+		Expect(injector.Calls()).To(Equal([]fault_injection.Call{
+			{Method: "StartProbe", Args: []string{"vcenter"}},
+			{Method: "StopProbe", Args: []string{"pure"}},
+			{Method: "StartProbe", Args: []string{"pure"}},
+		}))
+		Expect(injector.CallsTo("StartProbe")).To(HaveLen(2))
+
+		injector.ResetCalls()
+		Expect(injector.Calls()).To(BeEmpty())
+	})
+
+	It("fails only the nth call of a method", func() {
+		injectedErr := fmt.Errorf("injected")
+		injector.FailNthCall("StartProbe", 2, injectedErr)
+		Expect(injector.Inject("StopProbe", "vcenter")).To(Succeed())
+		Expect(injector.Inject("StartProbe", "vcenter")).To(Succeed())
+		Expect(injector.Inject("StartProbe", "vcenter")).To(MatchError(injectedErr))
+		Expect(injector.Inject("StartProbe", "vcenter")).To(Succeed())
+		Expect(injector.CallsTo("StartProbe")[1].Err).To(Equal(injectedErr))
+	})
+
+	It("fails every call until the fault is lifted", func() {
+		injectedErr := fmt.Errorf("injected")
+		injector.FailAllCalls(fault_injection.AnyMethod, injectedErr)
+		Expect(injector.Inject("StartProbe", "vcenter")).To(MatchError(injectedErr))
+		Expect(injector.Inject("StopProbe", "vcenter")).To(MatchError(injectedErr))
+		injector.FailAllCalls(fault_injection.AnyMethod, nil)
+		Expect(injector.Inject("StartProbe", "vcenter")).To(Succeed())
+	})
+
+	It("delays the calls of a method", func() {
+		injector.SetLatency("StartProbe", 50*time.Millisecond)
+		start := time.Now()
+		Expect(injector.Inject("StopProbe", "vcenter")).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically("<", 50*time.Millisecond))
+		Expect(injector.Inject("StartProbe", "vcenter")).To(Succeed())
+		Expect(time.Since(start)).To(BeNumerically(">=", 50*time.Millisecond))
+	})
+})
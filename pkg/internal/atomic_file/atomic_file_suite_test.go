@@ -0,0 +1,13 @@
+package atomic_file_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAtomicFile(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Atomic File Suite")
+}
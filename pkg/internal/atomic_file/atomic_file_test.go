@@ -0,0 +1,45 @@
+package atomic_file_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/internal/atomic_file"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Atomic file", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "atomic-file-")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("replaces the file, readable by the owner only, without leaving a temporary file behind", func() {
+		path := filepath.Join(dir, "state.json")
+		Expect(ioutil.WriteFile(path, []byte("old"), 0644)).To(Succeed())
+
+		Expect(atomic_file.WriteFile(path, []byte("new"))).To(Succeed())
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte("new")))
+		info, err := os.Stat(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+	})
+
+	It("fails without touching anything if the directory does not exist", func() {
+		Expect(atomic_file.WriteFile(filepath.Join(dir, "missing", "state.json"), []byte("new"))).NotTo(Succeed())
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+})
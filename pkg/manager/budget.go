@@ -0,0 +1,154 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	"k8s.io/klog"
+	"sort"
+	"time"
+)
+
+// WithProbeBudget limits the number of probes enabled at the same time, as each of them costs a pod.  A probe that
+// would exceed the budget is not started but queued as starved, and started once a slot frees up, the starved probes
+// with the highest priority first.  Running probes are never stopped to make room.  The default of 0 sets no limit.
+// Raising the budget starts the starved probes in the slots freed up.  Only the managed probes take slots: the other
+// components enabled in the XL custom resource, such as kubeturbo or grafana, are left out of the count.
+func (m *ProbeLifecycleManager) WithProbeBudget(maxEnabledProbes int) *ProbeLifecycleManager {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.budgetLock.Lock()
+	m.probeBudget = maxEnabledProbes
+	m.budgetLock.Unlock()
+	m.startStarvedProbes()
+	return m
+}
+
+// WithProbePriority sets the priority of the probe of the given type in the queue of the starved probes; the higher,
+// the sooner started.  The default priority is 0.
+func (m *ProbeLifecycleManager) WithProbePriority(probeType string, priority int) *ProbeLifecycleManager {
+	m.budgetLock.Lock()
+	defer m.budgetLock.Unlock()
+	m.probePriorities[probeType] = priority
+	return m
+}
+
+// IsStarved returns true if the probe of the given type is queued until a slot of the probe budget frees up
+func (m *ProbeLifecycleManager) IsStarved(probeType string) bool {
+	m.budgetLock.Lock()
+	defer m.budgetLock.Unlock()
+	_, starved := m.starvedProbes[probeType]
+	return starved
+}
+
+// ListStarvedProbes returns the probe types queued until a slot of the probe budget frees up, in the order they are
+// due to start: by decreasing priority, then by the time queued
+func (m *ProbeLifecycleManager) ListStarvedProbes() []string {
+	m.budgetLock.Lock()
+	defer m.budgetLock.Unlock()
+	probeTypes := make([]string, 0, len(m.starvedProbes))
+	for probeType := range m.starvedProbes {
+		probeTypes = append(probeTypes, probeType)
+	}
+	sort.Slice(probeTypes, func(i, j int) bool {
+		pi, pj := m.probePriorities[probeTypes[i]], m.probePriorities[probeTypes[j]]
+		if pi != pj {
+			return pi > pj
+		}
+		qi, qj := m.starvedProbes[probeTypes[i]], m.starvedProbes[probeTypes[j]]
+		if !qi.Equal(qj) {
+			return qi.Before(qj)
+		}
+		return probeTypes[i] < probeTypes[j]
+	})
+	return probeTypes
+}
+
+// byPriority returns a copy of the given probe types sorted by decreasing priority, keeping the order of the probe
+// types of the same priority
+func (m *ProbeLifecycleManager) byPriority(probeTypes []string) []string {
+	m.budgetLock.Lock()
+	defer m.budgetLock.Unlock()
+	sorted := append([]string{}, probeTypes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return m.probePriorities[sorted[i]] > m.probePriorities[sorted[j]]
+	})
+	return sorted
+}
+
+// budgetAllows returns true if the probe budget, counting the managed probes enabled, leaves enough slots to enable
+// every given probe not enabled yet
+func (m *ProbeLifecycleManager) budgetAllows(probeTypes []string) (bool, error) {
+	m.budgetLock.Lock()
+	budget := m.probeBudget
+	m.budgetLock.Unlock()
+	if budget <= 0 {
+		return true, nil
+	}
+	probeStates, err := m.probeController.ListProbes()
+	if err != nil {
+		return false, fmt.Errorf("failed to list the probes to check the probe budget\n%v", err)
+	}
+	enabled := 0
+	for probeType, state := range probeStates {
+		if state == probe_controller.ProbeStateEnabled && m.IsManagedProbe(probeType) {
+			enabled++
+		}
+	}
+	for _, probeType := range probeTypes {
+		if probeStates[probeType] != probe_controller.ProbeStateEnabled {
+			enabled++
+		}
+	}
+	return enabled <= budget, nil
+}
+
+// starve queues the probe until a slot of the probe budget frees up, keeping its place if queued already
+func (m *ProbeLifecycleManager) starve(probeType string) {
+	m.budgetLock.Lock()
+	defer m.budgetLock.Unlock()
+	if _, starved := m.starvedProbes[probeType]; !starved {
+		m.starvedProbes[probeType] = time.Now()
+		klog.Infof("Probe budget of %d spent; probe %v is starved until a slot frees up", m.probeBudget, probeType)
+	}
+}
+
+// unstarve removes the probe from the queue of the starved probes, once started or no longer needed
+func (m *ProbeLifecycleManager) unstarve(probeType string) {
+	m.budgetLock.Lock()
+	defer m.budgetLock.Unlock()
+	delete(m.starvedProbes, probeType)
+}
+
+// startStarvedProbes starts the starved probes still needed in the order they are due to start, as long as the probe
+// budget allows, and forgets the ones no longer needed, completing their start intents either way.  A failure is only
+// logged, and left to the reconciler.
+func (m *ProbeLifecycleManager) startStarvedProbes() {
+	for _, probeType := range m.ListStarvedProbes() {
+		needed, err := m.neededOnItsOwn(probeType)
+		if err == nil && !needed {
+			var dependents []string
+			dependents, err = m.runningDependents(probeType)
+			needed = len(dependents) > 0
+		}
+		if err != nil {
+			klog.Errorf("Failed to tell whether starved probe %v is still needed: %v", probeType, err)
+			continue
+		}
+		if !needed {
+			m.unstarve(probeType)
+			m.completeIntent(probeType, outbox.ActionStartProbe)
+			continue
+		}
+		started, err := m.startProbe(probeType)
+		if err != nil {
+			klog.Errorf("Failed to start starved probe %v: %v", probeType, err)
+			continue
+		}
+		if !started {
+			return
+		}
+		m.completeIntent(probeType, outbox.ActionStartProbe)
+		klog.Infof("Started starved probe %v as a slot of the probe budget has freed up", probeType)
+	}
+}
@@ -0,0 +1,110 @@
+package manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+)
+
+var _ = Describe("Test probe budget", func() {
+	var (
+		controller *probeinmemory.InMemoryProbeController
+		m          *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		controller = probeinmemory.NewInMemoryProbeController(nil)
+		m = manager.NewProbeLifecycleManager(newRegistrar(nil), controller).WithProbeBudget(1)
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+	})
+
+	It("starves a probe beyond the budget, and starts it once a slot frees up", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "pure")).NotTo(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.ListStarvedProbes()).To(Equal([]string{"pure"}))
+		status, err := m.GetProbeStatus("pure")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Starved).To(BeTrue())
+		Expect(status.InSync()).To(BeTrue())
+
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateDisabled))
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.IsStarved("pure")).To(BeFalse())
+	})
+
+	It("starts the starved probe with the highest priority first", func() {
+		m.WithProbePriority("appdynamics", 10)
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("appdynamics", "App1"))).To(Succeed())
+		Expect(m.ListStarvedProbes()).To(Equal([]string{"appdynamics", "pure"}))
+
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "appdynamics")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.ListStarvedProbes()).To(Equal([]string{"pure"}))
+	})
+
+	It("never stops a running probe of a lower priority to make room", func() {
+		m.WithProbePriority("pure", 10)
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(getState(controller, "pure")).NotTo(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.ListStarvedProbes()).To(Equal([]string{"pure"}))
+		Expect(controller.CallsTo("StopProbe")).To(BeEmpty())
+	})
+
+	It("starts the starved probe with the highest priority once the budget is raised", func() {
+		m.WithProbePriority("appdynamics", 10)
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("appdynamics", "App1"))).To(Succeed())
+		Expect(m.ListStarvedProbes()).To(Equal([]string{"appdynamics", "pure"}))
+
+		m.WithProbeBudget(2)
+		Expect(getState(controller, "appdynamics")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(getState(controller, "pure")).NotTo(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.ListStarvedProbes()).To(Equal([]string{"pure"}))
+	})
+
+	It("starts a starved probe in a slot freed up outside the manager upon setting an override mode", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(controller.StopProbe("vcenter")).To(Succeed())
+		Expect(m.SetOverrideMode("aws", target_registrar.OverrideAuto)).To(Succeed())
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.IsStarved("pure")).To(BeFalse())
+	})
+
+	It("forgets a starved probe whose targets have gone away", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.DeleteTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.ListStarvedProbes()).To(BeEmpty())
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(controller.CallsTo("StartProbe")).To(HaveLen(1))
+	})
+
+	It("leaves the other components of the platform out of the budget", func() {
+		controller = probeinmemory.NewInMemoryProbeController(map[string]probe_controller.ProbeState{
+			"kubeturbo": probe_controller.ProbeStateEnabled,
+			"grafana":   probe_controller.ProbeStateEnabled,
+		})
+		m = manager.NewProbeLifecycleManager(newRegistrar(nil), controller).WithProbeBudget(1)
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.ListStarvedProbes()).To(Equal([]string{"pure"}))
+	})
+
+	It("reconciles the probes with the highest priority first, and reports the starved ones", func() {
+		Expect(controller.StopProbe("vcenter")).To(Succeed())
+		registrar := newRegistrar(map[string]int{"appdynamics": 1, "pure": 1, "vcenter": 1})
+		m = manager.NewProbeLifecycleManager(registrar, controller).WithProbeBudget(2).
+			WithProbePriority("pure", 1).WithProbePriority("vcenter", 5)
+
+		report, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Started).To(Equal([]string{"vcenter", "pure"}))
+		Expect(report.Starved).To(Equal([]string{"appdynamics"}))
+	})
+})
@@ -0,0 +1,154 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/dependency"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"k8s.io/klog"
+	"time"
+)
+
+// WithDependencies sets the dependencies between the probe types.  Starting a probe starts its prerequisites first, in
+// topological order, and the stop of a probe is deferred while a running probe depends on it: the probe is stopped
+// once its last running dependent has stopped.
+func (m *ProbeLifecycleManager) WithDependencies(graph *dependency.Graph) *ProbeLifecycleManager {
+	m.dependencies = graph
+	return m
+}
+
+// startProbe starts the prerequisites of the probe in topological order, then the probe itself.  A prerequisite pinned
+// off by its override mode fails the start.  It returns false if the probe budget cannot afford the probe along with
+// its prerequisites not enabled yet, in which case none of them is started and the probe is queued as starved.
+func (m *ProbeLifecycleManager) startProbe(probeType string) (bool, error) {
+	prerequisites := m.prerequisites(probeType)
+	// the prerequisites started along are managed as well, so that they are restarted while a dependent needs them
+	m.manageProbes(prerequisites)
+	for _, prerequisite := range prerequisites {
+		mode, err := m.overrideMode(prerequisite)
+		if err != nil {
+			return false, err
+		}
+		if mode == target_registrar.OverrideForceDisabled {
+			return false, fmt.Errorf("cannot start probe %v as its prerequisite %v is pinned off", probeType,
+				prerequisite)
+		}
+	}
+	allowed, err := m.budgetAllows(append(append([]string{}, prerequisites...), probeType))
+	if err != nil {
+		return false, err
+	}
+	if !allowed {
+		m.starve(probeType)
+		return false, nil
+	}
+	for _, prerequisite := range prerequisites {
+		started, err := m.enableProbe(prerequisite)
+		if err != nil {
+			return false, fmt.Errorf("failed to start prerequisite %v of probe %v\n%v", prerequisite, probeType, err)
+		}
+		if !started {
+			m.starve(probeType)
+			return false, nil
+		}
+	}
+	return m.enableProbe(probeType)
+}
+
+// stopProbe stops the probe unless a running probe depends on it, then stops its prerequisites no longer needed, and
+// starts the starved probes in the slots freed up.  It returns false if the stop is deferred until the running
+// dependents have stopped.
+func (m *ProbeLifecycleManager) stopProbe(probeType string) (bool, error) {
+	stopped, err := m.stopUnlessNeeded(probeType)
+	if err != nil || !stopped {
+		return stopped, err
+	}
+	m.releasePrerequisites(probeType)
+	m.startStarvedProbes()
+	return true, nil
+}
+
+// stopUnlessNeeded stops the probe, or returns false if a running probe depends on it
+func (m *ProbeLifecycleManager) stopUnlessNeeded(probeType string) (bool, error) {
+	dependents, err := m.runningDependents(probeType)
+	if err != nil {
+		return false, err
+	}
+	if len(dependents) > 0 {
+		klog.Infof("Deferring the stop of probe %v still needed by running probes %v", probeType, dependents)
+		return false, nil
+	}
+	return true, m.disableProbe(probeType)
+}
+
+// releasePrerequisites stops the prerequisites of the stopped probe that are neither needed on their own nor by
+// another running probe, each after the prerequisites depending on it.  A failure is only logged, and left to the
+// reconciler.
+func (m *ProbeLifecycleManager) releasePrerequisites(probeType string) {
+	prerequisites := m.prerequisites(probeType)
+	for i := len(prerequisites) - 1; i >= 0; i-- {
+		prerequisite := prerequisites[i]
+		if state, err := m.probeController.GetProbeState(prerequisite); err != nil ||
+			state == probe_controller.ProbeStateDisabled {
+			continue
+		}
+		needed, err := m.neededOnItsOwn(prerequisite)
+		if err != nil {
+			klog.Errorf("Failed to tell whether probe %v is still needed after stopping probe %v: %v", prerequisite,
+				probeType, err)
+			continue
+		}
+		if needed {
+			continue
+		}
+		if stopped, err := m.stopUnlessNeeded(prerequisite); err != nil {
+			klog.Errorf("Failed to stop probe %v no longer needed by probe %v: %v", prerequisite, probeType, err)
+		} else if stopped {
+			klog.Infof("Stopped probe %v no longer needed by probe %v", prerequisite, probeType)
+		}
+	}
+}
+
+// neededOnItsOwn returns true if the probe should run regardless of its dependents, or is due to stop at the end of its
+// grace period
+func (m *ProbeLifecycleManager) neededOnItsOwn(probeType string) (bool, error) {
+	if _, pending := m.GetPendingStop(probeType); pending {
+		return true, nil
+	}
+	count, err := m.CountProbeTargets(probeType)
+	if err != nil {
+		return false, err
+	}
+	mode, err := m.overrideMode(probeType)
+	if err != nil {
+		return false, err
+	}
+	return shouldRun(mode, count, m.windowOpen(probeType, time.Now())), nil
+}
+
+// runningDependents returns the sorted probe types depending on the given probe type whose probes are enabled
+func (m *ProbeLifecycleManager) runningDependents(probeType string) ([]string, error) {
+	if m.dependencies == nil {
+		return nil, nil
+	}
+	var running []string
+	for _, dependent := range m.dependencies.Dependents(probeType) {
+		state, err := m.probeController.GetProbeState(dependent)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the state of probe %v depending on probe %v\n%v", dependent,
+				probeType, err)
+		}
+		if state == probe_controller.ProbeStateEnabled {
+			running = append(running, dependent)
+		}
+	}
+	return running, nil
+}
+
+// prerequisites returns the prerequisites of the given probe type in topological order
+func (m *ProbeLifecycleManager) prerequisites(probeType string) []string {
+	if m.dependencies == nil {
+		return nil
+	}
+	return m.dependencies.Prerequisites(probeType)
+}
@@ -0,0 +1,110 @@
+package manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/dependency"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+)
+
+// probesCalled returns the probe types passed to the given method of the probe controller, in the order of the calls
+func probesCalled(controller *probeinmemory.InMemoryProbeController, method string) []string {
+	var probeTypes []string
+	for _, call := range controller.CallsTo(method) {
+		probeTypes = append(probeTypes, call.Args[0])
+	}
+	return probeTypes
+}
+
+var _ = Describe("Test probe dependencies", func() {
+	var (
+		controller *probeinmemory.InMemoryProbeController
+		m          *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		graph, err := dependency.NewGraph(map[string][]string{
+			"appdynamics":      {"pure", "vcenter"},
+			"pure":             {"vcenter"},
+			"vcenter-browsing": {"vcenter"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		controller = probeinmemory.NewInMemoryProbeController(nil)
+		m = manager.NewProbeLifecycleManager(newRegistrar(nil), controller).WithDependencies(graph)
+	})
+
+	It("starts the prerequisites of a probe first, in topological order", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("appdynamics", "App1"))).To(Succeed())
+		Expect(probesCalled(controller, "StartProbe")).To(Equal([]string{"vcenter", "pure", "appdynamics"}))
+	})
+
+	It("defers the stop of a probe until its running dependents have stopped", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter-browsing", "Moid1"))).To(Succeed())
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+
+		status, err := m.GetProbeStatus("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.NeededBy).To(Equal([]string{"vcenter-browsing"}))
+		Expect(status.InSync()).To(BeTrue())
+		report, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.HasFixes()).To(BeFalse())
+
+		Expect(m.DeleteTarget(newTarget("vcenter-browsing", "Moid1"))).To(Succeed())
+		Expect(probesCalled(controller, "StopProbe")).To(Equal([]string{"vcenter-browsing", "vcenter"}))
+	})
+
+	It("keeps a prerequisite with targets running after its dependent has stopped", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter-browsing", "Moid1"))).To(Succeed())
+		Expect(m.DeleteTarget(newTarget("vcenter-browsing", "Moid1"))).To(Succeed())
+		Expect(probesCalled(controller, "StopProbe")).To(Equal([]string{"vcenter-browsing"}))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("restarts a prerequisite a running dependent still needs upon reconciliation", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter-browsing", "Moid1"))).To(Succeed())
+		Expect(controller.StopProbe("vcenter")).To(Succeed())
+		report, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Started).To(Equal([]string{"vcenter"}))
+		Expect(report.Stopped).To(BeEmpty())
+	})
+
+	It("refuses to pin off a probe a running dependent needs, and to start a probe whose prerequisite is pinned off",
+		func() {
+			Expect(m.AddOrUpdateTarget(newTarget("vcenter-browsing", "Moid1"))).To(Succeed())
+			err := m.SetOverrideMode("vcenter", target_registrar.OverrideForceDisabled)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("vcenter-browsing"))
+			Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+
+			Expect(m.SetOverrideMode("pure", target_registrar.OverrideForceDisabled)).To(Succeed())
+			err = m.AddOrUpdateTarget(newTarget("appdynamics", "App1"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("prerequisite pure is pinned off"))
+			Expect(getState(controller, "appdynamics")).NotTo(Equal(probe_controller.ProbeStateEnabled))
+		})
+
+	It("starts none of the prerequisites of a probe the probe budget cannot afford along with them", func() {
+		m.WithProbeBudget(2).WithManagedProbes("aws")
+		Expect(controller.StartProbe("aws")).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter-browsing", "Moid1"))).To(Succeed())
+		Expect(m.IsStarved("vcenter-browsing")).To(BeTrue())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateUnknown))
+		Expect(probesCalled(controller, "StartProbe")).To(Equal([]string{"aws"}))
+
+		// the probe and its prerequisites start together once the probe budget affords them
+		Expect(controller.StopProbe("aws")).To(Succeed())
+		report, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Started).To(Equal([]string{"vcenter-browsing"}))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.IsStarved("vcenter-browsing")).To(BeFalse())
+	})
+})
@@ -0,0 +1,145 @@
+package manager
+
+import (
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"k8s.io/klog"
+	"time"
+)
+
+// pendingStop is a stop of a probe scheduled upon its last target going away, due at the end of its grace period
+type pendingStop struct {
+	dueAt time.Time
+	timer *time.Timer
+}
+
+// WithDefaultStopGracePeriod sets the grace period before stopping a probe whose last target has gone away, for the
+// probe types without their own grace period.  The default is no grace period, i.e. the probe is stopped right away.
+func (m *ProbeLifecycleManager) WithDefaultStopGracePeriod(period time.Duration) *ProbeLifecycleManager {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	m.defaultStopGracePeriod = period
+	return m
+}
+
+// WithStopGracePeriod sets the grace period before stopping the probe of the given type once its last target has
+// gone away.  The stop is cancelled if a new target of the probe type is added within the grace period, sparing the
+// teardown and the restart of the probe when a target is deleted and added again, e.g. to fix a typo.
+func (m *ProbeLifecycleManager) WithStopGracePeriod(probeType string, period time.Duration) *ProbeLifecycleManager {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	m.stopGracePeriods[probeType] = period
+	return m
+}
+
+// stopGracePeriod returns the grace period before stopping the probe of the given type
+func (m *ProbeLifecycleManager) stopGracePeriod(probeType string) time.Duration {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	if period, found := m.stopGracePeriods[probeType]; found {
+		return period
+	}
+	return m.defaultStopGracePeriod
+}
+
+// GetPendingStop returns the time the probe of the given type is due to stop, and false if no stop is pending
+func (m *ProbeLifecycleManager) GetPendingStop(probeType string) (time.Time, bool) {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	if stop, found := m.pendingStops[probeType]; found {
+		return stop.dueAt, true
+	}
+	return time.Time{}, false
+}
+
+// ListPendingStops returns the time each probe with a pending stop is due to stop, keyed by the probe type
+func (m *ProbeLifecycleManager) ListPendingStops() map[string]time.Time {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	dueTimes := make(map[string]time.Time, len(m.pendingStops))
+	for probeType, stop := range m.pendingStops {
+		dueTimes[probeType] = stop.dueAt
+	}
+	return dueTimes
+}
+
+// scheduleStop schedules the stop of the probe at the end of the given grace period, replacing any stop pending
+func (m *ProbeLifecycleManager) scheduleStop(probeType string, period time.Duration) {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	if stop, found := m.pendingStops[probeType]; found {
+		stop.timer.Stop()
+	}
+	stop := &pendingStop{dueAt: time.Now().Add(period)}
+	stop.timer = time.AfterFunc(period, func() {
+		m.stopWhenDue(probeType, stop)
+	})
+	m.pendingStops[probeType] = stop
+	klog.Infof("Probe %v has no more targets; stopping it at %v unless a target is added",
+		probeType, stop.dueAt.Format(time.RFC3339))
+}
+
+// cancelPendingStop cancels the stop pending for the probe, if any, and returns whether there was one
+func (m *ProbeLifecycleManager) cancelPendingStop(probeType string) bool {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	stop, found := m.pendingStops[probeType]
+	if !found {
+		return false
+	}
+	stop.timer.Stop()
+	delete(m.pendingStops, probeType)
+	klog.Infof("Cancelled the pending stop of probe %v", probeType)
+	return true
+}
+
+// dropPendingStops stops the timers of all the pending stops and forgets them, upon the manager shutting down.  Their
+// stop intents stay in the outbox, to be replayed by the next run.
+func (m *ProbeLifecycleManager) dropPendingStops() {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	for probeType, stop := range m.pendingStops {
+		stop.timer.Stop()
+		delete(m.pendingStops, probeType)
+		klog.Infof("Dropped the pending stop of probe %v upon shutting down", probeType)
+	}
+}
+
+// takePendingStop removes the given stop from the pending stops, and returns false if it is no longer pending, i.e.
+// it has been cancelled or replaced since scheduled
+func (m *ProbeLifecycleManager) takePendingStop(probeType string, stop *pendingStop) bool {
+	m.stopLock.Lock()
+	defer m.stopLock.Unlock()
+	if m.pendingStops[probeType] != stop {
+		return false
+	}
+	delete(m.pendingStops, probeType)
+	return true
+}
+
+// stopWhenDue stops the probe at the end of its grace period, as long as the stop is still pending and the probe type
+// still has no target.  A failure is left to the reconciler and to the replay of the intents.
+func (m *ProbeLifecycleManager) stopWhenDue(probeType string, stop *pendingStop) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.takePendingStop(probeType, stop) {
+		return
+	}
+	count, err := m.CountProbeTargets(probeType)
+	if err != nil {
+		klog.Errorf("Failed to count the targets of probe %v before stopping it: %v", probeType, err)
+		return
+	}
+	if count > 0 {
+		m.completeIntent(probeType, outbox.ActionStopProbe)
+		return
+	}
+	stopped, err := m.stopProbe(probeType)
+	if err != nil {
+		klog.Errorf("Failed to stop probe %v at the end of its grace period: %v", probeType, err)
+		return
+	}
+	m.completeIntent(probeType, outbox.ActionStopProbe)
+	if stopped {
+		klog.Infof("Stopped probe %v at the end of its grace period", probeType)
+	}
+}
@@ -0,0 +1,106 @@
+package manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/fault_injection"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	outboxinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/outbox/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"time"
+)
+
+var _ = Describe("Test stop grace period", func() {
+	var (
+		controller   *probeinmemory.InMemoryProbeController
+		intentOutbox *outboxinmemory.InMemoryOutbox
+		m            *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		controller = probeinmemory.NewInMemoryProbeController(nil)
+		intentOutbox = outboxinmemory.NewInMemoryOutbox()
+		m = manager.NewProbeLifecycleManager(newRegistrar(nil), controller).WithOutbox(intentOutbox).
+			WithStopGracePeriod("vcenter", time.Hour)
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+	})
+
+	It("schedules the stop upon the last target going away, and cancels it upon a new target", func() {
+		before := time.Now()
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(controller.CallsTo("StopProbe")).To(BeEmpty())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		dueAt, pending := m.GetPendingStop("vcenter")
+		Expect(pending).To(BeTrue())
+		Expect(dueAt).To(BeTemporally(">=", before.Add(time.Hour)))
+		Expect(m.ListPendingStops()).To(Equal(map[string]time.Time{"vcenter": dueAt}))
+
+		status, err := m.GetProbeStatus("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.StopDueAt).To(Equal(&dueAt))
+		Expect(status.InSync()).To(BeTrue())
+		// the reconciler leaves the probe alone while its stop is pending
+		report, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Stopped).To(BeEmpty())
+
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		_, pending = m.GetPendingStop("vcenter")
+		Expect(pending).To(BeFalse())
+		Expect(controller.CallsTo("StartProbe")).To(HaveLen(1))
+		Expect(controller.CallsTo("StopProbe")).To(BeEmpty())
+		Expect(intentOutbox.List()).To(BeEmpty())
+	})
+
+	It("stops the probe at the end of its grace period", func() {
+		m.WithStopGracePeriod("vcenter", 20*time.Millisecond)
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		intents, err := intentOutbox.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(intents).To(HaveLen(1))
+		Expect(intents[0].Action).To(Equal(outbox.ActionStopProbe))
+
+		Eventually(func() probe_controller.ProbeState {
+			return getState(controller, "vcenter")
+		}).Should(Equal(probe_controller.ProbeStateDisabled))
+		Eventually(m.ListPendingStops).Should(BeEmpty())
+		Eventually(intentOutbox.List).Should(BeEmpty())
+	})
+
+	It("drops the pending stops upon shutting down, leaving their intents to the next run", func() {
+		m.WithStopGracePeriod("vcenter", 50*time.Millisecond).WithReconcileInterval(10 * time.Millisecond)
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		stopCh := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			m.Run(stopCh)
+			close(done)
+		}()
+		close(stopCh)
+		Eventually(done).Should(BeClosed())
+
+		Expect(m.ListPendingStops()).To(BeEmpty())
+		Consistently(func() []fault_injection.Call {
+			return controller.CallsTo("StopProbe")
+		}, 200*time.Millisecond).Should(BeEmpty())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		intents, err := intentOutbox.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(intents).To(HaveLen(1))
+		Expect(intents[0].Action).To(Equal(outbox.ActionStopProbe))
+	})
+
+	It("stops the probes without their own grace period right away, unless a default is set", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.DeleteTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateDisabled))
+
+		m.WithDefaultStopGracePeriod(time.Hour)
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.DeleteTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.ListPendingStops()).To(HaveKey("pure"))
+	})
+})
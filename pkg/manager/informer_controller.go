@@ -0,0 +1,208 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/t8c"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/k8s_secret"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog"
+	"time"
+)
+
+// DefaultInformerResyncPeriod is the default period at which the informers replay every watched object
+const DefaultInformerResyncPeriod = 10 * time.Minute
+
+// InformerController drives a ProbeLifecycleManager as a Kubernetes controller.  It watches the secrets keeping the
+// target info, the secret keeping the override modes and the XL custom resource through shared informers, and queues
+// the affected probe types in a rate-limited work queue.  The workers then start a probe when it has gained its first
+// target and stop it when it has lost its last one, regardless of who has changed the secrets.  Besides the secrets
+// labeled as managed by the registrar, the unlabeled secrets written by other components are noticed as well, as long
+// as the adoption policy of the registrar has it read their targets, e.g. k8s_secret.AdoptionPolicyAdoptLegacy for
+// the secrets named after their probe types.
+type InformerController struct {
+	manager        *ProbeLifecycleManager
+	queue          workqueue.RateLimitingInterface
+	secretFactory  informers.SharedInformerFactory
+	crFactory      dynamicinformer.DynamicSharedInformerFactory
+	secretInformer cache.SharedIndexInformer
+	crInformer     cache.SharedIndexInformer
+}
+
+// NewInformerControllerForConfig constructs an InformerController for the given manager and the input kubeconfig,
+// watching the objects in the given namespace
+func NewInformerControllerForConfig(manager *ProbeLifecycleManager, kubeConfig *rest.Config,
+	namespace string) (*InformerController, error) {
+	kubeClient, err := kubernetes.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kube client from config: %v", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dynamic client from config: %v", err)
+	}
+	v1beta1Client, err := v1beta1.NewForConfig(kubeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get apiextensions client from config: %v", err)
+	}
+	gvr, err := t8c.GetOrCreateGvr(v1beta1Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch the t8c XL resource\n%v", err)
+	}
+	return NewInformerControllerFromClient(manager, kubeClient, dynamicClient, *gvr, namespace), nil
+}
+
+// NewInformerControllerFromClient constructs an InformerController for the given manager and the clients, watching
+// the XL custom resource of the given GroupVersionResource and the secrets in the given namespace
+func NewInformerControllerFromClient(manager *ProbeLifecycleManager, kubeClient kubernetes.Interface,
+	dynamicClient dynamic.Interface, xlGvr schema.GroupVersionResource, namespace string) *InformerController {
+	c := &InformerController{
+		manager: manager,
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "probes"),
+		// the unlabeled secrets written by other components are watched too, so the secrets cannot be filtered by label
+		secretFactory: informers.NewSharedInformerFactoryWithOptions(kubeClient, DefaultInformerResyncPeriod,
+			informers.WithNamespace(namespace)),
+		crFactory: dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, DefaultInformerResyncPeriod,
+			namespace, nil),
+	}
+	c.secretInformer = c.secretFactory.Core().V1().Secrets().Informer()
+	c.secretInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: c.enqueueSecret,
+		// a secret losing its last target no longer looks like one keeping target info, and the overrides secret no
+		// longer keeps the override modes set back to auto; the old version tells
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.enqueueSecret(oldObj)
+			c.enqueueSecret(newObj)
+		},
+		DeleteFunc: c.enqueueSecret,
+	})
+	c.crInformer = c.crFactory.ForResource(xlGvr).Informer()
+	c.crInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { c.enqueueChangedProbes(nil, obj) },
+		UpdateFunc: c.enqueueChangedProbes,
+		DeleteFunc: func(obj interface{}) { c.enqueueChangedProbes(obj, nil) },
+	})
+	return c
+}
+
+// Run starts the informers and the given number of workers, and blocks until the stop channel is closed
+func (c *InformerController) Run(workers int, stopCh <-chan struct{}) error {
+	defer utilruntime.HandleCrash()
+	defer c.queue.ShutDown()
+
+	c.secretFactory.Start(stopCh)
+	c.crFactory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, c.secretInformer.HasSynced, c.crInformer.HasSynced) {
+		return fmt.Errorf("failed to wait for the secret and the XL resource caches to sync")
+	}
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+	<-stopCh
+	return nil
+}
+
+// adoptingRegistrar is a target registrar reading the targets of some of the secrets it has not created
+type adoptingRegistrar interface {
+	AdoptableProbeType(secret *apiv1.Secret) (string, bool)
+}
+
+// enqueueSecret queues the probe types needed by the targets whose info is kept in the given secret, or the probe types
+// whose override modes are kept in it, so that the override modes edited by hand take effect without a resync.  An
+// unlabeled secret is only taken for a secret keeping targets if the target registrar would read the targets in it.
+func (c *InformerController) enqueueSecret(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	secret, ok := obj.(*apiv1.Secret)
+	if !ok {
+		return
+	}
+	targetType, isTargetSecret := k8s_secret.ProbeTypeForSecret(secret)
+	if registrar, adopting := c.manager.targetRegistrar.(adoptingRegistrar); adopting && !isTargetSecret {
+		targetType, isTargetSecret = registrar.AdoptableProbeType(secret)
+	}
+	if isTargetSecret {
+		for _, probeType := range c.manager.ProbeTypesFor(targetType) {
+			c.queue.Add(probeType)
+		}
+	}
+	if overrides, isOverridesSecret := k8s_secret.OverrideModesForSecret(secret); isOverridesSecret {
+		for probeType := range overrides {
+			c.queue.Add(probeType)
+		}
+	}
+}
+
+// enqueueChangedProbes queues the probe types whose configuration differs between the old and the new version of
+// the XL custom resource; either version may be nil when the resource is added or deleted
+func (c *InformerController) enqueueChangedProbes(oldObj, newObj interface{}) {
+	oldCR, newCR := crFromObj(oldObj), crFromObj(newObj)
+	for probeType := range probeSpecs(oldCR) {
+		c.enqueueIfChanged(probeType, oldCR, newCR)
+	}
+	for probeType := range probeSpecs(newCR) {
+		c.enqueueIfChanged(probeType, oldCR, newCR)
+	}
+}
+
+// enqueueIfChanged queues the given probe type if its configuration differs between the two versions of the CR
+func (c *InformerController) enqueueIfChanged(probeType string, oldCR, newCR *unstructured.Unstructured) {
+	if !equality.Semantic.DeepEqual(probeSpecs(oldCR)[probeType], probeSpecs(newCR)[probeType]) {
+		c.queue.Add(probeType)
+	}
+}
+
+// crFromObj extracts the XL custom resource out of an informer event object
+func crFromObj(obj interface{}) *unstructured.Unstructured {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cr, _ := obj.(*unstructured.Unstructured)
+	return cr
+}
+
+// probeSpecs returns the spec section of the given XL custom resource keyed by the probe type
+func probeSpecs(cr *unstructured.Unstructured) map[string]interface{} {
+	if cr == nil {
+		return nil
+	}
+	spec, _, _ := unstructured.NestedMap(cr.Object, "spec")
+	return spec
+}
+
+// runWorker processes the queued probe types until the queue is shut down
+func (c *InformerController) runWorker() {
+	for c.processNextProbe() {
+	}
+}
+
+// processNextProbe reconciles the next queued probe type, requeuing it with rate limiting if it fails
+func (c *InformerController) processNextProbe() bool {
+	item, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(item)
+
+	probeType := item.(string)
+	if err := c.manager.syncProbe(probeType); err != nil {
+		klog.Errorf("Failed to sync probe %v; will retry: %v", probeType, err)
+		c.queue.AddRateLimited(item)
+		return true
+	}
+	c.queue.Forget(item)
+	return true
+}
@@ -0,0 +1,167 @@
+package manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/t8c"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/k8s_secret"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	testNamespace = "turbonomic"
+	testXlGvr     = schema.GroupVersionResource{Group: "charts.helm.k8s.io", Version: "v1alpha1", Resource: "xls"}
+)
+
+var _ = Describe("Test informer controller", func() {
+	var (
+		registrar     *in_memory.InMemoryRegistrar
+		controller    *probeinmemory.InMemoryProbeController
+		kubeClient    *fake.Clientset
+		dynamicClient *dynamicfake.FakeDynamicClient
+		stopCh        chan struct{}
+	)
+
+	BeforeEach(func() {
+		registrar = in_memory.NewInMemoryRegistrar()
+		controller = probeinmemory.NewInMemoryProbeController(nil)
+		kubeClient = fake.NewSimpleClientset()
+		dynamicClient = dynamicfake.NewSimpleDynamicClient(t8c.Scheme)
+		m := manager.NewProbeLifecycleManager(registrar, controller).WithManagedProbes("pure")
+		informerController := manager.NewInformerControllerFromClient(m, kubeClient, dynamicClient, testXlGvr, testNamespace)
+		stopCh = make(chan struct{})
+		go informerController.Run(1, stopCh)
+	})
+
+	AfterEach(func() {
+		close(stopCh)
+	})
+
+	It("starts a probe when a secret gains its first target and stops it when the last one is gone", func() {
+		registrar.RegisterTarget(newTarget("vcenter", "moid1"))
+		secret := &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "vcenter", Namespace: testNamespace, Labels: map[string]string{
+				k8s_secret.ManagedByLabel: k8s_secret.ManagedByValue, k8s_secret.ProbeTypeLabel: "vcenter"}},
+			StringData: map[string]string{"moid1": "target info"},
+		}
+		_, err := kubeClient.CoreV1().Secrets(testNamespace).Create(secret)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() probe_controller.ProbeState {
+			return getState(controller, "vcenter")
+		}).Should(Equal(probe_controller.ProbeStateEnabled))
+
+		registrar.UnregisterTarget(newTarget("vcenter", "moid1"))
+		err = kubeClient.CoreV1().Secrets(testNamespace).Delete("vcenter", &metav1.DeleteOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() probe_controller.ProbeState {
+			return getState(controller, "vcenter")
+		}).Should(Equal(probe_controller.ProbeStateDisabled))
+	})
+
+	It("stops a probe enabled by hand in the XL resource without any target", func() {
+		controller.StartProbe("pure")
+		controller.StartProbe("kubeturbo")
+		cr := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "charts.helm.k8s.io/v1alpha1",
+			"kind":       "Xl",
+			"metadata":   map[string]interface{}{"name": t8c.XlCrDefaultName, "namespace": testNamespace},
+			"spec": map[string]interface{}{
+				"pure":      map[string]interface{}{"enabled": true},
+				"kubeturbo": map[string]interface{}{"enabled": true},
+			},
+		}}
+		_, err := dynamicClient.Resource(testXlGvr).Namespace(testNamespace).Create(cr, metav1.CreateOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() probe_controller.ProbeState {
+			return getState(controller, "pure")
+		}).Should(Equal(probe_controller.ProbeStateDisabled))
+		// kubeturbo is another component of the platform, not a probe managed here
+		Expect(getState(controller, "kubeturbo")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+})
+
+var _ = Describe("Test informer controller with the secret registrar", func() {
+	DescribeTable("test an unlabeled secret written by another component",
+		func(policy k8s_secret.AdoptionPolicy, expectedState probe_controller.ProbeState) {
+			controller := probeinmemory.NewInMemoryProbeController(nil)
+			kubeClient := fake.NewSimpleClientset()
+			registrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(kubeClient.CoreV1(), testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			registrar.WithAdoptionPolicy(policy)
+			m := manager.NewProbeLifecycleManager(registrar, controller)
+			informerController := manager.NewInformerControllerFromClient(m, kubeClient,
+				dynamicfake.NewSimpleDynamicClient(t8c.Scheme), testXlGvr, testNamespace)
+			stopCh := make(chan struct{})
+			defer close(stopCh)
+			go informerController.Run(1, stopCh)
+
+			// another component keeps the bare target info in an unlabeled secret named after the probe type
+			bytes, err := target_registrar.UserPassTarget{Id: "moid1", Probetype: "vcenter", Username: "user",
+				Password: "pass"}.Bytes()
+			Expect(err).NotTo(HaveOccurred())
+			_, err = kubeClient.CoreV1().Secrets(testNamespace).Create(&apiv1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "vcenter", Namespace: testNamespace},
+				Type:       apiv1.SecretTypeOpaque,
+				Data:       map[string][]byte{"moid1": bytes},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() probe_controller.ProbeState {
+				return getState(controller, "vcenter")
+			}).Should(Equal(expectedState))
+			Consistently(func() probe_controller.ProbeState {
+				return getState(controller, "vcenter")
+			}, "200ms").Should(Equal(expectedState))
+		},
+		Entry("start the probe of a legacy secret upon legacy adoption", k8s_secret.AdoptionPolicyAdoptLegacy,
+			probe_controller.ProbeStateEnabled),
+		Entry("leave the probe alone by default", k8s_secret.AdoptionPolicyRefuse, probe_controller.ProbeStateUnknown),
+	)
+
+	It("applies the override modes edited by hand in the overrides secret", func() {
+		controller := probeinmemory.NewInMemoryProbeController(nil)
+		kubeClient := fake.NewSimpleClientset()
+		registrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(kubeClient.CoreV1(), testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		m := manager.NewProbeLifecycleManager(registrar, controller)
+		informerController := manager.NewInformerControllerFromClient(m, kubeClient,
+			dynamicfake.NewSimpleDynamicClient(t8c.Scheme), testXlGvr, testNamespace)
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go informerController.Run(1, stopCh)
+
+		Expect(controller.StartProbe("pure")).To(Succeed())
+		Expect(registrar.SetOverrideMode("pure", target_registrar.OverrideForceDisabled)).To(Succeed())
+		Eventually(func() probe_controller.ProbeState {
+			return getState(controller, "pure")
+		}).Should(Equal(probe_controller.ProbeStateDisabled))
+
+		secret, err := kubeClient.CoreV1().Secrets(testNamespace).Get(k8s_secret.OverridesSecretName,
+			metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		secret.Data[k8s_secret.EncodeDataKey("pure")] = []byte(`{"probeType":"pure","mode":"ForceEnabled"}`)
+		_, err = kubeClient.CoreV1().Secrets(testNamespace).Update(secret)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() probe_controller.ProbeState {
+			return getState(controller, "pure")
+		}).Should(Equal(probe_controller.ProbeStateEnabled))
+
+		// setting the probe back to auto removes it from the overrides secret, and stops it as it has no target
+		delete(secret.Data, k8s_secret.EncodeDataKey("pure"))
+		_, err = kubeClient.CoreV1().Secrets(testNamespace).Update(secret)
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(func() probe_controller.ProbeState {
+			return getState(controller, "pure")
+		}).Should(Equal(probe_controller.ProbeStateDisabled))
+	})
+})
@@ -0,0 +1,219 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"math"
+	"time"
+)
+
+// DefaultReplayBackoff is the default backoff between two passes replaying the pending intents: starting at a second
+// and doubling up to five minutes
+var DefaultReplayBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    math.MaxInt32,
+	Cap:      5 * time.Minute,
+}
+
+// WithOutbox sets the outbox to record the probe changes in before they are made, so that a change owed to the probe
+// controller survives a crash of the manager and is replayed by ReplayIntents
+func (m *ProbeLifecycleManager) WithOutbox(intentOutbox outbox.Outbox) *ProbeLifecycleManager {
+	m.intentOutbox = intentOutbox
+	return m
+}
+
+// WithReplayBackoff sets the backoff between two passes replaying the pending intents
+func (m *ProbeLifecycleManager) WithReplayBackoff(backoff wait.Backoff) *ProbeLifecycleManager {
+	m.replayBackoff = backoff
+	return m
+}
+
+// recordIntentIf records the intent of the given action on the probe in the outbox, if there is an outbox and the
+// given condition holds.  It returns whether the intent has been recorded.
+func (m *ProbeLifecycleManager) recordIntentIf(condition func() (bool, error), probeType string,
+	action outbox.Action) (bool, error) {
+	if m.intentOutbox == nil {
+		return false, nil
+	}
+	if needed, err := condition(); err != nil || !needed {
+		return false, err
+	}
+	intent := outbox.Intent{ProbeType: probeType, Action: action, RecordedAt: time.Now()}
+	if err := m.intentOutbox.Record(intent); err != nil {
+		return false, fmt.Errorf("failed to record intent %v of probe %v\n%v", action, probeType, err)
+	}
+	return true, nil
+}
+
+// recordIntentsIf records the intent of the given action on each of the given probes having the given number of
+// targets, if there is an outbox.  It returns the probe types whose intents have been recorded.  A failure forgets the
+// intents recorded so far.
+func (m *ProbeLifecycleManager) recordIntentsIf(probeTypes []string, count int,
+	action outbox.Action) (map[string]bool, error) {
+	recorded := map[string]bool{}
+	for _, probeType := range probeTypes {
+		probeType := probeType
+		ok, err := m.recordIntentIf(func() (bool, error) {
+			probeCount, err := m.CountProbeTargets(probeType)
+			return probeCount == count, err
+		}, probeType, action)
+		if err != nil {
+			m.completeIntents(recorded, action)
+			return nil, err
+		}
+		if ok {
+			recorded[probeType] = true
+		}
+	}
+	return recorded, nil
+}
+
+// completeIntents forgets the intents of the given action on the given probes
+func (m *ProbeLifecycleManager) completeIntents(probeTypes map[string]bool, action outbox.Action) {
+	for probeType := range probeTypes {
+		m.completeIntent(probeType, action)
+	}
+}
+
+// completeIntent forgets the intent of the given action on the probe, once made or no longer owed.  A failure is only
+// logged, as replaying an intent that has been made already is harmless.
+func (m *ProbeLifecycleManager) completeIntent(probeType string, action outbox.Action) {
+	if m.intentOutbox == nil {
+		return
+	}
+	if err := m.intentOutbox.Complete(probeType, action); err != nil {
+		klog.Errorf("Failed to complete intent %v of probe %v: %v", action, probeType, err)
+	}
+}
+
+// ReplayIntents replays the intents pending in the outbox, e.g. left behind by a crash, until the probe controller has
+// confirmed all of them or the stop channel is closed, backing off exponentially between two passes.  It returns
+// right away if no outbox is set.
+func (m *ProbeLifecycleManager) ReplayIntents(stopCh <-chan struct{}) {
+	if m.intentOutbox == nil {
+		return
+	}
+	backoff := m.replayBackoff
+	for {
+		pending, err := m.ReplayPendingIntents()
+		if err != nil {
+			klog.Errorf("Failed to replay the pending probe intents: %v", err)
+		}
+		if pending == 0 && err == nil {
+			return
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(backoff.Step()):
+		}
+	}
+}
+
+// ReplayPendingIntents runs a single pass over the intents pending in the outbox.  Each intent is made again unless
+// the probe controller reports it made already, and is forgotten once the probe controller confirms it.  An intent
+// that no longer agrees with the targets, e.g. recorded just before a registration that has not happened, or that is
+// overridden by a probe pinned on or off, or a start outside the maintenance windows of the probe, is stale and
+// forgotten as well.  The start of a starved probe stays pending until the probe starts from the queue of the starved
+// probes, as does the stop of a probe until the end of its grace period.  It returns the number of intents still
+// pending, i.e. neither completed nor dropped, and the aggregated failures of this pass.
+func (m *ProbeLifecycleManager) ReplayPendingIntents() (int, error) {
+	if m.intentOutbox == nil {
+		return 0, nil
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	intents, err := m.intentOutbox.List()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list the pending intents\n%v", err)
+	}
+	pending := 0
+	var errs []error
+	for _, intent := range intents {
+		stillPending, err := m.replayIntent(intent)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if stillPending {
+			pending++
+		}
+	}
+	return pending, utilerrors.NewAggregate(errs)
+}
+
+// replayIntent makes a single intent again if needed, and forgets it once the probe controller confirms it.  It
+// returns whether the intent is still pending.
+func (m *ProbeLifecycleManager) replayIntent(intent outbox.Intent) (bool, error) {
+	count, err := m.CountProbeTargets(intent.ProbeType)
+	if err != nil {
+		return true, err
+	}
+	mode, err := m.overrideMode(intent.ProbeType)
+	if err != nil {
+		return true, err
+	}
+	inWindow := m.windowOpen(intent.ProbeType, time.Now())
+	needed := count > 0 && inWindow
+	var neededBy []string
+	if !needed && mode == target_registrar.OverrideAuto {
+		// a prerequisite keeps running for its running dependents, the same way the reconciler keeps it
+		if neededBy, err = m.runningDependents(intent.ProbeType); err != nil {
+			return true, err
+		}
+		needed = len(neededBy) > 0
+	}
+	if (intent.Action == outbox.ActionStartProbe) != needed || mode != target_registrar.OverrideAuto {
+		klog.Infof("Dropping stale intent %v of probe %v with %d targets in override mode %v, within its windows: %v, "+
+			"needed by: %v", intent.Action, intent.ProbeType, count, mode, inWindow, neededBy)
+		m.completeIntent(intent.ProbeType, intent.Action)
+		return false, nil
+	}
+	if _, pending := m.GetPendingStop(intent.ProbeType); pending && intent.Action == outbox.ActionStopProbe {
+		// made at the end of the grace period of the probe
+		return true, nil
+	}
+	desiredState := probe_controller.ProbeStateEnabled
+	if intent.Action == outbox.ActionStopProbe {
+		desiredState = probe_controller.ProbeStateDisabled
+	}
+	if state, err := m.probeController.GetProbeState(intent.ProbeType); err != nil || state != desiredState {
+		var changed bool
+		if intent.Action == outbox.ActionStartProbe {
+			changed, err = m.startProbe(intent.ProbeType)
+		} else {
+			changed, err = m.stopProbe(intent.ProbeType)
+		}
+		if err != nil {
+			return true, fmt.Errorf("failed to replay intent %v of probe %v\n%v", intent.Action, intent.ProbeType,
+				err)
+		}
+		if !changed && intent.Action == outbox.ActionStartProbe {
+			// queued until a slot of the probe budget frees up, and completed once started from the queue
+			return true, nil
+		}
+		if !changed {
+			// deferred until the running dependents have stopped
+			m.completeIntent(intent.ProbeType, intent.Action)
+			return false, nil
+		}
+	}
+	state, err := m.probeController.GetProbeState(intent.ProbeType)
+	if err != nil {
+		return true, fmt.Errorf("failed to confirm intent %v of probe %v\n%v", intent.Action, intent.ProbeType, err)
+	}
+	if state != desiredState {
+		return true, fmt.Errorf("probe %v is still %v after replaying intent %v", intent.ProbeType, state,
+			intent.Action)
+	}
+	klog.Infof("Replayed intent %v of probe %v recorded at %v", intent.Action, intent.ProbeType, intent.RecordedAt)
+	m.completeIntent(intent.ProbeType, intent.Action)
+	return false, nil
+}
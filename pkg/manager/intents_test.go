@@ -0,0 +1,149 @@
+package manager_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/dependency"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	outboxinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/outbox/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/in_memory"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"time"
+)
+
+var _ = Describe("Test probe intents", func() {
+	var (
+		registrar    *in_memory.InMemoryRegistrar
+		controller   *probeinmemory.InMemoryProbeController
+		intentOutbox *outboxinmemory.InMemoryOutbox
+		m            *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		registrar = newRegistrar(map[string]int{"vcenter": 1})
+		controller = probeinmemory.NewInMemoryProbeController(nil)
+		intentOutbox = outboxinmemory.NewInMemoryOutbox()
+		m = manager.NewProbeLifecycleManager(registrar, controller).WithOutbox(intentOutbox)
+	})
+
+	It("records the intent before registering the first target, and completes it once the probe has started", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(intentOutbox.CallsTo("Record")).To(HaveLen(1))
+		Expect(registrar.CallsTo("RegisterTarget")).To(HaveLen(1))
+		Expect(intentOutbox.List()).To(BeEmpty())
+
+		// no intent is owed upon a target that is not the first
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid2"))).To(Succeed())
+		Expect(intentOutbox.CallsTo("Record")).To(HaveLen(1))
+	})
+
+	It("leaves the intent pending when the probe controller fails, and replays it", func() {
+		controller.FailNthCall("StartProbe", 1, fmt.Errorf("xl resource is gone"))
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).NotTo(Succeed())
+		intents, err := intentOutbox.List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(intents).To(HaveLen(1))
+		Expect(intents[0].ProbeType).To(Equal("pure"))
+		Expect(intents[0].Action).To(Equal(outbox.ActionStartProbe))
+
+		pending, err := m.ReplayPendingIntents()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(Equal(0))
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(intentOutbox.List()).To(BeEmpty())
+	})
+
+	It("replays the intents left behind by a crash, and drops the stale ones", func() {
+		// a crash after unregistering the last target of pure, and one before registering the first of appdynamics
+		Expect(intentOutbox.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe})).To(Succeed())
+		Expect(intentOutbox.Record(outbox.Intent{ProbeType: "pure", Action: outbox.ActionStopProbe})).To(Succeed())
+		Expect(intentOutbox.Record(outbox.Intent{ProbeType: "appdynamics",
+			Action: outbox.ActionStartProbe})).To(Succeed())
+		Expect(controller.StartProbe("pure")).To(Succeed())
+		controller.ResetCalls()
+
+		pending, err := m.ReplayPendingIntents()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(Equal(0))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateDisabled))
+		Expect(getState(controller, "appdynamics")).To(Equal(probe_controller.ProbeStateUnknown))
+		Expect(intentOutbox.List()).To(BeEmpty())
+	})
+
+	It("keeps replaying with backoff until the probe controller confirms the intents", func() {
+		Expect(intentOutbox.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe})).To(Succeed())
+		controller.FailNthCall("StartProbe", 1, fmt.Errorf("xl resource is gone"))
+		controller.FailNthCall("StartProbe", 2, fmt.Errorf("xl resource is gone"))
+		m.WithReplayBackoff(wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 10})
+
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		done := make(chan struct{})
+		go func() {
+			m.ReplayIntents(stopCh)
+			close(done)
+		}()
+		Eventually(done).Should(BeClosed())
+		Expect(controller.CallsTo("StartProbe")).To(HaveLen(3))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(intentOutbox.List()).To(BeEmpty())
+	})
+
+	It("counts the stop owed at the end of the grace period as pending", func() {
+		m.WithStopGracePeriod("vcenter", time.Hour)
+		Expect(controller.StartProbe("vcenter")).To(Succeed())
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+
+		pending, err := m.ReplayPendingIntents()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(Equal(1))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(intentOutbox.List()).To(HaveLen(1))
+	})
+
+	It("keeps the start of a starved probe pending until it starts from the queue", func() {
+		m.WithProbeBudget(1).WithManagedProbes("vcenter")
+		Expect(controller.StartProbe("vcenter")).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.IsStarved("pure")).To(BeTrue())
+		Expect(intentOutbox.List()).To(HaveLen(1))
+
+		// as after a crash forgetting the queue of the starved probes, the replay queues the probe again
+		pending, err := m.ReplayPendingIntents()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(Equal(1))
+		Expect(m.IsStarved("pure")).To(BeTrue())
+		Expect(intentOutbox.List()).To(HaveLen(1))
+
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(intentOutbox.List()).To(BeEmpty())
+	})
+
+	It("replays the start of a prerequisite without targets of its own that a running probe needs", func() {
+		graph, err := dependency.NewGraph(map[string][]string{"appdynamics": {"pure"}})
+		Expect(err).NotTo(HaveOccurred())
+		m.WithDependencies(graph)
+		// a crash after starting appdynamics, before starting pure it depends on
+		Expect(controller.StartProbe("appdynamics")).To(Succeed())
+		Expect(intentOutbox.Record(outbox.Intent{ProbeType: "pure", Action: outbox.ActionStartProbe})).To(Succeed())
+
+		pending, err := m.ReplayPendingIntents()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pending).To(Equal(0))
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(intentOutbox.List()).To(BeEmpty())
+	})
+
+	It("refuses to register a target if the intent cannot be recorded", func() {
+		intentOutbox.FailAllCalls("Record", fmt.Errorf("outbox is down"))
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).NotTo(Succeed())
+		Expect(registrar.CallsTo("RegisterTarget")).To(BeEmpty())
+		Expect(controller.CallsTo("StartProbe")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/schedule"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"sort"
+	"time"
+)
+
+// DefaultScheduleInterval is the default period between two checks of the maintenance windows, matching the one-minute
+// resolution of the cron expressions
+const DefaultScheduleInterval = time.Minute
+
+// WithSchedule restricts the probe of the given type to the maintenance windows of the given schedule: the probe runs
+// only while a window is open and the probe type has targets.  A nil schedule lifts the restriction.  An override mode
+// other than Auto takes precedence over the schedule.
+func (m *ProbeLifecycleManager) WithSchedule(probeType string,
+	probeSchedule *schedule.Schedule) *ProbeLifecycleManager {
+	m.scheduleLock.Lock()
+	defer m.scheduleLock.Unlock()
+	if probeSchedule == nil {
+		delete(m.schedules, probeType)
+	} else {
+		m.schedules[probeType] = probeSchedule
+	}
+	return m
+}
+
+// WithScheduleInterval sets the period between two checks of the maintenance windows when running the manager
+func (m *ProbeLifecycleManager) WithScheduleInterval(interval time.Duration) *ProbeLifecycleManager {
+	m.scheduleLock.Lock()
+	defer m.scheduleLock.Unlock()
+	m.scheduleInterval = interval
+	return m
+}
+
+// GetNextTransition returns the time a maintenance window of the probe of the given type next opens or closes, and
+// false if the probe has no schedule or its schedule never changes again
+func (m *ProbeLifecycleManager) GetNextTransition(probeType string) (time.Time, bool) {
+	probeSchedule := m.getSchedule(probeType)
+	if probeSchedule == nil {
+		return time.Time{}, false
+	}
+	return probeSchedule.NextTransition(time.Now())
+}
+
+// RunSchedules checks the maintenance windows periodically until the stop channel is closed, starting a probe with
+// targets when one of its windows opens and stopping it when its windows close
+func (m *ProbeLifecycleManager) RunSchedules(stopCh <-chan struct{}) {
+	m.scheduleLock.Lock()
+	interval := m.scheduleInterval
+	m.scheduleLock.Unlock()
+	if interval <= 0 {
+		interval = DefaultScheduleInterval
+	}
+	wait.Until(m.applySchedules, interval, stopCh)
+}
+
+// applySchedules brings every probe with a schedule in line with its maintenance windows
+func (m *ProbeLifecycleManager) applySchedules() {
+	for _, probeType := range m.scheduledProbeTypes() {
+		if err := m.syncProbe(probeType); err != nil {
+			klog.Errorf("Failed to apply the maintenance windows of probe %v: %v", probeType, err)
+		}
+	}
+}
+
+// windowOpen returns true if the probe of the given type has no schedule, or one of its windows is open at the given
+// time
+func (m *ProbeLifecycleManager) windowOpen(probeType string, t time.Time) bool {
+	probeSchedule := m.getSchedule(probeType)
+	return probeSchedule == nil || probeSchedule.IsOpen(t)
+}
+
+// getSchedule returns the schedule of the probe of the given type, or nil if it has none
+func (m *ProbeLifecycleManager) getSchedule(probeType string) *schedule.Schedule {
+	m.scheduleLock.Lock()
+	defer m.scheduleLock.Unlock()
+	return m.schedules[probeType]
+}
+
+// scheduledProbeTypes returns the sorted probe types with a schedule
+func (m *ProbeLifecycleManager) scheduledProbeTypes() []string {
+	m.scheduleLock.Lock()
+	defer m.scheduleLock.Unlock()
+	probeTypes := make([]string, 0, len(m.schedules))
+	for probeType := range m.schedules {
+		probeTypes = append(probeTypes, probeType)
+	}
+	sort.Strings(probeTypes)
+	return probeTypes
+}
@@ -0,0 +1,99 @@
+package manager_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/schedule"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"time"
+)
+
+// newDailySchedule constructs a schedule with a window opening every day at the given time, for the given duration
+func newDailySchedule(start time.Time, duration time.Duration) *schedule.Schedule {
+	utc := start.UTC()
+	s, err := schedule.NewSchedule(schedule.Window{
+		Start:    fmt.Sprintf("CRON_TZ=UTC %d %d * * *", utc.Minute(), utc.Hour()),
+		Duration: duration,
+	})
+	Expect(err).NotTo(HaveOccurred())
+	return s
+}
+
+var _ = Describe("Test maintenance windows", func() {
+	var (
+		controller *probeinmemory.InMemoryProbeController
+		m          *manager.ProbeLifecycleManager
+		// open has a window open since a minute ago for two hours; closed has a window opening in two hours
+		open, closed *schedule.Schedule
+	)
+
+	BeforeEach(func() {
+		controller = probeinmemory.NewInMemoryProbeController(nil)
+		m = manager.NewProbeLifecycleManager(newRegistrar(nil), controller)
+		open = newDailySchedule(time.Now().Add(-time.Minute), 2*time.Hour)
+		closed = newDailySchedule(time.Now().Add(2*time.Hour), time.Hour)
+	})
+
+	It("starts a probe upon its first target only within its windows", func() {
+		m.WithSchedule("vcenter", open).WithSchedule("pure", closed)
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(getState(controller, "pure")).NotTo(Equal(probe_controller.ProbeStateEnabled))
+
+		status, err := m.GetProbeStatus("pure")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.OutsideWindow).To(BeTrue())
+		Expect(status.NextTransition).NotTo(BeNil())
+		Expect(*status.NextTransition).To(BeTemporally("~", time.Now().Add(2*time.Hour), time.Minute))
+		Expect(status.InSync()).To(BeTrue())
+
+		status, err = m.GetProbeStatus("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.OutsideWindow).To(BeFalse())
+		Expect(*status.NextTransition).To(BeTemporally("~", time.Now().Add(2*time.Hour-time.Minute), time.Minute))
+		next, found := m.GetNextTransition("vcenter")
+		Expect(found).To(BeTrue())
+		Expect(next).To(Equal(*status.NextTransition))
+	})
+
+	It("starts and stops the probes as their windows open and close", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(controller.StopProbe("pure")).To(Succeed())
+		m.WithSchedule("vcenter", closed).WithSchedule("pure", open).WithScheduleInterval(10 * time.Millisecond)
+
+		stopCh := make(chan struct{})
+		defer close(stopCh)
+		go m.RunSchedules(stopCh)
+		Eventually(func() probe_controller.ProbeState {
+			return getState(controller, "vcenter")
+		}).Should(Equal(probe_controller.ProbeStateDisabled))
+		Eventually(func() probe_controller.ProbeState {
+			return getState(controller, "pure")
+		}).Should(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("stops a probe outside its windows upon reconciliation, unless pinned on", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.SetOverrideMode("pure", target_registrar.OverrideForceEnabled)).To(Succeed())
+		m.WithSchedule("vcenter", closed).WithSchedule("pure", closed)
+
+		report, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Stopped).To(ConsistOf("vcenter"))
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+
+		m.WithSchedule("vcenter", nil)
+		report, err = m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Started).To(ConsistOf("vcenter"))
+		_, found := m.GetNextTransition("vcenter")
+		Expect(found).To(BeFalse())
+	})
+})
@@ -0,0 +1,30 @@
+package manager
+
+// WithManagedProbes declares the given probe types as managed, so that the reconciler stops them when they have no
+// targets even if the manager has never seen any of their targets, e.g. after a restart.  The probe types the target
+// registrar holds targets or override modes for, the ones the probe mapping maps a target type to, and the ones the
+// manager has registered or unregistered targets for are managed as well.  Any other entry of the XL custom resource,
+// such as kubeturbo, grafana or ingress, is not a probe of this manager, and is neither started nor stopped.
+func (m *ProbeLifecycleManager) WithManagedProbes(probeTypes ...string) *ProbeLifecycleManager {
+	m.manageProbes(probeTypes)
+	return m
+}
+
+// IsManagedProbe returns true if the probe of the given type is owned by this manager
+func (m *ProbeLifecycleManager) IsManagedProbe(probeType string) bool {
+	if _, mapped := m.targetTypeMapping[probeType]; mapped {
+		return true
+	}
+	m.managedLock.Lock()
+	defer m.managedLock.Unlock()
+	return m.managedProbes[probeType]
+}
+
+// manageProbes adds the given probe types to the probes owned by this manager
+func (m *ProbeLifecycleManager) manageProbes(probeTypes []string) {
+	m.managedLock.Lock()
+	defer m.managedLock.Unlock()
+	for _, probeType := range probeTypes {
+		m.managedProbes[probeType] = true
+	}
+}
@@ -0,0 +1,96 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+)
+
+// SetOverrideMode pins the probe of the given type on or off regardless of its targets, or lets it follow its targets
+// again with target_registrar.OverrideAuto.  The mode is kept by the target registrar next to the targets, which must
+// implement target_registrar.OverrideRegistrar, and the probe is brought in line with it right away.  A probe cannot be
+// pinned off while a running probe depends on it.
+func (m *ProbeLifecycleManager) SetOverrideMode(probeType string, mode target_registrar.OverrideMode) error {
+	if err := target_registrar.ValidateOverrideMode(mode); err != nil {
+		return err
+	}
+	overrideRegistrar, ok := m.targetRegistrar.(target_registrar.OverrideRegistrar)
+	if !ok {
+		return fmt.Errorf("the target registrar %T cannot keep the override modes of the probes", m.targetRegistrar)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if mode == target_registrar.OverrideForceDisabled {
+		dependents, err := m.runningDependents(probeType)
+		if err != nil {
+			return err
+		}
+		if len(dependents) > 0 {
+			return fmt.Errorf("cannot pin off probe %v while running probes %v depend on it", probeType, dependents)
+		}
+	}
+	if err := overrideRegistrar.SetOverrideMode(probeType, mode); err != nil {
+		return err
+	}
+	if mode != target_registrar.OverrideAuto && m.cancelPendingStop(probeType) {
+		m.completeIntent(probeType, outbox.ActionStopProbe)
+	}
+	state, err := m.probeController.GetProbeState(probeType)
+	if err != nil {
+		return fmt.Errorf("failed to get the state of probe %v from the probe controller\n%v", probeType, err)
+	}
+	if err := m.reconcileProbe(probeType, state, &ReconcileReport{Failed: map[string]error{}}); err != nil {
+		return err
+	}
+	// a slot may have freed up outside the manager, e.g. a probe stopped by hand
+	m.startStarvedProbes()
+	return nil
+}
+
+// GetOverrideMode returns the override mode of the given probe type, which is target_registrar.OverrideAuto unless
+// set otherwise
+func (m *ProbeLifecycleManager) GetOverrideMode(probeType string) (target_registrar.OverrideMode, error) {
+	return m.overrideMode(probeType)
+}
+
+// ListOverrideModes returns the override modes other than target_registrar.OverrideAuto, keyed by the probe type
+func (m *ProbeLifecycleManager) ListOverrideModes() (map[string]target_registrar.OverrideMode, error) {
+	overrideRegistrar, ok := m.targetRegistrar.(target_registrar.OverrideRegistrar)
+	if !ok {
+		return map[string]target_registrar.OverrideMode{}, nil
+	}
+	return overrideRegistrar.ListOverrideModes()
+}
+
+// overrideMode returns the override mode of the given probe type, which is always target_registrar.OverrideAuto if
+// the target registrar cannot keep override modes
+func (m *ProbeLifecycleManager) overrideMode(probeType string) (target_registrar.OverrideMode, error) {
+	overrideRegistrar, ok := m.targetRegistrar.(target_registrar.OverrideRegistrar)
+	if !ok {
+		return target_registrar.OverrideAuto, nil
+	}
+	mode, err := overrideRegistrar.GetOverrideMode(probeType)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the override mode of probe %v\n%v", probeType, err)
+	}
+	return mode, nil
+}
+
+// shouldRun returns true if a probe in the given override mode with the given number of targets should be enabled,
+// given whether one of its maintenance windows is open
+func shouldRun(mode target_registrar.OverrideMode, count int, inWindow bool) bool {
+	switch mode {
+	case target_registrar.OverrideForceEnabled:
+		return true
+	case target_registrar.OverrideForceDisabled:
+		return false
+	}
+	return count > 0 && inWindow
+}
+
+// inSync returns true if a probe in the given state is in line with its override mode, its number of targets and its
+// maintenance windows
+func inSync(state probe_controller.ProbeState, mode target_registrar.OverrideMode, count int, inWindow bool) bool {
+	return shouldRun(mode, count, inWindow) == (state == probe_controller.ProbeStateEnabled)
+}
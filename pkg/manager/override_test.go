@@ -0,0 +1,100 @@
+package manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/in_memory"
+)
+
+var _ = Describe("Test probe override modes", func() {
+	var (
+		registrar  *in_memory.InMemoryRegistrar
+		controller *probeinmemory.InMemoryProbeController
+		m          *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		registrar = newRegistrar(map[string]int{"vcenter": 1})
+		controller = probeinmemory.NewInMemoryProbeController(map[string]probe_controller.ProbeState{
+			"vcenter": probe_controller.ProbeStateEnabled,
+		})
+		m = manager.NewProbeLifecycleManager(registrar, controller)
+	})
+
+	It("keeps a probe pinned on running without targets", func() {
+		Expect(m.SetOverrideMode("pure", target_registrar.OverrideForceEnabled)).To(Succeed())
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.GetOverrideMode("pure")).To(Equal(target_registrar.OverrideForceEnabled))
+
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(m.DeleteTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(controller.CallsTo("StartProbe")).To(HaveLen(1))
+		Expect(controller.CallsTo("StopProbe")).To(BeEmpty())
+
+		status, err := m.GetProbeStatus("pure")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Override).To(Equal(target_registrar.OverrideForceEnabled))
+		Expect(status.TargetCount).To(BeZero())
+		Expect(status.InSync()).To(BeTrue())
+	})
+
+	It("keeps a probe pinned off stopped with targets", func() {
+		Expect(m.SetOverrideMode("vcenter", target_registrar.OverrideForceDisabled)).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateDisabled))
+
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid2"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateDisabled))
+		Expect(controller.CallsTo("StartProbe")).To(BeEmpty())
+
+		status, err := m.GetProbeStatus("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.Override).To(Equal(target_registrar.OverrideForceDisabled))
+		Expect(status.TargetCount).To(Equal(2))
+		Expect(status.InSync()).To(BeTrue())
+	})
+
+	It("reconciles the probes to their override modes", func() {
+		Expect(m.SetOverrideMode("vcenter", target_registrar.OverrideForceDisabled)).To(Succeed())
+		Expect(m.SetOverrideMode("pure", target_registrar.OverrideForceEnabled)).To(Succeed())
+		// the probes drift away from their override modes behind the back of the manager
+		Expect(controller.StartProbe("vcenter")).To(Succeed())
+		Expect(controller.StopProbe("pure")).To(Succeed())
+
+		report, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Started).To(ConsistOf("pure"))
+		Expect(report.Stopped).To(ConsistOf("vcenter"))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateDisabled))
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("lets the probe follow its targets again in the automatic mode", func() {
+		Expect(m.SetOverrideMode("vcenter", target_registrar.OverrideForceDisabled)).To(Succeed())
+		Expect(m.SetOverrideMode("pure", target_registrar.OverrideForceEnabled)).To(Succeed())
+
+		Expect(m.SetOverrideMode("vcenter", target_registrar.OverrideAuto)).To(Succeed())
+		Expect(m.SetOverrideMode("pure", target_registrar.OverrideAuto)).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateDisabled))
+		Expect(m.ListOverrideModes()).To(BeEmpty())
+	})
+
+	It("keeps the override modes in the target registrar", func() {
+		Expect(m.SetOverrideMode("vcenter", target_registrar.OverrideForceDisabled)).To(Succeed())
+		Expect(registrar.GetOverrideMode("vcenter")).To(Equal(target_registrar.OverrideForceDisabled))
+		restarted := manager.NewProbeLifecycleManager(registrar, controller)
+		Expect(restarted.ListOverrideModes()).To(Equal(map[string]target_registrar.OverrideMode{
+			"vcenter": target_registrar.OverrideForceDisabled,
+		}))
+	})
+
+	It("rejects an unknown override mode", func() {
+		Expect(m.SetOverrideMode("vcenter", "Sometimes")).To(HaveOccurred())
+		Expect(registrar.CallsTo("SetOverrideMode")).To(BeEmpty())
+	})
+})
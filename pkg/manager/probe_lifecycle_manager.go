@@ -2,17 +2,58 @@ package manager
 
 import (
 	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/dependency"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
 	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
 	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/t8c"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/schedule"
 	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
 	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/k8s_secret"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
+	"sync"
+	"time"
 )
 
 // ProbeLifecycleManager manages probe life cycles
 type ProbeLifecycleManager struct {
 	targetRegistrar target_registrar.Registrar
 	probeController probe_controller.ProbeController
+	// reconcileInterval is the period between two reconciliation passes when running the reconciler
+	reconcileInterval time.Duration
+	// lock serializes the changes to the probe states made by the API calls and the reconciler
+	lock sync.Mutex
+	// transactional tells whether to roll back the changes to the target registrar the probe controller fails to follow
+	transactional bool
+	// intentOutbox, if set, records the probe changes before they are made so that they can be replayed after a crash
+	intentOutbox outbox.Outbox
+	// replayBackoff is the backoff between two passes replaying the pending intents
+	replayBackoff wait.Backoff
+	// stopLock guards the grace periods and the pending stops below, which the status reads without taking the lock
+	stopLock               sync.Mutex
+	defaultStopGracePeriod time.Duration
+	stopGracePeriods       map[string]time.Duration
+	pendingStops           map[string]*pendingStop
+	// scheduleLock guards the maintenance windows of the probes and the period between two checks of them
+	scheduleLock     sync.Mutex
+	schedules        map[string]*schedule.Schedule
+	scheduleInterval time.Duration
+	// dependencies, if set, orders the starts of the probes after their prerequisites, and defers the stops of the
+	// probes still needed by running dependents
+	dependencies *dependency.Graph
+	// probeMapping maps each target type to the probe types its targets need, and targetTypeMapping the other way round;
+	// a target type left out is mapped to the probe of the same name
+	probeMapping      map[string][]string
+	targetTypeMapping map[string][]string
+	// budgetLock guards the probe budget, the priorities of the probes and the starved probes keyed by the time queued
+	budgetLock      sync.Mutex
+	probeBudget     int
+	probePriorities map[string]int
+	starvedProbes   map[string]time.Time
+	// managedLock guards the probe types owned by this manager besides the ones mapped to by the probe mapping; the
+	// reconciler never starts or stops any other entry of the XL custom resource
+	managedLock   sync.Mutex
+	managedProbes map[string]bool
 }
 
 // DefaultProbeManagerForConfig constructs a default probe lifecycle manager using k8s secrets to keep target info and
@@ -27,29 +68,274 @@ func DefaultProbeManagerForConfig(kubeConfig *rest.Config, namespace string) (*P
 	if err != nil {
 		return nil, fmt.Errorf("failed to construct a probe controller: %v\n", err)
 	}
-	return &ProbeLifecycleManager{targetRegistrar: target_registrar, probeController: probeController}, nil
+	return NewProbeLifecycleManager(target_registrar, probeController), nil
 }
 
-// AddOrUpdateTarget adds or updates the given target and starts the probe if not already started
+// NewProbeLifecycleManager constructs a probe lifecycle manager keeping the target info with the given target
+// registrar and controlling the probes with the given probe controller
+func NewProbeLifecycleManager(targetRegistrar target_registrar.Registrar,
+	probeController probe_controller.ProbeController) *ProbeLifecycleManager {
+	return &ProbeLifecycleManager{
+		targetRegistrar:   targetRegistrar,
+		probeController:   probeController,
+		reconcileInterval: DefaultReconcileInterval,
+		replayBackoff:     DefaultReplayBackoff,
+		stopGracePeriods:  map[string]time.Duration{},
+		pendingStops:      map[string]*pendingStop{},
+		schedules:         map[string]*schedule.Schedule{},
+		scheduleInterval:  DefaultScheduleInterval,
+		probePriorities:   map[string]int{},
+		starvedProbes:     map[string]time.Time{},
+		managedProbes:     map[string]bool{},
+	}
+}
+
+// ValidateTarget checks the given target against both its own validation and the constraints of the target registrar.
+// It returns target_registrar.ValidationErrors listing every invalid field, or nil if the target is valid.
+func (m *ProbeLifecycleManager) ValidateTarget(target target_registrar.Target) error {
+	if err := target_registrar.ValidateTarget(target); err != nil {
+		return err
+	}
+	return m.validateForRegistrar(target)
+}
+
+// validateTargetKey checks the probe type and the id of the given target against the constraints of the target
+// registrar, leaving the own validation of the target alone, so that a target can be deleted by its key only
+func (m *ProbeLifecycleManager) validateTargetKey(target target_registrar.Target) error {
+	if err := target_registrar.ValidateTargetKey(target); err != nil {
+		return err
+	}
+	return m.validateForRegistrar(target)
+}
+
+// validateForRegistrar checks the given target against the constraints of the target registrar, if it has any
+func (m *ProbeLifecycleManager) validateForRegistrar(target target_registrar.Target) error {
+	if validatingRegistrar, ok := m.targetRegistrar.(target_registrar.ValidatingRegistrar); ok {
+		return validatingRegistrar.ValidateTarget(target)
+	}
+	return nil
+}
+
+// AddOrUpdateTarget adds or updates the given target, and starts each probe it needs if the target is the first of the
+// probe across the target types mapped to it, unless the probe is pinned on or off by its override mode or is outside
+// its maintenance windows.  An update or a repeated registration leaves the probes alone.  A stop of a probe pending at
+// the end of its grace period is cancelled.  An invalid target is rejected with target_registrar.ValidationErrors
+// before reaching the target registrar.  In the transactional mode, the target is unregistered again, or its previous
+// info restored, if a probe fails to start or the targets of a probe cannot be counted to tell whether to start it.
+// With an outbox, the start of each probe is recorded as owed before the target is registered, and stays pending until
+// the probe has started.
 func (m *ProbeLifecycleManager) AddOrUpdateTarget(target target_registrar.Target) error {
-	isFirstTarget, err := m.targetRegistrar.RegisterTarget(target)
+	if err := m.ValidateTarget(target); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, err := m.addOrUpdateTarget(target)
+	return err
+}
+
+// addOrUpdateTarget registers the given target and starts the probes it needs, with the lock held, and returns the
+// result of the registration
+func (m *ProbeLifecycleManager) addOrUpdateTarget(target target_registrar.Target) (target_registrar.RegistrationResult,
+	error) {
+	targetType := target.GetProbeType()
+	m.manageProbes(m.ProbeTypesFor(targetType))
+	previousTarget, err := m.getTargetToRestore(target)
 	if err != nil {
-		return fmt.Errorf("failed to register target %v\n%v", target, err)
+		return target_registrar.RegistrationResult{}, err
 	}
-	if !isFirstTarget {
-		return nil
+	// a probe pinned by its override mode, or outside its maintenance windows, is not started by a new target
+	probeTypes, err := m.probeTypesFollowing(targetType, func(probeType string, mode target_registrar.OverrideMode) bool {
+		return mode == target_registrar.OverrideAuto && m.windowOpen(probeType, time.Now())
+	})
+	if err != nil {
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to register target %v\n%v",
+			target_registrar.SafeString(target), err)
+	}
+	recorded, err := m.recordIntentsIf(probeTypes, 0, outbox.ActionStartProbe)
+	if err != nil {
+		return target_registrar.RegistrationResult{}, err
 	}
-	return m.probeController.StartProbe(target.GetProbeType())
+	result, err := m.targetRegistrar.RegisterTarget(target)
+	if err != nil {
+		m.completeIntents(recorded, outbox.ActionStartProbe)
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to register target %v\n%v",
+			target_registrar.SafeString(target), err)
+	}
+	for _, probeType := range m.ProbeTypesFor(targetType) {
+		if m.cancelPendingStop(probeType) {
+			m.completeIntent(probeType, outbox.ActionStopProbe)
+		}
+	}
+	for _, probeType := range probeTypes {
+		first := false
+		if result.IsFirstTarget() {
+			others, err := m.countTargetsExcept(probeType, targetType)
+			if err != nil {
+				return result, m.rollback(target, previousTarget, probeTypes, nil, outbox.ActionStartProbe, err)
+			}
+			first = others == 0
+		}
+		if !first {
+			if recorded[probeType] {
+				m.completeIntent(probeType, outbox.ActionStartProbe)
+			}
+			continue
+		}
+		// a starved probe is started once a slot of the probe budget frees up, its start intent pending until then
+		started, err := m.startProbe(probeType)
+		if err != nil {
+			return result, m.rollback(target, previousTarget, probeTypes, nil, outbox.ActionStartProbe, err)
+		}
+		if started {
+			m.completeIntent(probeType, outbox.ActionStartProbe)
+		}
+	}
+	return result, nil
 }
 
-// DeleteTarget deletes the given target and stops the probe if it has no more targets
+// DeleteTarget deletes the given target, and stops each probe it needs if the target was the last of the probe across
+// the target types mapped to it, either right away or, if the probe type has a grace period, at the end of the grace
+// period.  A probe pinned on or off by its override mode is left alone, as are the probes after deleting a target that
+// is not registered.  In the transactional mode, the target is registered again if a probe fails to stop right away, or
+// if the targets of a probe cannot be counted to tell whether to stop it.
+// With an outbox, the stop of each probe is recorded as owed before the target is unregistered, and stays pending until
+// the probe has stopped.  Only the probe type and the id of the target are needed: a target whose probe type or id is
+// missing or invalid is rejected with target_registrar.ValidationErrors before reaching the target registrar.
 func (m *ProbeLifecycleManager) DeleteTarget(target target_registrar.Target) error {
-	isLastTarget, err := m.targetRegistrar.UnregisterTarget(target)
-	if err != nil {
+	if err := m.validateTargetKey(target); err != nil {
 		return err
 	}
-	if !isLastTarget {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, err := m.deleteTarget(target)
+	return err
+}
+
+// deleteTarget unregisters the given target and stops the probes it needs, with the lock held, and returns the
+// result of the unregistration
+func (m *ProbeLifecycleManager) deleteTarget(target target_registrar.Target) (target_registrar.RegistrationResult,
+	error) {
+	targetType := target.GetProbeType()
+	m.manageProbes(m.ProbeTypesFor(targetType))
+	previousTarget, err := m.getTargetToRestore(target)
+	if err != nil {
+		return target_registrar.RegistrationResult{}, err
+	}
+	probeTypes, err := m.probeTypesFollowing(targetType, func(_ string, mode target_registrar.OverrideMode) bool {
+		return mode == target_registrar.OverrideAuto
+	})
+	if err != nil {
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to unregister target %v\n%v",
+			target_registrar.SafeString(target), err)
+	}
+	recorded, err := m.recordIntentsIf(probeTypes, 1, outbox.ActionStopProbe)
+	if err != nil {
+		return target_registrar.RegistrationResult{}, err
+	}
+	result, err := m.targetRegistrar.UnregisterTarget(target)
+	if err != nil {
+		m.completeIntents(recorded, outbox.ActionStopProbe)
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to unregister target %v\n%v",
+			target_registrar.SafeString(target), err)
+	}
+	// the stops scheduled at the end of the grace periods are cancelled if the change is rolled back
+	var scheduledStops []string
+	for _, probeType := range probeTypes {
+		last := false
+		if result.IsLastTarget() {
+			others, err := m.countTargetsExcept(probeType, targetType)
+			if err != nil {
+				return result, m.rollback(target, previousTarget, probeTypes, scheduledStops, outbox.ActionStopProbe,
+					err)
+			}
+			last = others == 0
+		}
+		if !last {
+			if recorded[probeType] {
+				m.completeIntent(probeType, outbox.ActionStopProbe)
+			}
+			continue
+		}
+		if period := m.stopGracePeriod(probeType); period > 0 {
+			// the intent stays pending until the probe is stopped at the end of the grace period
+			m.scheduleStop(probeType, period)
+			scheduledStops = append(scheduledStops, probeType)
+			continue
+		}
+		if _, err := m.stopProbe(probeType); err != nil {
+			return result, m.rollback(target, previousTarget, probeTypes, scheduledStops, outbox.ActionStopProbe, err)
+		}
+		m.completeIntent(probeType, outbox.ActionStopProbe)
+	}
+	return result, nil
+}
+
+// probeTypesFollowing returns the probe types the targets of the given target type need whose override modes pass the
+// given filter
+func (m *ProbeLifecycleManager) probeTypesFollowing(targetType string,
+	filter func(probeType string, mode target_registrar.OverrideMode) bool) ([]string, error) {
+	var probeTypes []string
+	for _, probeType := range m.ProbeTypesFor(targetType) {
+		mode, err := m.overrideMode(probeType)
+		if err != nil {
+			return nil, err
+		}
+		if filter(probeType, mode) {
+			probeTypes = append(probeTypes, probeType)
+		}
+	}
+	return probeTypes, nil
+}
+
+// enableProbe starts the probe unless it is known to be enabled already, sparing a redundant update to the probe
+// controller.  It returns false, queueing the probe as starved, if the probe budget is spent.
+func (m *ProbeLifecycleManager) enableProbe(probeType string) (bool, error) {
+	if state, err := m.probeController.GetProbeState(probeType); err == nil && state == probe_controller.ProbeStateEnabled {
+		m.unstarve(probeType)
+		return true, nil
+	}
+	allowed, err := m.budgetAllows([]string{probeType})
+	if err != nil {
+		return false, err
+	}
+	if !allowed {
+		m.starve(probeType)
+		return false, nil
+	}
+	if err := m.probeController.StartProbe(probeType); err != nil {
+		return false, err
+	}
+	m.unstarve(probeType)
+	return true, nil
+}
+
+// disableProbe stops the probe unless it is known to be disabled already, sparing a redundant update to the probe
+// controller.  A starved probe is no longer queued.
+func (m *ProbeLifecycleManager) disableProbe(probeType string) error {
+	m.unstarve(probeType)
+	if state, err := m.probeController.GetProbeState(probeType); err == nil && state == probe_controller.ProbeStateDisabled {
 		return nil
 	}
-	return m.probeController.StopProbe(target.GetProbeType())
+	return m.probeController.StopProbe(probeType)
+}
+
+// ListProbeTypes returns the probe types having target info kept by the target registrar
+func (m *ProbeLifecycleManager) ListProbeTypes() ([]string, error) {
+	return m.targetRegistrar.ListProbeTypes()
+}
+
+// ListTargets returns all the targets of the given probe type, sorted by the target id
+func (m *ProbeLifecycleManager) ListTargets(probeType string) ([]target_registrar.Target, error) {
+	return m.targetRegistrar.ListTargets(probeType)
+}
+
+// GetTarget returns the target of the given probe type and id, or nil if no such target exists
+func (m *ProbeLifecycleManager) GetTarget(probeType string, id string) (target_registrar.Target, error) {
+	return m.targetRegistrar.GetTarget(probeType, id)
+}
+
+// CountTargets returns the number of targets of the given probe type
+func (m *ProbeLifecycleManager) CountTargets(probeType string) (int, error) {
+	return m.targetRegistrar.CountTargets(probeType)
 }
@@ -0,0 +1,125 @@
+package manager_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/fault_injection"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/in_memory"
+)
+
+// newTarget constructs a username/password target of the given probe type and id
+func newTarget(probeType string, id string) target_registrar.UserPassTarget {
+	return target_registrar.UserPassTarget{Id: id, Probetype: probeType, Username: "user-" + id, Password: "pass-" + id}
+}
+
+// newRegistrar constructs an in-memory registrar with the given number of targets for each probe type
+func newRegistrar(counts map[string]int) *in_memory.InMemoryRegistrar {
+	registrar := in_memory.NewInMemoryRegistrar()
+	for probeType, count := range counts {
+		for i := 1; i <= count; i++ {
+			_, err := registrar.RegisterTarget(newTarget(probeType, fmt.Sprintf("Moid%d", i)))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+	registrar.ResetCalls()
+	return registrar
+}
+
+// getState returns the current state of the given probe
+func getState(controller probe_controller.ProbeController, probeType string) probe_controller.ProbeState {
+	state, err := controller.GetProbeState(probeType)
+	Expect(err).NotTo(HaveOccurred())
+	return state
+}
+
+var _ = Describe("Test probe lifecycle manager", func() {
+	var (
+		registrar  *in_memory.InMemoryRegistrar
+		controller *probeinmemory.InMemoryProbeController
+		m          *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		registrar = in_memory.NewInMemoryRegistrar()
+		controller = probeinmemory.NewInMemoryProbeController(nil)
+		m = manager.NewProbeLifecycleManager(registrar, controller)
+	})
+
+	It("starts a probe upon its first target and stops it upon its last", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid2"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(controller.CallsTo("StartProbe")).To(HaveLen(1))
+
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid2"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateDisabled))
+		Expect(controller.CallsTo("StopProbe")).To(HaveLen(1))
+	})
+
+	It("leaves the probe alone when a target is updated or registered again", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		updatedTarget := newTarget("vcenter", "Moid1")
+		updatedTarget.Password = "new-password"
+		Expect(m.AddOrUpdateTarget(updatedTarget)).To(Succeed())
+		Expect(controller.CallsTo("StartProbe")).To(HaveLen(1))
+		Expect(controller.CallsTo("GetProbeState")).To(HaveLen(1))
+
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid2"))).To(Succeed())
+		Expect(m.DeleteTarget(newTarget("pure", "Moid1"))).To(Succeed())
+		Expect(controller.CallsTo("StopProbe")).To(BeEmpty())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("reports the failure of the probe controller, and leaves it to the reconciler to start the probe", func() {
+		controller.FailNthCall("StartProbe", 1, fmt.Errorf("xl resource is gone"))
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).NotTo(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(getState(controller, "vcenter")).NotTo(Equal(probe_controller.ProbeStateEnabled))
+		_, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("never reveals the credentials of a target in its errors", func() {
+		registrar.FailAllCalls(fault_injection.AnyMethod, fmt.Errorf("registrar is down"))
+		target := target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter", Username: "user1", Password: "pass1"}
+
+		for _, err := range []error{m.AddOrUpdateTarget(target), m.DeleteTarget(target)} {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("vcenter/Moid1"))
+			Expect(err.Error()).NotTo(ContainSubstring("pass1"))
+		}
+	})
+
+	It("rejects an invalid target with validation errors before registering it", func() {
+		err := m.AddOrUpdateTarget(target_registrar.UserPassTarget{Probetype: "vcenter", Username: "user1"})
+		Expect(target_registrar.IsValidationError(err)).To(BeTrue())
+		var fields []string
+		for _, validationErr := range target_registrar.AsValidationErrors(err) {
+			fields = append(fields, validationErr.Field)
+		}
+		Expect(fields).To(Equal([]string{"Id", "Password"}))
+		Expect(registrar.CallsTo("RegisterTarget")).To(BeEmpty())
+	})
+
+	It("rejects a target without a probe type before unregistering it", func() {
+		err := m.DeleteTarget(target_registrar.UserPassTarget{Id: "Moid1", Username: "user1", Password: "pass1"})
+		Expect(target_registrar.IsValidationError(err)).To(BeTrue())
+		Expect(registrar.CallsTo("UnregisterTarget")).To(BeEmpty())
+	})
+
+	It("deletes a target known by its probe type and id only", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(m.DeleteTarget(target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter"})).To(Succeed())
+		Expect(registrar.CountTargets("vcenter")).To(Equal(0))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateDisabled))
+	})
+})
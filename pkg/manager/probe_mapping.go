@@ -0,0 +1,80 @@
+package manager
+
+import (
+	"fmt"
+	"sort"
+)
+
+// WithProbeMapping maps each given target type, i.e. the probe type a target reports with GetProbeType(), to the
+// probes its targets need, e.g. a vCenter target to both the vcenter and the vcenter-browsing probes, or several
+// target types to a single shared probe.  A target type left out is mapped to the probe of the same name.  A probe
+// counts the targets of all the target types mapped to it, and is stopped only when none of them remains.
+func (m *ProbeLifecycleManager) WithProbeMapping(mapping map[string][]string) *ProbeLifecycleManager {
+	m.probeMapping = map[string][]string{}
+	m.targetTypeMapping = map[string][]string{}
+	for targetType, probeTypes := range mapping {
+		unique := map[string]bool{}
+		for _, probeType := range probeTypes {
+			unique[probeType] = true
+		}
+		for probeType := range unique {
+			m.probeMapping[targetType] = append(m.probeMapping[targetType], probeType)
+			m.targetTypeMapping[probeType] = append(m.targetTypeMapping[probeType], targetType)
+		}
+		m.probeMapping[targetType] = sortedOrEmpty(m.probeMapping[targetType])
+	}
+	for probeType, targetTypes := range m.targetTypeMapping {
+		m.targetTypeMapping[probeType] = sortedOrEmpty(targetTypes)
+	}
+	return m
+}
+
+// ProbeTypesFor returns the sorted probe types the targets of the given target type need
+func (m *ProbeLifecycleManager) ProbeTypesFor(targetType string) []string {
+	if probeTypes, mapped := m.probeMapping[targetType]; mapped {
+		return probeTypes
+	}
+	return []string{targetType}
+}
+
+// TargetTypesFor returns the sorted target types whose targets need the probe of the given type
+func (m *ProbeLifecycleManager) TargetTypesFor(probeType string) []string {
+	targetTypes := m.targetTypeMapping[probeType]
+	if _, mapped := m.probeMapping[probeType]; mapped {
+		return targetTypes
+	}
+	// the target type of the same name is mapped to the probe by default
+	return sortedOrEmpty(append(append([]string{}, targetTypes...), probeType))
+}
+
+// CountProbeTargets returns the number of targets needing the probe of the given type, across all the target types
+// mapped to it
+func (m *ProbeLifecycleManager) CountProbeTargets(probeType string) (int, error) {
+	return m.countTargetsExcept(probeType, "")
+}
+
+// countTargetsExcept returns the number of targets needing the probe of the given type, leaving out the targets of the
+// given target type
+func (m *ProbeLifecycleManager) countTargetsExcept(probeType string, excludedTargetType string) (int, error) {
+	total := 0
+	for _, targetType := range m.TargetTypesFor(probeType) {
+		if targetType == excludedTargetType {
+			continue
+		}
+		count, err := m.targetRegistrar.CountTargets(targetType)
+		if err != nil {
+			return 0, fmt.Errorf("failed to count the targets of type %v of probe %v\n%v", targetType, probeType, err)
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// sortedOrEmpty sorts the given probe or target types, returning an empty list rather than nil
+func sortedOrEmpty(types []string) []string {
+	if types == nil {
+		return []string{}
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,63 @@
+package manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+)
+
+var _ = Describe("Test probe mapping", func() {
+	var (
+		controller *probeinmemory.InMemoryProbeController
+		m          *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		controller = probeinmemory.NewInMemoryProbeController(nil)
+		m = manager.NewProbeLifecycleManager(newRegistrar(nil), controller).WithProbeMapping(map[string][]string{
+			"vcenter":        {"vcenter", "vcenter-browsing"},
+			"hyperv-cluster": {"hyperv"},
+			"hyperv-host":    {"hyperv"},
+		})
+	})
+
+	It("maps the target types to the probes and back", func() {
+		Expect(m.ProbeTypesFor("vcenter")).To(Equal([]string{"vcenter", "vcenter-browsing"}))
+		Expect(m.ProbeTypesFor("pure")).To(Equal([]string{"pure"}))
+		Expect(m.TargetTypesFor("hyperv")).To(Equal([]string{"hyperv", "hyperv-cluster", "hyperv-host"}))
+		Expect(m.TargetTypesFor("vcenter-browsing")).To(Equal([]string{"vcenter", "vcenter-browsing"}))
+		Expect(m.TargetTypesFor("vcenter")).To(Equal([]string{"vcenter"}))
+	})
+
+	It("starts and stops every probe a target needs", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(probesCalled(controller, "StartProbe")).To(Equal([]string{"vcenter", "vcenter-browsing"}))
+		Expect(m.DeleteTarget(newTarget("vcenter", "Moid1"))).To(Succeed())
+		Expect(probesCalled(controller, "StopProbe")).To(Equal([]string{"vcenter", "vcenter-browsing"}))
+	})
+
+	It("stops a probe shared by several target types only when no target of any of them remains", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("hyperv-cluster", "Cluster1"))).To(Succeed())
+		Expect(m.AddOrUpdateTarget(newTarget("hyperv-host", "Host1"))).To(Succeed())
+		Expect(probesCalled(controller, "StartProbe")).To(Equal([]string{"hyperv"}))
+
+		status, err := m.GetProbeStatus("hyperv")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status.TargetCount).To(Equal(2))
+		statuses, err := m.ListProbeStatuses()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].ProbeType).To(Equal("hyperv"))
+
+		Expect(m.DeleteTarget(newTarget("hyperv-cluster", "Cluster1"))).To(Succeed())
+		report, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.HasFixes()).To(BeFalse())
+		Expect(getState(controller, "hyperv")).To(Equal(probe_controller.ProbeStateEnabled))
+
+		Expect(m.DeleteTarget(newTarget("hyperv-host", "Host1"))).To(Succeed())
+		Expect(getState(controller, "hyperv")).To(Equal(probe_controller.ProbeStateDisabled))
+	})
+})
@@ -0,0 +1,207 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog"
+	"sync"
+	"time"
+)
+
+// DefaultReconcileInterval is the default period between two reconciliation passes
+const DefaultReconcileInterval = 5 * time.Minute
+
+// ReconcileReport summarizes what a single reconciliation pass has found and fixed
+type ReconcileReport struct {
+	// Checked lists every probe type examined in this pass
+	Checked []string
+	// Started lists the probe types that had targets but were not enabled, and have now been started
+	Started []string
+	// Stopped lists the probe types that had no targets but were enabled, and have now been stopped
+	Stopped []string
+	// Starved lists the probe types that had targets but were not enabled, and are queued until a slot of the probe
+	// budget frees up
+	Starved []string
+	// Failed maps the probe types that could not be reconciled to the corresponding errors
+	Failed map[string]error
+}
+
+// HasFixes returns true if this pass has started or stopped any probe
+func (r *ReconcileReport) HasFixes() bool {
+	return len(r.Started) > 0 || len(r.Stopped) > 0
+}
+
+// WithReconcileInterval sets the period between two reconciliation passes when running the reconciler
+func (m *ProbeLifecycleManager) WithReconcileInterval(interval time.Duration) *ProbeLifecycleManager {
+	m.reconcileInterval = interval
+	return m
+}
+
+// Run runs the reconciler periodically until the stop channel is closed.  Each pass brings the enabled flag of every
+// known probe in line with whether the probe has targets, and logs what it has fixed.  With an outbox, the intents
+// left pending by an earlier run are replayed alongside, and the maintenance windows of the probes are checked
+// alongside as well.  Once the stop channel is closed, it returns after the last passes, dropping the stops pending
+// at the end of the grace periods so that no probe is stopped after the shutdown.
+func (m *ProbeLifecycleManager) Run(stopCh <-chan struct{}) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		m.ReplayIntents(stopCh)
+	}()
+	go func() {
+		defer wg.Done()
+		m.RunSchedules(stopCh)
+	}()
+	interval := m.reconcileInterval
+	if interval <= 0 {
+		interval = DefaultReconcileInterval
+	}
+	wait.Until(func() {
+		report, err := m.Reconcile()
+		logReconcileReport(report, err)
+	}, interval, stopCh)
+	wg.Wait()
+	m.dropPendingStops()
+}
+
+// Reconcile runs a single reconciliation pass over every managed probe type known to either the target registrar or the
+// probe controller.  A probe is started if it has targets but is not enabled, and stopped if it has no targets but is
+// enabled.  An entry of the probe controller not managed by this manager is left alone.  The returned report lists
+// what has been fixed; the error aggregates all failures in this pass.
+func (m *ProbeLifecycleManager) Reconcile() (*ReconcileReport, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	report := &ReconcileReport{Failed: map[string]error{}}
+	probeTypes, probeStates, err := m.listKnownProbes()
+	if err != nil {
+		return report, err
+	}
+	report.Checked = probeTypes
+
+	var errs []error
+	// the probes with the highest priority come first in case the probe budget cannot afford all of them
+	for _, probeType := range m.byPriority(report.Checked) {
+		if err := m.reconcileProbe(probeType, probeStates[probeType], report); err != nil {
+			report.Failed[probeType] = err
+			errs = append(errs, err)
+		}
+	}
+	// a slot may have freed up outside the manager, e.g. a probe stopped by hand
+	m.startStarvedProbes()
+	// a probe starved early in the pass may have been started in a slot freed up later in the pass
+	starved := report.Starved
+	report.Starved = nil
+	for _, probeType := range starved {
+		if m.IsStarved(probeType) {
+			report.Starved = append(report.Starved, probeType)
+		} else {
+			report.Started = append(report.Started, probeType)
+		}
+	}
+	return report, utilerrors.NewAggregate(errs)
+}
+
+// syncProbe reconciles a single probe type, e.g. upon a change to its targets or to its maintenance windows, and logs
+// what it has fixed.  The starved probes are started if a slot of the probe budget has freed up.
+func (m *ProbeLifecycleManager) syncProbe(probeType string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state, err := m.probeController.GetProbeState(probeType)
+	if err != nil {
+		return fmt.Errorf("failed to get the state of probe %v from the probe controller\n%v", probeType, err)
+	}
+	report := &ReconcileReport{Checked: []string{probeType}, Failed: map[string]error{}}
+	if err := m.reconcileProbe(probeType, state, report); err != nil {
+		return err
+	}
+	m.startStarvedProbes()
+	if report.HasFixes() {
+		klog.Infof("Probe %v synced with its targets; started %v, stopped %v", probeType, report.Started, report.Stopped)
+	}
+	return nil
+}
+
+// reconcileProbe brings a single probe in line with whether it has targets and is within its maintenance windows, or
+// is needed by a running dependent, or with its override mode if pinned on or off, and records the fix in the report.
+// A probe following its targets is left alone while its stop is pending, and a pending stop is cancelled if the probe
+// should run after all, or should stop right away as its windows have closed.  A probe not managed by this manager is
+// never stopped.
+func (m *ProbeLifecycleManager) reconcileProbe(probeType string, state probe_controller.ProbeState,
+	report *ReconcileReport) error {
+	count, err := m.CountProbeTargets(probeType)
+	if err != nil {
+		return err
+	}
+	mode, err := m.overrideMode(probeType)
+	if err != nil {
+		return err
+	}
+	if count > 0 || mode != target_registrar.OverrideAuto {
+		m.manageProbes([]string{probeType})
+	}
+	inWindow := m.windowOpen(probeType, time.Now())
+	run := shouldRun(mode, count, inWindow)
+	if !run && mode != target_registrar.OverrideForceDisabled {
+		neededBy, err := m.runningDependents(probeType)
+		if err != nil {
+			return err
+		}
+		run = len(neededBy) > 0
+	}
+	if run || mode != target_registrar.OverrideAuto || !inWindow {
+		m.cancelPendingStop(probeType)
+	} else if _, pending := m.GetPendingStop(probeType); pending {
+		// the probe is due to stop at the end of its grace period
+		return nil
+	}
+	if !run {
+		m.unstarve(probeType)
+	}
+	if run && state != probe_controller.ProbeStateEnabled {
+		started, err := m.startProbe(probeType)
+		if err != nil {
+			return fmt.Errorf("failed to start probe %v with %d targets in override mode %v\n%v", probeType, count,
+				mode, err)
+		}
+		if started {
+			report.Started = append(report.Started, probeType)
+		} else {
+			report.Starved = append(report.Starved, probeType)
+		}
+	} else if !run && state == probe_controller.ProbeStateEnabled && m.IsManagedProbe(probeType) {
+		stopped, err := m.stopProbe(probeType)
+		if err != nil {
+			return fmt.Errorf("failed to stop probe %v with %d targets in override mode %v\n%v", probeType, count,
+				mode, err)
+		}
+		if stopped {
+			report.Stopped = append(report.Stopped, probeType)
+		}
+	}
+	return nil
+}
+
+// logReconcileReport logs the fixes and the failures of a reconciliation pass
+func logReconcileReport(report *ReconcileReport, err error) {
+	if err != nil {
+		klog.Errorf("Probe reconciliation pass failed: %v", err)
+	}
+	if report == nil {
+		return
+	}
+	if report.HasFixes() {
+		klog.Infof("Probe reconciliation pass checked %d probes; started %v, stopped %v",
+			len(report.Checked), report.Started, report.Stopped)
+	} else {
+		klog.V(2).Infof("Probe reconciliation pass checked %d probes; nothing to fix", len(report.Checked))
+	}
+	if len(report.Starved) > 0 {
+		klog.Infof("Probes %v are starved until a slot of the probe budget frees up", report.Starved)
+	}
+}
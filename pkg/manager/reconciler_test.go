@@ -0,0 +1,118 @@
+package manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/k8s_secret"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = Describe("Test probe reconciliation", func() {
+	DescribeTable("test a reconciliation pass",
+		func(counts map[string]int, states map[string]probe_controller.ProbeState,
+			expectedStarted []string, expectedStopped []string) {
+			controller := probeinmemory.NewInMemoryProbeController(states)
+			m := manager.NewProbeLifecycleManager(newRegistrar(counts), controller).
+				WithManagedProbes("appdynamics", "pure")
+
+			report, err := m.Reconcile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.Started).To(Equal(expectedStarted))
+			Expect(report.Stopped).To(Equal(expectedStopped))
+			for probeType, count := range counts {
+				if count > 0 {
+					Expect(getState(controller, probeType)).To(Equal(probe_controller.ProbeStateEnabled))
+				} else {
+					Expect(getState(controller, probeType)).NotTo(Equal(probe_controller.ProbeStateEnabled))
+				}
+			}
+
+			// A second pass should find nothing to fix
+			report, err = m.Reconcile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.HasFixes()).To(BeFalse())
+		},
+		Entry("nothing to fix when no probes exist", map[string]int{},
+			map[string]probe_controller.ProbeState{}, nil, nil),
+		Entry("start a probe with targets that was never configured", map[string]int{"vcenter": 2},
+			map[string]probe_controller.ProbeState{}, []string{"vcenter"}, nil),
+		Entry("start a probe with targets that is disabled", map[string]int{"vcenter": 1},
+			map[string]probe_controller.ProbeState{"vcenter": probe_controller.ProbeStateDisabled}, []string{"vcenter"}, nil),
+		Entry("stop an enabled probe without targets", map[string]int{"vcenter": 1},
+			map[string]probe_controller.ProbeState{
+				"vcenter": probe_controller.ProbeStateEnabled,
+				"pure":    probe_controller.ProbeStateEnabled,
+			}, nil, []string{"pure"}),
+		Entry("fix several probes at once", map[string]int{"vcenter": 1, "pure": 0},
+			map[string]probe_controller.ProbeState{
+				"appdynamics": probe_controller.ProbeStateEnabled,
+				"pure":        probe_controller.ProbeStateEnabled,
+				"vcenter":     probe_controller.ProbeStateUnknown,
+			}, []string{"vcenter"}, []string{"appdynamics", "pure"}),
+		Entry("leave alone an enabled component of the platform that is not a managed probe",
+			map[string]int{"vcenter": 1},
+			map[string]probe_controller.ProbeState{
+				"vcenter":   probe_controller.ProbeStateEnabled,
+				"kubeturbo": probe_controller.ProbeStateEnabled,
+				"grafana":   probe_controller.ProbeStateEnabled,
+			}, nil, nil),
+	)
+
+	It("manages the probes it has seen targets of, and the probes of the probe mapping", func() {
+		controller := probeinmemory.NewInMemoryProbeController(map[string]probe_controller.ProbeState{
+			"vcenter":          probe_controller.ProbeStateEnabled,
+			"vcenter-browsing": probe_controller.ProbeStateEnabled,
+			"ingress":          probe_controller.ProbeStateEnabled,
+		})
+		registrar := newRegistrar(map[string]int{"vcenter": 1})
+		m := manager.NewProbeLifecycleManager(registrar, controller).
+			WithProbeMapping(map[string][]string{"vcenter-host": {"vcenter-browsing"}})
+		report, err := m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Checked).To(Equal([]string{"vcenter", "vcenter-browsing"}))
+		Expect(report.Stopped).To(Equal([]string{"vcenter-browsing"}))
+		Expect(m.IsManagedProbe("vcenter")).To(BeTrue())
+		Expect(m.IsManagedProbe("ingress")).To(BeFalse())
+
+		// the last target of vcenter goes away outside the manager
+		_, err = registrar.UnregisterTarget(newTarget("vcenter", "Moid1"))
+		Expect(err).NotTo(HaveOccurred())
+		report, err = m.Reconcile()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Stopped).To(Equal([]string{"vcenter"}))
+		Expect(getState(controller, "ingress")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("keeps running a probe whose targets were registered by an earlier version of this library upon legacy adoption",
+		func() {
+			// Earlier versions kept the bare target info in the StringData of an unlabeled secret named after the
+			// probe type
+			bytes, err := target_registrar.UserPassTarget{Id: "moid1", Probetype: "vcenter", Username: "user",
+				Password: "pass"}.Bytes()
+			Expect(err).NotTo(HaveOccurred())
+			legacySecret := &apiv1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "vcenter", Namespace: testNamespace},
+				StringData: map[string]string{"moid1": string(bytes)},
+			}
+			registrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(
+				fake.NewSimpleClientset(legacySecret).CoreV1(), testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			registrar.WithAdoptionPolicy(k8s_secret.AdoptionPolicyAdoptLegacy)
+			controller := probeinmemory.NewInMemoryProbeController(map[string]probe_controller.ProbeState{
+				"vcenter": probe_controller.ProbeStateEnabled,
+			})
+			m := manager.NewProbeLifecycleManager(registrar, controller)
+
+			report, err := m.Reconcile()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(report.HasFixes()).To(BeFalse())
+			Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		})
+})
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"sort"
+	"time"
+)
+
+// ProbeStatus reports the current state of a probe together with the number of its targets
+type ProbeStatus struct {
+	// ProbeType is the type of the probe
+	ProbeType string
+	// State is the state of the probe as reported by the probe controller
+	State probe_controller.ProbeState
+	// TargetCount is the number of targets registered for the probe, across all the target types mapped to it
+	TargetCount int
+	// StopDueAt is the time the probe is due to stop at the end of its grace period, or nil if no stop is pending
+	StopDueAt *time.Time
+	// Override is the override mode of the probe, pinning it on or off regardless of its targets unless Auto
+	Override target_registrar.OverrideMode
+	// OutsideWindow is true if the probe has a schedule and none of its maintenance windows is open
+	OutsideWindow bool
+	// NextTransition is the time a maintenance window of the probe next opens or closes, or nil if the probe has no
+	// schedule
+	NextTransition *time.Time
+	// NeededBy lists the running probes depending on the probe, which keep it running even without targets
+	NeededBy []string
+	// Starved is true if the probe is queued until a slot of the probe budget frees up
+	Starved bool
+}
+
+// InSync returns true if the probe is enabled if and only if it has targets within its maintenance windows, a pending
+// stop or running dependents, or as pinned by its override mode.  A starved probe is in sync as long as it is not
+// enabled.
+func (s *ProbeStatus) InSync() bool {
+	if s.Starved {
+		return s.State != probe_controller.ProbeStateEnabled
+	}
+	if s.Override == target_registrar.OverrideAuto && (s.StopDueAt != nil && !s.OutsideWindow || len(s.NeededBy) > 0) {
+		return s.State == probe_controller.ProbeStateEnabled
+	}
+	return inSync(s.State, s.Override, s.TargetCount, !s.OutsideWindow)
+}
+
+// GetProbeStatus returns the status of the given probe
+func (m *ProbeLifecycleManager) GetProbeStatus(probeType string) (*ProbeStatus, error) {
+	state, err := m.probeController.GetProbeState(probeType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the state of probe %v\n%v", probeType, err)
+	}
+	return m.probeStatus(probeType, state)
+}
+
+// ListProbeStatuses returns the status of every managed probe known to either the target registrar or the probe
+// controller, sorted by the probe type
+func (m *ProbeLifecycleManager) ListProbeStatuses() ([]*ProbeStatus, error) {
+	probeTypes, probeStates, err := m.listKnownProbes()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*ProbeStatus, 0, len(probeTypes))
+	for _, probeType := range probeTypes {
+		status, err := m.probeStatus(probeType, probeStates[probeType])
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// probeStatus constructs the status of a probe in the given state
+func (m *ProbeLifecycleManager) probeStatus(probeType string, state probe_controller.ProbeState) (*ProbeStatus, error) {
+	count, err := m.CountProbeTargets(probeType)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := m.overrideMode(probeType)
+	if err != nil {
+		return nil, err
+	}
+	status := &ProbeStatus{ProbeType: probeType, State: state, TargetCount: count, Override: mode,
+		Starved: m.IsStarved(probeType)}
+	if dueAt, pending := m.GetPendingStop(probeType); pending {
+		status.StopDueAt = &dueAt
+	}
+	if status.NeededBy, err = m.runningDependents(probeType); err != nil {
+		return nil, err
+	}
+	if probeSchedule := m.getSchedule(probeType); probeSchedule != nil {
+		now := time.Now()
+		status.OutsideWindow = !probeSchedule.IsOpen(now)
+		if next, found := probeSchedule.NextTransition(now); found {
+			status.NextTransition = &next
+		}
+	}
+	return status, nil
+}
+
+// listKnownProbes returns the sorted probe types known to either the target registrar, i.e. the probe types needed by
+// its targets or with an override mode, or the probe controller, along with their states.  The probe types only known
+// to the target registrar are in the unknown state.  The entries of the probe controller not managed by this manager
+// are left out, as they may be other components of the platform rather than probes.
+func (m *ProbeLifecycleManager) listKnownProbes() ([]string, map[string]probe_controller.ProbeState, error) {
+	targetTypes, err := m.targetRegistrar.ListProbeTypes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the probe types from the target registrar\n%v", err)
+	}
+	probeStates, err := m.probeController.ListProbes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the probes from the probe controller\n%v", err)
+	}
+	if probeStates == nil {
+		probeStates = map[string]probe_controller.ProbeState{}
+	}
+	overrides, err := m.ListOverrideModes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the override modes from the target registrar\n%v", err)
+	}
+	var registeredProbeTypes []string
+	for _, targetType := range targetTypes {
+		registeredProbeTypes = append(registeredProbeTypes, m.ProbeTypesFor(targetType)...)
+	}
+	for probeType := range overrides {
+		registeredProbeTypes = append(registeredProbeTypes, probeType)
+	}
+	m.manageProbes(registeredProbeTypes)
+	for probeType := range probeStates {
+		if !m.IsManagedProbe(probeType) {
+			delete(probeStates, probeType)
+		}
+	}
+	for _, probeType := range registeredProbeTypes {
+		if _, found := probeStates[probeType]; !found {
+			probeStates[probeType] = probe_controller.ProbeStateUnknown
+		}
+	}
+	probeTypes := make([]string, 0, len(probeStates))
+	for probeType := range probeStates {
+		probeTypes = append(probeTypes, probeType)
+	}
+	sort.Strings(probeTypes)
+	return probeTypes, probeStates, nil
+}
@@ -0,0 +1,54 @@
+package manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+)
+
+var _ = Describe("Test probe status", func() {
+	var (
+		controller *probeinmemory.InMemoryProbeController
+		m          *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		controller = probeinmemory.NewInMemoryProbeController(map[string]probe_controller.ProbeState{
+			"pure":        probe_controller.ProbeStateEnabled,
+			"appdynamics": probe_controller.ProbeStateDisabled,
+		})
+		m = manager.NewProbeLifecycleManager(newRegistrar(map[string]int{"vcenter": 2, "pure": 1}), controller).
+			WithManagedProbes("appdynamics")
+	})
+
+	It("reports the state and the target count of every known probe", func() {
+		statuses, err := m.ListProbeStatuses()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(statuses).To(Equal([]*manager.ProbeStatus{
+			{ProbeType: "appdynamics", State: probe_controller.ProbeStateDisabled, TargetCount: 0,
+				Override: target_registrar.OverrideAuto},
+			{ProbeType: "pure", State: probe_controller.ProbeStateEnabled, TargetCount: 1,
+				Override: target_registrar.OverrideAuto},
+			{ProbeType: "vcenter", State: probe_controller.ProbeStateUnknown, TargetCount: 2,
+				Override: target_registrar.OverrideAuto},
+		}))
+		Expect(statuses[0].InSync()).To(BeTrue())
+		Expect(statuses[1].InSync()).To(BeTrue())
+		Expect(statuses[2].InSync()).To(BeFalse())
+
+		status, err := m.GetProbeStatus("pure")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(status).To(Equal(statuses[1]))
+	})
+
+	It("skips the update to the probe controller when the probe is already in the desired state", func() {
+		Expect(m.AddOrUpdateTarget(newTarget("pure", "Moid9"))).To(Succeed())
+		Expect(controller.CallsTo("StartProbe")).To(BeEmpty())
+		Expect(m.AddOrUpdateTarget(newTarget("appdynamics", "Moid1"))).To(Succeed())
+		Expect(controller.CallsTo("StartProbe")).To(HaveLen(1))
+		Expect(getState(controller, "appdynamics")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+})
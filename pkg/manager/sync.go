@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sort"
+)
+
+// SyncReport summarizes what a declarative sync has changed.  The targets are listed as "probeType/id", sorted.
+type SyncReport struct {
+	// Created lists the desired targets that were not registered, and have now been registered
+	Created []string
+	// Updated lists the desired targets that were registered with different target info, and have now been updated
+	Updated []string
+	// Unchanged lists the desired targets that were already registered with the same target info
+	Unchanged []string
+	// Deleted lists the targets within the scope that were registered but not desired, and have now been unregistered
+	Deleted []string
+	// Started lists the probe types that were not enabled before the sync and are enabled now
+	Started []string
+	// Stopped lists the probe types that were enabled before the sync and are not enabled now
+	Stopped []string
+	// Failed maps the targets, or the probe types within the scope whose targets could not be listed, to the
+	// corresponding errors
+	Failed map[string]error
+}
+
+// HasChanges returns true if the sync has registered or unregistered any target
+func (r *SyncReport) HasChanges() bool {
+	return len(r.Created) > 0 || len(r.Updated) > 0 || len(r.Deleted) > 0
+}
+
+// Sync brings the targets of the probe types within the given scope, i.e. the probe types the targets report with
+// GetProbeType(), in line with the given desired targets: the new and changed targets are registered, and the
+// registered targets missing from the desired ones are unregistered, each starting or stopping the probes they need
+// as AddOrUpdateTarget() and DeleteTarget() would.  The targets of the probe types outside the scope are left alone.
+// The desired targets are checked up front: an invalid or duplicate target, or a target outside the scope, fails the
+// sync before any change is made.  Otherwise a failure to register or unregister a target does not stop the sync; the
+// returned report lists what has been changed, and the error aggregates all failures.
+func (m *ProbeLifecycleManager) Sync(desired []target_registrar.Target, scope []string) (*SyncReport, error) {
+	if len(scope) == 0 {
+		return nil, fmt.Errorf("cannot sync the targets without a scope of probe types")
+	}
+	inScope := map[string]bool{}
+	for _, probeType := range scope {
+		inScope[probeType] = true
+	}
+	desiredIds := map[string]map[string]bool{}
+	for _, target := range desired {
+		if err := m.ValidateTarget(target); err != nil {
+			return nil, fmt.Errorf("cannot sync the invalid target %v\n%v", target_registrar.SafeString(target), err)
+		}
+		probeType, id := target.GetProbeType(), target.GetId()
+		if !inScope[probeType] {
+			return nil, fmt.Errorf("cannot sync target %v outside the scope %v", target_registrar.SafeString(target),
+				scope)
+		}
+		if desiredIds[probeType][id] {
+			return nil, fmt.Errorf("cannot sync the duplicate target %v", target_registrar.SafeString(target))
+		}
+		if desiredIds[probeType] == nil {
+			desiredIds[probeType] = map[string]bool{}
+		}
+		desiredIds[probeType][id] = true
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	report := &SyncReport{Failed: map[string]error{}}
+	statesBefore, err := m.probeController.ListProbes()
+	if err != nil {
+		return report, fmt.Errorf("failed to list the probes before syncing the targets\n%v", err)
+	}
+	var errs []error
+	fail := func(key string, err error) {
+		report.Failed[key] = err
+		errs = append(errs, err)
+	}
+	for _, target := range desired {
+		key := syncKey(target.GetProbeType(), target.GetId())
+		result, err := m.addOrUpdateTarget(target)
+		if err != nil {
+			fail(key, err)
+			continue
+		}
+		switch result.Change {
+		case target_registrar.RegistrationCreated:
+			report.Created = append(report.Created, key)
+		case target_registrar.RegistrationUpdated:
+			report.Updated = append(report.Updated, key)
+		default:
+			report.Unchanged = append(report.Unchanged, key)
+		}
+	}
+	for _, probeType := range sortedOrEmpty(append([]string{}, scope...)) {
+		registered, err := m.targetRegistrar.ListTargets(probeType)
+		if err != nil {
+			// without the registered targets, none of them can be told missing
+			fail(probeType, fmt.Errorf("failed to list the targets of probe type %v to sync\n%v", probeType, err))
+			continue
+		}
+		for _, target := range registered {
+			if desiredIds[probeType][target.GetId()] {
+				continue
+			}
+			key := syncKey(probeType, target.GetId())
+			result, err := m.deleteTarget(target)
+			if err != nil {
+				fail(key, err)
+				continue
+			}
+			if result.Change == target_registrar.RegistrationDeleted {
+				report.Deleted = append(report.Deleted, key)
+			}
+		}
+	}
+
+	statesAfter, err := m.probeController.ListProbes()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list the probes after syncing the targets\n%v", err))
+	} else {
+		report.Started, report.Stopped = diffProbeStates(statesBefore, statesAfter)
+	}
+	sort.Strings(report.Created)
+	sort.Strings(report.Updated)
+	sort.Strings(report.Unchanged)
+	sort.Strings(report.Deleted)
+	return report, utilerrors.NewAggregate(errs)
+}
+
+// syncKey identifies a target in a sync report
+func syncKey(probeType string, id string) string {
+	return probeType + "/" + id
+}
+
+// diffProbeStates returns the sorted probe types enabled in the after states but not in the before states, and the
+// ones enabled in the before states but not in the after states
+func diffProbeStates(before, after map[string]probe_controller.ProbeState) ([]string, []string) {
+	var started, stopped []string
+	for probeType, state := range after {
+		if state == probe_controller.ProbeStateEnabled && before[probeType] != probe_controller.ProbeStateEnabled {
+			started = append(started, probeType)
+		}
+	}
+	for probeType, state := range before {
+		if state == probe_controller.ProbeStateEnabled && after[probeType] != probe_controller.ProbeStateEnabled {
+			stopped = append(stopped, probeType)
+		}
+	}
+	sort.Strings(started)
+	sort.Strings(stopped)
+	return started, stopped
+}
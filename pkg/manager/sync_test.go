@@ -0,0 +1,81 @@
+package manager_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/in_memory"
+)
+
+var _ = Describe("Test declarative sync", func() {
+	var (
+		registrar  *in_memory.InMemoryRegistrar
+		controller *probeinmemory.InMemoryProbeController
+		m          *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		registrar = newRegistrar(map[string]int{"vcenter": 2, "pure": 1, "hyperv": 1})
+		controller = probeinmemory.NewInMemoryProbeController(map[string]probe_controller.ProbeState{
+			"vcenter": probe_controller.ProbeStateEnabled,
+			"pure":    probe_controller.ProbeStateEnabled,
+			"hyperv":  probe_controller.ProbeStateEnabled,
+		})
+		m = manager.NewProbeLifecycleManager(registrar, controller)
+	})
+
+	It("creates, updates and deletes the targets within the scope, and starts and stops their probes", func() {
+		updated := newTarget("vcenter", "Moid2")
+		updated.Password = "new-pass"
+		report, err := m.Sync([]target_registrar.Target{
+			newTarget("vcenter", "Moid1"), updated, newTarget("netapp", "Moid1"),
+		}, []string{"vcenter", "pure", "netapp"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Created).To(Equal([]string{"netapp/Moid1"}))
+		Expect(report.Updated).To(Equal([]string{"vcenter/Moid2"}))
+		Expect(report.Unchanged).To(Equal([]string{"vcenter/Moid1"}))
+		Expect(report.Deleted).To(Equal([]string{"pure/Moid1"}))
+		Expect(report.Started).To(Equal([]string{"netapp"}))
+		Expect(report.Stopped).To(Equal([]string{"pure"}))
+		Expect(report.Failed).To(BeEmpty())
+		Expect(report.HasChanges()).To(BeTrue())
+
+		// the targets outside the scope are left alone
+		Expect(registrar.CountTargets("hyperv")).To(Equal(1))
+		Expect(getState(controller, "hyperv")).To(Equal(probe_controller.ProbeStateEnabled))
+
+		report, err = m.Sync([]target_registrar.Target{
+			newTarget("vcenter", "Moid1"), updated, newTarget("netapp", "Moid1"),
+		}, []string{"vcenter", "pure", "netapp"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.HasChanges()).To(BeFalse())
+		Expect(report.Unchanged).To(HaveLen(3))
+	})
+
+	It("deletes every target of a probe type within the scope but left out of the desired targets", func() {
+		report, err := m.Sync(nil, []string{"vcenter"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(report.Deleted).To(Equal([]string{"vcenter/Moid1", "vcenter/Moid2"}))
+		Expect(report.Stopped).To(Equal([]string{"vcenter"}))
+		Expect(getState(controller, "pure")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("rejects the desired targets before making any change if any of them is out of scope, duplicate or invalid",
+		func() {
+			for _, desired := range [][]target_registrar.Target{
+				{newTarget("netapp", "Moid1"), newTarget("pure", "Moid2")},
+				{newTarget("netapp", "Moid1"), newTarget("netapp", "Moid1")},
+				{newTarget("netapp", "Moid1"), target_registrar.UserPassTarget{Probetype: "netapp", Username: "user1"}},
+			} {
+				_, err := m.Sync(desired, []string{"netapp"})
+				Expect(err).To(HaveOccurred())
+			}
+			_, err := m.Sync(nil, nil)
+			Expect(err).To(HaveOccurred())
+			Expect(registrar.CallsTo("RegisterTarget")).To(BeEmpty())
+			Expect(registrar.CallsTo("UnregisterTarget")).To(BeEmpty())
+		})
+})
@@ -0,0 +1,86 @@
+package manager
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+)
+
+// RollbackError reports a change to the target registrar that has been undone in the transactional mode, because the
+// probe controller has failed to follow it or the probes to follow it could not be told, along with the failure to undo
+// the change if any
+type RollbackError struct {
+	// Err is the failure of the probe controller, or of counting the targets of a probe, that has triggered the rollback
+	Err error
+	// RollbackErr is the failure to undo the change to the target registrar, or nil if the change has been undone
+	RollbackErr error
+}
+
+func (e *RollbackError) Error() string {
+	if e.RollbackErr == nil {
+		return fmt.Sprintf("%v\nthe change to the target has been rolled back", e.Err)
+	}
+	return fmt.Sprintf("%v\nfailed to roll back the change to the target\n%v", e.Err, e.RollbackErr)
+}
+
+// Unwrap returns the failure of the probe controller that has triggered the rollback
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// IsRolledBack returns true if the change to the target registrar has been undone
+func (e *RollbackError) IsRolledBack() bool {
+	return e.RollbackErr == nil
+}
+
+// WithTransactions turns the transactional mode on or off.  In the transactional mode, a target added, updated or
+// deleted is put back the way it was if the probe controller then fails to start or stop the probe, or if the targets
+// of the probe cannot be counted to tell whether to, and the failure is returned as a RollbackError.  Otherwise the
+// change to the target registrar stays, and it is left to the reconciler to bring the probe in line.
+func (m *ProbeLifecycleManager) WithTransactions(transactional bool) *ProbeLifecycleManager {
+	m.transactional = transactional
+	return m
+}
+
+// getTargetToRestore returns the target currently registered with the same probe type and id as the given target, or
+// nil if none is registered, so that it can be restored upon a rollback.  It returns nil outside the transactional
+// mode.
+func (m *ProbeLifecycleManager) getTargetToRestore(target target_registrar.Target) (target_registrar.Target, error) {
+	if !m.transactional {
+		return nil, nil
+	}
+	previousTarget, err := m.targetRegistrar.GetTarget(target.GetProbeType(), target.GetId())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the current info of target %v/%v\n%v", target.GetProbeType(),
+			target.GetId(), err)
+	}
+	return previousTarget, nil
+}
+
+// rollback puts the target back the way it was before a change the probe controller has failed to follow, i.e.
+// registers the previous target again, or unregisters the target if it was not registered before.  The intents of the
+// given action on the given probes are no longer owed once the change is undone, and the stops the change has
+// scheduled at the end of the grace periods are cancelled, as the restored target needs the probes again; a probe the
+// target needs that has started already is left to the reconciler.  Outside the transactional mode it returns the
+// failure of the probe controller as is, leaving the intents and the scheduled stops pending.
+func (m *ProbeLifecycleManager) rollback(target target_registrar.Target, previousTarget target_registrar.Target,
+	probeTypes []string, scheduledStops []string, action outbox.Action, err error) error {
+	if !m.transactional {
+		return err
+	}
+	var rollbackErr error
+	if previousTarget != nil {
+		_, rollbackErr = m.targetRegistrar.RegisterTarget(previousTarget)
+	} else {
+		_, rollbackErr = m.targetRegistrar.UnregisterTarget(target)
+	}
+	if rollbackErr == nil {
+		for _, probeType := range scheduledStops {
+			m.cancelPendingStop(probeType)
+		}
+		for _, probeType := range probeTypes {
+			m.completeIntent(probeType, action)
+		}
+	}
+	return &RollbackError{Err: err, RollbackErr: rollbackErr}
+}
@@ -0,0 +1,118 @@
+package manager_test
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/in_memory"
+	"time"
+)
+
+var _ = Describe("Test transactional mode", func() {
+	var (
+		registrar  *in_memory.InMemoryRegistrar
+		controller *probeinmemory.InMemoryProbeController
+		m          *manager.ProbeLifecycleManager
+	)
+
+	BeforeEach(func() {
+		registrar = in_memory.NewInMemoryRegistrar()
+		controller = probeinmemory.NewInMemoryProbeController(nil)
+		m = manager.NewProbeLifecycleManager(registrar, controller).WithTransactions(true)
+	})
+
+	It("unregisters the first target again if the probe fails to start", func() {
+		controllerErr := fmt.Errorf("xl resource is gone")
+		controller.FailNthCall("StartProbe", 1, controllerErr)
+		err := m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))
+		var rollbackErr *manager.RollbackError
+		Expect(errors.As(err, &rollbackErr)).To(BeTrue())
+		Expect(rollbackErr.IsRolledBack()).To(BeTrue())
+		Expect(errors.Is(err, controllerErr)).To(BeTrue())
+		Expect(m.CountTargets("vcenter")).To(Equal(0))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateUnknown))
+	})
+
+	It("restores the last target with its info if the probe fails to stop", func() {
+		target := newTarget("vcenter", "Moid1")
+		Expect(m.AddOrUpdateTarget(target)).To(Succeed())
+		controller.FailNthCall("StopProbe", 1, fmt.Errorf("xl resource is gone"))
+		err := m.DeleteTarget(target)
+		var rollbackErr *manager.RollbackError
+		Expect(errors.As(err, &rollbackErr)).To(BeTrue())
+		Expect(rollbackErr.IsRolledBack()).To(BeTrue())
+		Expect(m.GetTarget("vcenter", "Moid1")).To(Equal(target))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("rolls back a target whose probes cannot be told to start or stop for lack of a target count", func() {
+		m.WithProbeMapping(map[string][]string{"vcenter": {"vcenter"}, "vcenter-host": {"vcenter"}})
+		countErr := fmt.Errorf("registrar is down")
+		registrar.FailNthCall("CountTargets", 1, countErr)
+		err := m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))
+		var rollbackErr *manager.RollbackError
+		Expect(errors.As(err, &rollbackErr)).To(BeTrue())
+		Expect(rollbackErr.IsRolledBack()).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("registrar is down"))
+		Expect(m.CountTargets("vcenter")).To(Equal(0))
+		Expect(controller.CallsTo("StartProbe")).To(BeEmpty())
+
+		target := newTarget("vcenter", "Moid1")
+		Expect(m.AddOrUpdateTarget(target)).To(Succeed())
+		// the first count fails again once the call counts are reset
+		registrar.ResetCalls()
+		err = m.DeleteTarget(target)
+		Expect(errors.As(err, &rollbackErr)).To(BeTrue())
+		Expect(rollbackErr.IsRolledBack()).To(BeTrue())
+		Expect(m.GetTarget("vcenter", "Moid1")).To(Equal(target))
+		Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("cancels the stops it has scheduled for a target restored after a later probe of the target fails to stop",
+		func() {
+			m.WithProbeMapping(map[string][]string{"vcenter": {"vcenter", "vcenter-browsing"}}).
+				WithStopGracePeriod("vcenter", time.Hour)
+			target := newTarget("vcenter", "Moid1")
+			Expect(m.AddOrUpdateTarget(target)).To(Succeed())
+			controller.FailNthCall("StopProbe", 1, fmt.Errorf("xl resource is gone"))
+			err := m.DeleteTarget(target)
+			var rollbackErr *manager.RollbackError
+			Expect(errors.As(err, &rollbackErr)).To(BeTrue())
+			Expect(rollbackErr.IsRolledBack()).To(BeTrue())
+			Expect(m.GetTarget("vcenter", "Moid1")).To(Equal(target))
+			Expect(probesCalled(controller, "StopProbe")).To(Equal([]string{"vcenter-browsing"}))
+			Expect(m.ListPendingStops()).To(BeEmpty())
+			Expect(getState(controller, "vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+			Expect(getState(controller, "vcenter-browsing")).To(Equal(probe_controller.ProbeStateEnabled))
+		})
+
+	It("reports both the failure of the probe controller and the failure to roll back", func() {
+		controller.FailNthCall("StartProbe", 1, fmt.Errorf("xl resource is gone"))
+		registrar.FailNthCall("UnregisterTarget", 1, fmt.Errorf("registrar is down"))
+		target := target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter", Username: "user1", Password: "pass1"}
+		err := m.AddOrUpdateTarget(target)
+		var rollbackErr *manager.RollbackError
+		Expect(errors.As(err, &rollbackErr)).To(BeTrue())
+		Expect(rollbackErr.IsRolledBack()).To(BeFalse())
+		Expect(err.Error()).To(ContainSubstring("xl resource is gone"))
+		Expect(err.Error()).To(ContainSubstring("registrar is down"))
+		Expect(err.Error()).NotTo(ContainSubstring("pass1"))
+		Expect(m.CountTargets("vcenter")).To(Equal(1))
+	})
+
+	It("keeps the change to the target registrar outside the transactional mode", func() {
+		m.WithTransactions(false)
+		controller.FailNthCall("StartProbe", 1, fmt.Errorf("xl resource is gone"))
+		err := m.AddOrUpdateTarget(newTarget("vcenter", "Moid1"))
+		Expect(err).To(HaveOccurred())
+		var rollbackErr *manager.RollbackError
+		Expect(errors.As(err, &rollbackErr)).To(BeFalse())
+		Expect(m.CountTargets("vcenter")).To(Equal(1))
+		Expect(registrar.CallsTo("GetTarget")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,180 @@
+package configmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	clientv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	clientretry "k8s.io/client-go/util/retry"
+)
+
+const (
+	// DefaultName is the default name of the ConfigMap keeping the intents
+	DefaultName = "probe-lifecycle-manager-outbox"
+	// IntentsKey is the data key of the ConfigMap under which the intents are kept as a JSON list
+	IntentsKey = "intents"
+	// managedByLabel and managedByValue mark the ConfigMap as created by the manager
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "probe-lifecycle-manager"
+)
+
+// ConfigMapOutbox implements the Outbox interface keeping the intents in a Kubernetes ConfigMap, as a JSON list under
+// IntentsKey.  Every change reads the ConfigMap and updates it with its resource version, retrying upon conflicts, so
+// that concurrent changes are not lost.
+type ConfigMapOutbox struct {
+	client    clientv1.CoreV1Interface
+	namespace string
+	name      string
+}
+
+// NewConfigMapOutboxForConfig constructs a ConfigMapOutbox given the input kubeconfig, the namespace and the name of
+// the ConfigMap
+func NewConfigMapOutboxForConfig(config *rest.Config, namespace string, name string) (*ConfigMapOutbox, error) {
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewConfigMapOutboxFromClient(kubeClient.CoreV1(), namespace, name)
+}
+
+// NewConfigMapOutboxFromClient constructs a ConfigMapOutbox given the kube client, the namespace and the name of the
+// ConfigMap, which defaults to DefaultName.  The ConfigMap is created upon the first intent recorded.
+func NewConfigMapOutboxFromClient(client clientv1.CoreV1Interface, namespace string,
+	name string) (*ConfigMapOutbox, error) {
+	if name == "" {
+		name = DefaultName
+	}
+	return &ConfigMapOutbox{client: client, namespace: namespace, name: name}, nil
+}
+
+// Record keeps the intent in the ConfigMap, replacing any pending intent for the same probe type
+func (o *ConfigMapOutbox) Record(intent outbox.Intent) error {
+	err := o.update(func(intents map[string]outbox.Intent) bool {
+		intents[intent.ProbeType] = intent
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record intent %v of probe %v in ConfigMap %v/%v\n%v", intent.Action,
+			intent.ProbeType, o.namespace, o.name, err)
+	}
+	return nil
+}
+
+// Complete removes the pending intent for the given probe type from the ConfigMap if it is the given action
+func (o *ConfigMapOutbox) Complete(probeType string, action outbox.Action) error {
+	err := o.update(func(intents map[string]outbox.Intent) bool {
+		if intent, found := intents[probeType]; !found || intent.Action != action {
+			return false
+		}
+		delete(intents, probeType)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete intent %v of probe %v in ConfigMap %v/%v\n%v", action, probeType,
+			o.namespace, o.name, err)
+	}
+	return nil
+}
+
+// List returns the pending intents in the ConfigMap, sorted by the probe type
+func (o *ConfigMapOutbox) List() ([]outbox.Intent, error) {
+	configMap, err := o.getConfigMap()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ConfigMap %v/%v\n%v", o.namespace, o.name, err)
+	}
+	intents, err := o.decodeIntents(configMap)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]outbox.Intent, 0, len(intents))
+	for _, intent := range intents {
+		list = append(list, intent)
+	}
+	outbox.SortIntents(list)
+	return list, nil
+}
+
+// update applies the given change to the intents in the ConfigMap, and writes them back if the change returns true,
+// creating the ConfigMap if not yet created.  It reads the ConfigMap again and retries upon conflicts.
+func (o *ConfigMapOutbox) update(change func(map[string]outbox.Intent) bool) error {
+	return clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
+		configMap, err := o.getConfigMap()
+		if err != nil {
+			return err
+		}
+		intents, err := o.decodeIntents(configMap)
+		if err != nil {
+			return err
+		}
+		if !change(intents) {
+			return nil
+		}
+		list := make([]outbox.Intent, 0, len(intents))
+		for _, intent := range intents {
+			list = append(list, intent)
+		}
+		outbox.SortIntents(list)
+		data, err := json.Marshal(list)
+		if err != nil {
+			return err
+		}
+		if configMap == nil {
+			_, err = o.client.ConfigMaps(o.namespace).Create(o.newConfigMap(string(data)))
+			if errors.IsAlreadyExists(err) {
+				// created by another writer in between; read it again and retry
+				return errors.NewConflict(schema.GroupResource{Resource: "configmaps"}, o.name, err)
+			}
+			return err
+		}
+		updatedConfigMap := configMap.DeepCopy()
+		if updatedConfigMap.Data == nil {
+			updatedConfigMap.Data = map[string]string{}
+		}
+		updatedConfigMap.Data[IntentsKey] = string(data)
+		_, err = o.client.ConfigMaps(o.namespace).Update(updatedConfigMap)
+		return err
+	})
+}
+
+// getConfigMap returns the ConfigMap keeping the intents, or nil if not yet created
+func (o *ConfigMapOutbox) getConfigMap() (*apiv1.ConfigMap, error) {
+	configMap, err := o.client.ConfigMaps(o.namespace).Get(o.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	return configMap, err
+}
+
+// decodeIntents decodes the intents kept in the ConfigMap keyed by the probe type, or none if the ConfigMap is nil
+func (o *ConfigMapOutbox) decodeIntents(configMap *apiv1.ConfigMap) (map[string]outbox.Intent, error) {
+	intents := map[string]outbox.Intent{}
+	if configMap == nil || configMap.Data[IntentsKey] == "" {
+		return intents, nil
+	}
+	var list []outbox.Intent
+	if err := json.Unmarshal([]byte(configMap.Data[IntentsKey]), &list); err != nil {
+		return nil, fmt.Errorf("failed to decode the intents in ConfigMap %v/%v\n%v", o.namespace, o.name, err)
+	}
+	for _, intent := range list {
+		intents[intent.ProbeType] = intent
+	}
+	return intents, nil
+}
+
+// newConfigMap returns a new ConfigMap keeping the given encoded intents, labeled as managed by the manager
+func (o *ConfigMapOutbox) newConfigMap(data string) *apiv1.ConfigMap {
+	return &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      o.name,
+			Namespace: o.namespace,
+			Labels:    map[string]string{managedByLabel: managedByValue},
+		},
+		Data: map[string]string{IntentsKey: data},
+	}
+}
@@ -0,0 +1,88 @@
+package configmap_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox/configmap"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"time"
+)
+
+const testNamespace = "turbonomic"
+
+var _ = Describe("ConfigMap outbox", func() {
+	var clientset *fake.Clientset
+	var o *configmap.ConfigMapOutbox
+
+	BeforeEach(func() {
+		clientset = fake.NewSimpleClientset()
+		var err error
+		o, err = configmap.NewConfigMapOutboxFromClient(clientset.CoreV1(), testNamespace, "")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("creates the ConfigMap upon the first intent and keeps one intent per probe type", func() {
+		Expect(o.List()).To(BeEmpty())
+		Expect(o.Complete("vcenter", outbox.ActionStartProbe)).To(Succeed())
+		_, err := clientset.CoreV1().ConfigMaps(testNamespace).Get(configmap.DefaultName, metav1.GetOptions{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		recordedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+		Expect(o.Record(outbox.Intent{ProbeType: "pure", Action: outbox.ActionStopProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStopProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+		Expect(o.List()).To(Equal([]outbox.Intent{
+			{ProbeType: "pure", Action: outbox.ActionStopProbe, RecordedAt: recordedAt},
+			{ProbeType: "vcenter", Action: outbox.ActionStopProbe, RecordedAt: recordedAt},
+		}))
+
+		cm, err := clientset.CoreV1().ConfigMaps(testNamespace).Get(configmap.DefaultName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cm.Labels).To(HaveKeyWithValue("app.kubernetes.io/managed-by", "probe-lifecycle-manager"))
+		Expect(cm.Data).To(HaveKey(configmap.IntentsKey))
+
+		Expect(o.Complete("vcenter", outbox.ActionStopProbe)).To(Succeed())
+		Expect(o.List()).To(Equal([]outbox.Intent{
+			{ProbeType: "pure", Action: outbox.ActionStopProbe, RecordedAt: recordedAt},
+		}))
+	})
+
+	It("retries an update that conflicts with another writer", func() {
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe})).To(Succeed())
+		conflicts := 2
+		clientset.PrependReactor("update", "configmaps",
+			func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if conflicts > 0 {
+					conflicts--
+					return true, nil, errors.NewConflict(schema.GroupResource{Resource: "configmaps"},
+						configmap.DefaultName, fmt.Errorf("the object has been modified"))
+				}
+				return false, nil, nil
+			})
+		Expect(o.Record(outbox.Intent{ProbeType: "pure", Action: outbox.ActionStartProbe})).To(Succeed())
+		Expect(conflicts).To(Equal(0))
+		Expect(o.List()).To(HaveLen(2))
+	})
+
+	It("reports the intents that cannot be decoded", func() {
+		_, err := clientset.CoreV1().ConfigMaps(testNamespace).Create(&apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: configmap.DefaultName, Namespace: testNamespace},
+			Data:       map[string]string{configmap.IntentsKey: "not json"},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = o.List()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(configmap.DefaultName))
+	})
+})
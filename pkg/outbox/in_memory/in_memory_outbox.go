@@ -0,0 +1,63 @@
+package in_memory
+
+import (
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/fault_injection"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"sync"
+)
+
+// InMemoryOutbox implements the Outbox interface keeping the intents in memory, which does not survive a crash of
+// course.  It records the history of the calls made to it and supports fault injection through the embedded
+// FaultInjector, which makes it a drop-in replacement of the durable outboxes in tests.  It is safe for concurrent use.
+type InMemoryOutbox struct {
+	*fault_injection.FaultInjector
+	lock    sync.RWMutex
+	intents map[string]outbox.Intent
+}
+
+// NewInMemoryOutbox constructs an empty InMemoryOutbox
+func NewInMemoryOutbox() *InMemoryOutbox {
+	return &InMemoryOutbox{
+		FaultInjector: fault_injection.NewFaultInjector(),
+		intents:       map[string]outbox.Intent{},
+	}
+}
+
+// Record keeps the intent in memory, replacing any pending intent for the same probe type
+func (o *InMemoryOutbox) Record(intent outbox.Intent) error {
+	if err := o.Inject("Record", intent.ProbeType, string(intent.Action)); err != nil {
+		return err
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.intents[intent.ProbeType] = intent
+	return nil
+}
+
+// Complete forgets the pending intent for the given probe type if it is the given action
+func (o *InMemoryOutbox) Complete(probeType string, action outbox.Action) error {
+	if err := o.Inject("Complete", probeType, string(action)); err != nil {
+		return err
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if intent, found := o.intents[probeType]; found && intent.Action == action {
+		delete(o.intents, probeType)
+	}
+	return nil
+}
+
+// List returns the pending intents, sorted by the probe type
+func (o *InMemoryOutbox) List() ([]outbox.Intent, error) {
+	if err := o.Inject("List"); err != nil {
+		return nil, err
+	}
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	intents := make([]outbox.Intent, 0, len(o.intents))
+	for _, intent := range o.intents {
+		intents = append(intents, intent)
+	}
+	outbox.SortIntents(intents)
+	return intents, nil
+}
@@ -0,0 +1,81 @@
+package in_memory_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/manager"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	probeinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	registrarinmemory "github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/in_memory"
+	"time"
+)
+
+var _ = Describe("In memory outbox", func() {
+	var o *in_memory.InMemoryOutbox
+	recordedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	BeforeEach(func() {
+		o = in_memory.NewInMemoryOutbox()
+	})
+
+	It("keeps one intent per probe type, and lists the pending ones to replay by probe type", func() {
+		Expect(o.List()).To(BeEmpty())
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+		Expect(o.Record(outbox.Intent{ProbeType: "aws/ec2", Action: outbox.ActionStartProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStopProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+		Expect(o.List()).To(Equal([]outbox.Intent{
+			{ProbeType: "aws/ec2", Action: outbox.ActionStartProbe, RecordedAt: recordedAt},
+			{ProbeType: "vcenter", Action: outbox.ActionStopProbe, RecordedAt: recordedAt},
+		}))
+
+		// completing another action leaves the intent superseding it alone
+		Expect(o.Complete("vcenter", outbox.ActionStartProbe)).To(Succeed())
+		Expect(o.List()).To(HaveLen(2))
+		Expect(o.Complete("vcenter", outbox.ActionStopProbe)).To(Succeed())
+		Expect(o.Complete("pure", outbox.ActionStopProbe)).To(Succeed())
+		Expect(o.List()).To(Equal([]outbox.Intent{
+			{ProbeType: "aws/ec2", Action: outbox.ActionStartProbe, RecordedAt: recordedAt},
+		}))
+	})
+
+	It("has the intents left pending by a crash replayed by the next manager", func() {
+		// The previous manager has registered the target but crashed before starting the probe
+		registrar := registrarinmemory.NewInMemoryRegistrar()
+		_, err := registrar.RegisterTarget(target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter",
+			Username: "user1", Password: "pass1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+		Expect(o.Record(outbox.Intent{ProbeType: "pure", Action: outbox.ActionStartProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+
+		controller := probeinmemory.NewInMemoryProbeController(nil)
+		m := manager.NewProbeLifecycleManager(registrar, controller).WithOutbox(o)
+		Expect(m.ReplayPendingIntents()).To(Equal(0))
+		Expect(controller.GetProbeState("vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		// the start of a probe without targets is stale, and forgotten without starting the probe
+		Expect(controller.GetProbeState("pure")).To(Equal(probe_controller.ProbeStateUnknown))
+		Expect(o.List()).To(BeEmpty())
+		Expect(o.CallsTo("Complete")).To(HaveLen(2))
+	})
+
+	It("records the calls and fails the ones it is told to, leaving the intents alone", func() {
+		o.FailNthCall("Record", 1, fmt.Errorf("outbox is down"))
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe})).To(
+			MatchError("outbox is down"))
+		Expect(o.List()).To(BeEmpty())
+
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe})).To(Succeed())
+		o.FailAllCalls("Complete", fmt.Errorf("outbox is down"))
+		Expect(o.Complete("vcenter", outbox.ActionStartProbe)).NotTo(Succeed())
+		Expect(o.List()).To(HaveLen(1))
+		Expect(o.CallsTo("Record")).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,109 @@
+package local_file
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/internal/atomic_file"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// LocalFileOutbox implements the Outbox interface keeping the intents in a JSON file on the local disk.  Every change
+// rewrites the whole file atomically, so that a crash leaves either the old or the new intents behind.  It is meant for
+// a single manager process, and is safe for concurrent use within it.
+type LocalFileOutbox struct {
+	path string
+	lock sync.Mutex
+}
+
+// NewLocalFileOutbox constructs a LocalFileOutbox keeping the intents in the file of the given path, creating the
+// directory of the file if needed
+func NewLocalFileOutbox(path string) (*LocalFileOutbox, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the directory of outbox file %v\n%v", path, err)
+	}
+	return &LocalFileOutbox{path: path}, nil
+}
+
+// Record writes the intent to the file, replacing any pending intent for the same probe type
+func (o *LocalFileOutbox) Record(intent outbox.Intent) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	intents, err := o.readIntents()
+	if err != nil {
+		return err
+	}
+	intents[intent.ProbeType] = intent
+	return o.writeIntents(intents)
+}
+
+// Complete removes the pending intent for the given probe type from the file if it is the given action
+func (o *LocalFileOutbox) Complete(probeType string, action outbox.Action) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	intents, err := o.readIntents()
+	if err != nil {
+		return err
+	}
+	if intent, found := intents[probeType]; !found || intent.Action != action {
+		return nil
+	}
+	delete(intents, probeType)
+	return o.writeIntents(intents)
+}
+
+// List returns the pending intents in the file, sorted by the probe type
+func (o *LocalFileOutbox) List() ([]outbox.Intent, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	intents, err := o.readIntents()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]outbox.Intent, 0, len(intents))
+	for _, intent := range intents {
+		list = append(list, intent)
+	}
+	outbox.SortIntents(list)
+	return list, nil
+}
+
+// readIntents reads the intents in the file keyed by the probe type, or none if the file does not exist yet
+func (o *LocalFileOutbox) readIntents() (map[string]outbox.Intent, error) {
+	intents := map[string]outbox.Intent{}
+	content, err := ioutil.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		return intents, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox file %v\n%v", o.path, err)
+	}
+	var list []outbox.Intent
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode outbox file %v\n%v", o.path, err)
+	}
+	for _, intent := range list {
+		intents[intent.ProbeType] = intent
+	}
+	return intents, nil
+}
+
+// writeIntents replaces the content of the file with the given intents, sorted by the probe type
+func (o *LocalFileOutbox) writeIntents(intents map[string]outbox.Intent) error {
+	list := make([]outbox.Intent, 0, len(intents))
+	for _, intent := range intents {
+		list = append(list, intent)
+	}
+	outbox.SortIntents(list)
+	content, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomic_file.WriteFile(o.path, content); err != nil {
+		return fmt.Errorf("failed to write outbox file %v\n%v", o.path, err)
+	}
+	return nil
+}
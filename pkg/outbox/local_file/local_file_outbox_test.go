@@ -0,0 +1,68 @@
+package local_file_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/outbox/local_file"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Local file outbox", func() {
+	var dir string
+	var path string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "outbox-")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "state", "outbox.json")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("keeps one intent per probe type across instances", func() {
+		o, err := local_file.NewLocalFileOutbox(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(o.List()).To(BeEmpty())
+
+		recordedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+		Expect(o.Record(outbox.Intent{ProbeType: "aws/ec2", Action: outbox.ActionStartProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStopProbe,
+			RecordedAt: recordedAt})).To(Succeed())
+
+		// a new instance, as after a restart, reads the same intents back
+		o, err = local_file.NewLocalFileOutbox(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(o.List()).To(Equal([]outbox.Intent{
+			{ProbeType: "aws/ec2", Action: outbox.ActionStartProbe, RecordedAt: recordedAt},
+			{ProbeType: "vcenter", Action: outbox.ActionStopProbe, RecordedAt: recordedAt},
+		}))
+
+		// completing another action leaves the intent superseding it alone
+		Expect(o.Complete("vcenter", outbox.ActionStartProbe)).To(Succeed())
+		Expect(o.List()).To(HaveLen(2))
+		Expect(o.Complete("vcenter", outbox.ActionStopProbe)).To(Succeed())
+		Expect(o.Complete("pure", outbox.ActionStopProbe)).To(Succeed())
+		Expect(o.List()).To(Equal([]outbox.Intent{
+			{ProbeType: "aws/ec2", Action: outbox.ActionStartProbe, RecordedAt: recordedAt},
+		}))
+	})
+
+	It("reports a corrupted file", func() {
+		o, err := local_file.NewLocalFileOutbox(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ioutil.WriteFile(path, []byte("not json"), 0600)).To(Succeed())
+		_, err = o.List()
+		Expect(err).To(HaveOccurred())
+		Expect(o.Record(outbox.Intent{ProbeType: "vcenter", Action: outbox.ActionStartProbe})).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,42 @@
+package outbox
+
+import (
+	"sort"
+	"time"
+)
+
+// Action is the change to a probe that an intent records as owed
+type Action string
+
+const (
+	// ActionStartProbe records a probe to start
+	ActionStartProbe Action = "StartProbe"
+	// ActionStopProbe records a probe to stop
+	ActionStopProbe Action = "StopProbe"
+)
+
+// Intent records a change to a probe that the manager owes the probe controller, from before the change is made until
+// the probe controller confirms it, so that it survives a crash in between
+type Intent struct {
+	ProbeType  string    `json:"probeType"`
+	Action     Action    `json:"action"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+// Outbox declares the interface of a durable journal of the intents.  It keeps at most one intent per probe type: a
+// later intent for the same probe type supersedes the earlier one.
+type Outbox interface {
+	// Record keeps the intent durably, replacing any pending intent for the same probe type
+	Record(intent Intent) error
+	// Complete forgets the pending intent for the given probe type, if any, as long as it is the given action
+	Complete(probeType string, action Action) error
+	// List returns the pending intents, sorted by the probe type
+	List() ([]Intent, error)
+}
+
+// SortIntents sorts the intents by the probe type
+func SortIntents(intents []Intent) {
+	sort.Slice(intents, func(i, j int) bool {
+		return intents[i].ProbeType < intents[j].ProbeType
+	})
+}
@@ -0,0 +1,81 @@
+package in_memory
+
+import (
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/fault_injection"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	"sync"
+)
+
+// InMemoryProbeController implements the ProbeController interface keeping the probe states in memory.  It records the
+// history of the calls made to it and supports fault injection through the embedded FaultInjector, which makes it a
+// drop-in replacement of the other probe controllers in tests.  It is safe for concurrent use.
+type InMemoryProbeController struct {
+	*fault_injection.FaultInjector
+	lock   sync.RWMutex
+	states map[string]probe_controller.ProbeState
+}
+
+// NewInMemoryProbeController constructs an InMemoryProbeController with the given initial probe states, which may be
+// nil
+func NewInMemoryProbeController(initialStates map[string]probe_controller.ProbeState) *InMemoryProbeController {
+	states := map[string]probe_controller.ProbeState{}
+	for probeType, state := range initialStates {
+		states[probeType] = state
+	}
+	return &InMemoryProbeController{
+		FaultInjector: fault_injection.NewFaultInjector(),
+		states:        states,
+	}
+}
+
+// StartProbe marks the probe enabled
+func (pc *InMemoryProbeController) StartProbe(probeType string) error {
+	if err := pc.Inject("StartProbe", probeType); err != nil {
+		return err
+	}
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	pc.states[probeType] = probe_controller.ProbeStateEnabled
+	return nil
+}
+
+// StopProbe marks the probe disabled
+func (pc *InMemoryProbeController) StopProbe(probeType string) error {
+	if err := pc.Inject("StopProbe", probeType); err != nil {
+		return err
+	}
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	pc.states[probeType] = probe_controller.ProbeStateDisabled
+	return nil
+}
+
+// GetProbeState returns the state of the probe, or the unknown state if the probe has never been started or stopped
+func (pc *InMemoryProbeController) GetProbeState(probeType string) (probe_controller.ProbeState, error) {
+	if err := pc.Inject("GetProbeState", probeType); err != nil {
+		return probe_controller.ProbeStateUnknown, err
+	}
+	pc.lock.RLock()
+	defer pc.lock.RUnlock()
+	if state, found := pc.states[probeType]; found {
+		return state, nil
+	}
+	return probe_controller.ProbeStateUnknown, nil
+}
+
+// ListProbes returns a copy of the states of all the probes ever started or stopped
+func (pc *InMemoryProbeController) ListProbes() (map[string]probe_controller.ProbeState, error) {
+	if err := pc.Inject("ListProbes"); err != nil {
+		return nil, err
+	}
+	pc.lock.RLock()
+	defer pc.lock.RUnlock()
+	states := make(map[string]probe_controller.ProbeState, len(pc.states))
+	for probeType, state := range pc.states {
+		states[probeType] = state
+	}
+	return states, nil
+}
+
+// Make sure InMemoryProbeController implements the ProbeController interface, or a compilation error will result
+var _ probe_controller.ProbeController = (*InMemoryProbeController)(nil)
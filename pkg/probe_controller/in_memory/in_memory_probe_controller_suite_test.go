@@ -0,0 +1,13 @@
+package in_memory_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestProbeController(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "In Memory Probe Controller Suite")
+}
@@ -0,0 +1,51 @@
+package in_memory_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/in_memory"
+)
+
+var _ = Describe("In memory probe controller", func() {
+	It("starts and stops the probes, and lists the probes ever started or stopped", func() {
+		initialStates := map[string]probe_controller.ProbeState{"pure": probe_controller.ProbeStateEnabled}
+		controller := in_memory.NewInMemoryProbeController(initialStates)
+		Expect(controller.GetProbeState("vcenter")).To(Equal(probe_controller.ProbeStateUnknown))
+
+		Expect(controller.StartProbe("vcenter")).To(Succeed())
+		Expect(controller.StopProbe("pure")).To(Succeed())
+		Expect(controller.GetProbeState("vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+		Expect(controller.ListProbes()).To(Equal(map[string]probe_controller.ProbeState{
+			"pure":    probe_controller.ProbeStateDisabled,
+			"vcenter": probe_controller.ProbeStateEnabled,
+		}))
+		// the initial states are copied, not shared
+		Expect(initialStates).To(Equal(map[string]probe_controller.ProbeState{
+			"pure": probe_controller.ProbeStateEnabled}))
+	})
+
+	It("returns a copy of the probe states", func() {
+		controller := in_memory.NewInMemoryProbeController(nil)
+		Expect(controller.ListProbes()).To(BeEmpty())
+		Expect(controller.StartProbe("vcenter")).To(Succeed())
+		states, err := controller.ListProbes()
+		Expect(err).NotTo(HaveOccurred())
+		states["vcenter"] = probe_controller.ProbeStateDisabled
+		Expect(controller.GetProbeState("vcenter")).To(Equal(probe_controller.ProbeStateEnabled))
+	})
+
+	It("records the calls and fails the ones it is told to, leaving the probes alone", func() {
+		controller := in_memory.NewInMemoryProbeController(nil)
+		controller.FailNthCall("StartProbe", 1, fmt.Errorf("xl resource is gone"))
+		Expect(controller.StartProbe("vcenter")).To(MatchError("xl resource is gone"))
+		Expect(controller.GetProbeState("vcenter")).To(Equal(probe_controller.ProbeStateUnknown))
+		Expect(controller.StartProbe("vcenter")).To(Succeed())
+		Expect(controller.CallsTo("StartProbe")).To(HaveLen(2))
+
+		controller.FailAllCalls("ListProbes", fmt.Errorf("xl resource is gone"))
+		_, err := controller.ListProbes()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,10 +1,25 @@
 package probe_controller
 
+// ProbeState describes whether a probe is currently enabled, disabled or in an unknown state
+type ProbeState string
+
+const (
+	// ProbeStateEnabled indicates the probe is enabled/started
+	ProbeStateEnabled ProbeState = "enabled"
+	// ProbeStateDisabled indicates the probe is disabled/stopped
+	ProbeStateDisabled ProbeState = "disabled"
+	// ProbeStateUnknown indicates the probe state cannot be determined, e.g. the probe has never been configured
+	ProbeStateUnknown ProbeState = "unknown"
+)
+
 // ProbeController is the interface defining a list of actions to control probes such as start probe and stop probe.
 type ProbeController interface {
 	// StartProbe starts a probe if not yet started
 	StartProbe(probeType string) error
 	// StopProbe stops a probe if it is started
 	StopProbe(probeType string) error
+	// GetProbeState returns the current state of a probe
+	GetProbeState(probeType string) (ProbeState, error)
+	// ListProbes returns the state of every probe known to this controller, keyed by the probe type
+	ListProbes() (map[string]ProbeState, error)
 }
-
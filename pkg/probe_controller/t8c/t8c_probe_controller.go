@@ -2,6 +2,7 @@ package t8c
 
 import (
 	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
 	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
@@ -50,17 +51,66 @@ func (pc *T8cProbeController) StopProbe(probeType string) error {
 	return pc.setEnabledFlag(probeType, false)
 }
 
+// GetProbeState returns the state of a probe by reading its enabled flag from the deployment CR.  A probe whose
+// enabled flag is missing or malformed is in the unknown state.
+func (pc *T8cProbeController) GetProbeState(probeType string) (probe_controller.ProbeState, error) {
+	cr, _, err := GetOrCreateCR(pc.v1beta1Client, pc.dynamicClient, pc.namespace)
+	if err != nil {
+		return probe_controller.ProbeStateUnknown, fmt.Errorf("failed to get the state of probe %v in namespace %v\n%v",
+			probeType, pc.namespace, err)
+	}
+	spec, _, err := unstructured.NestedMap(cr.Object, "spec")
+	if err != nil {
+		return probe_controller.ProbeStateUnknown, nil
+	}
+	return probeStateFromSpec(spec, probeType), nil
+}
+
+// ListProbes returns the state of every probe configured in the deployment CR.  A probe whose enabled flag is missing
+// or malformed is reported in the unknown state.
+func (pc *T8cProbeController) ListProbes() (map[string]probe_controller.ProbeState, error) {
+	cr, _, err := GetOrCreateCR(pc.v1beta1Client, pc.dynamicClient, pc.namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list probes in namespace %v\n%v", pc.namespace, err)
+	}
+	spec, _, err := unstructured.NestedMap(cr.Object, "spec")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list probes from a malformed spec in namespace %v\n%v", pc.namespace, err)
+	}
+	probes := map[string]probe_controller.ProbeState{}
+	for probeType := range spec {
+		probes[probeType] = probeStateFromSpec(spec, probeType)
+	}
+	return probes, nil
+}
+
+// probeStateFromSpec reads the enabled flag of the given probe from the spec section of the deployment CR
+func probeStateFromSpec(spec map[string]interface{}, probeType string) probe_controller.ProbeState {
+	enabled, found, err := unstructured.NestedBool(spec, probeType, "enabled")
+	if err != nil || !found {
+		return probe_controller.ProbeStateUnknown
+	}
+	if enabled {
+		return probe_controller.ProbeStateEnabled
+	}
+	return probe_controller.ProbeStateDisabled
+}
+
 // Set the enabled flag for a probe in the deployment CR
 func (pc *T8cProbeController) setEnabledFlag(probeType string, enabled bool) error {
 	cr, gvr, err := GetOrCreateCR(pc.v1beta1Client, pc.dynamicClient, pc.namespace)
 	if err != nil {
-		return fmt.Errorf("failed to enable probe %v in namespace %v\n%v", probeType, pc.namespace, err)
+		return fmt.Errorf("failed to set probe %v to enabled=%v in namespace %v\n%v", probeType, enabled, pc.namespace, err)
 	}
 	return clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
 		if err = unstructured.SetNestedField(cr.Object, enabled, "spec", probeType, "enabled"); err != nil {
-			return fmt.Errorf("failed to set probe %v to enabled in CR %v in namespace %v\n%v", probeType, cr, pc.namespace, err)
+			return fmt.Errorf("failed to set probe %v to enabled=%v in CR %v in namespace %v\n%v", probeType, enabled,
+				cr.GetName(), pc.namespace, err)
 		}
 		cr, err = pc.dynamicClient.Resource(*gvr).Namespace(pc.namespace).Update(cr, metav1.UpdateOptions{})
 		return err
 	})
 }
+
+// Make sure T8cProbeController implements the ProbeController interface, or a compilation error will result
+var _ probe_controller.ProbeController = (*T8cProbeController)(nil)
@@ -4,6 +4,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller"
 	"github.com/turbonomic/probe-lifecycle-manager/pkg/probe_controller/t8c"
 	v1beta1fake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/typed/apiextensions/v1beta1/fake"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -84,4 +85,42 @@ var _ = Describe("Test turbo probe controller", func() {
 			"appdynamics" : map[string]interface{}{"enabled": false},
 		}),
 	)
+	DescribeTable("test listing probes",
+		func(existingSpec map[string]interface{}, expectedProbes map[string]probe_controller.ProbeState) {
+			dynamicClient := dynamicfake.NewSimpleDynamicClient(t8c.Scheme)
+			v1beta1Client := v1beta1fake.FakeApiextensionsV1beta1{Fake: &dynamicClient.Fake}
+			cr, gvr, err := t8c.GetOrCreateCR(&v1beta1Client, dynamicClient, testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			err = unstructured.SetNestedMap(cr.Object, existingSpec, "spec")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = dynamicClient.Resource(*gvr).Namespace(testNamespace).Update(cr, metav1.UpdateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			probeController := t8c.NewT8cProbeControllerFromClient(&v1beta1Client, dynamicClient, testNamespace)
+			probes, err := probeController.ListProbes()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(probes).To(Equal(expectedProbes))
+			for probeType, expectedState := range expectedProbes {
+				Expect(probeController.GetProbeState(probeType)).To(Equal(expectedState))
+			}
+			Expect(probeController.GetProbeState("probeNotFound")).To(Equal(probe_controller.ProbeStateUnknown))
+		},
+		Entry("list probes when none exists", map[string]interface{}{},
+			map[string]probe_controller.ProbeState{}),
+		Entry("list enabled and disabled probes", map[string]interface{}{
+			"vcenter" : map[string]interface{}{"enabled": true},
+			"pure" : map[string]interface{}{"enabled": false},
+		}, map[string]probe_controller.ProbeState{
+			"vcenter": probe_controller.ProbeStateEnabled,
+			"pure": probe_controller.ProbeStateDisabled,
+		}),
+		Entry("list a probe without the enabled flag as unknown", map[string]interface{}{
+			"vcenter" : map[string]interface{}{"enabled": true},
+			"appdynamics" : map[string]interface{}{},
+		}, map[string]probe_controller.ProbeState{
+			"vcenter": probe_controller.ProbeStateEnabled,
+			"appdynamics": probe_controller.ProbeStateUnknown,
+		}),
+	)
 })
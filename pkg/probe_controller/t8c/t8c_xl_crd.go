@@ -100,11 +100,20 @@ func getGvrFromCrd(crd *v1beta1.CustomResourceDefinition) (*schema.GroupVersionR
 		}
 	}
 	if versionChosen == "" {
-		return nil, fmt.Errorf("failed to construct the GroupVersionResource without a valid served version from the CRD: %v", crd)
+		return nil, fmt.Errorf("failed to construct the GroupVersionResource without a valid served version from the CRD: %v", crd.Name)
 	}
 	return &schema.GroupVersionResource{Group: crd.Spec.Group, Version: versionChosen, Resource: crd.Spec.Names.Plural}, nil
 }
 
+// GetOrCreateGvr returns the GroupVersionResource of the XL custom resource, creating the CRD if not already created
+func GetOrCreateGvr(v1beta1Client clientv1beta1.ApiextensionsV1beta1Interface) (*schema.GroupVersionResource, error) {
+	crd, err := getOrCreateCRD(v1beta1Client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the t8c XL resource type without the CRD: %v", err)
+	}
+	return getGvrFromCrd(crd)
+}
+
 // GetOrCreateCR retrieves a XL CR from the given namespace if one exists.  If multiple exist, then the first one on
 // the list will be returned.  If none exists, then a default will be created.
 func GetOrCreateCR(v1beta1Client clientv1beta1.ApiextensionsV1beta1Interface, dynamicClient dynamic.Interface,
@@ -116,7 +125,7 @@ func GetOrCreateCR(v1beta1Client clientv1beta1.ApiextensionsV1beta1Interface, dy
 	}
 	gvr, err := getGvrFromCrd(crd)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get/create the t8c XL resource without being able to construct the GroupVersionResource from CRD (crd=%v)\n%v", crd, err)
+		return nil, nil, fmt.Errorf("failed to get/create the t8c XL resource without being able to construct the GroupVersionResource from CRD (crd=%v)\n%v", crd.Name, err)
 	}
 	// Look for any existing CR; return it if found
 	crList, err := dynamicClient.Resource(*gvr).Namespace(namespace).List(metav1.ListOptions{})
@@ -0,0 +1,105 @@
+package schedule
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+	"time"
+)
+
+// maxMergedWindows bounds the number of overlapping or adjacent windows merged when looking for the end of an open
+// window, so that a schedule whose windows never close does not loop forever
+const maxMergedWindows = 1000
+
+// Window is a maintenance window opening at the times given by a cron expression, and staying open for the given
+// duration, e.g. "0 22 * * 1-5" and 8 hours for the nights of the working days
+type Window struct {
+	// Start is a standard cron expression with five fields, minute, hour, day of month, month and day of week, or a
+	// descriptor such as "@daily"; it may be prefixed with "CRON_TZ=<time zone> " to use a time zone other than local
+	Start string
+	// Duration is how long the window stays open once opened
+	Duration time.Duration
+}
+
+// String returns the cron expression and the duration of the window
+func (w Window) String() string {
+	return fmt.Sprintf("%q for %v", w.Start, w.Duration)
+}
+
+// parsedWindow is a window with its cron expression parsed
+type parsedWindow struct {
+	start    cron.Schedule
+	duration time.Duration
+}
+
+// Schedule is a set of maintenance windows, open whenever any of its windows is open
+type Schedule struct {
+	windows []parsedWindow
+}
+
+// NewSchedule constructs a schedule open during the given windows.  It returns an error if a cron expression cannot be
+// parsed, or if a duration is not positive.
+func NewSchedule(windows ...Window) (*Schedule, error) {
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("a schedule needs at least one window")
+	}
+	s := &Schedule{}
+	for _, window := range windows {
+		if window.Duration <= 0 {
+			return nil, fmt.Errorf("window %v has a non-positive duration", window)
+		}
+		start, err := cron.ParseStandard(window.Start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the start of window %v\n%v", window, err)
+		}
+		s.windows = append(s.windows, parsedWindow{start: start, duration: window.Duration})
+	}
+	return s, nil
+}
+
+// IsOpen returns true if any window of the schedule is open at the given time
+func (s *Schedule) IsOpen(t time.Time) bool {
+	_, open := s.openUntil(t)
+	return open
+}
+
+// NextTransition returns the time the schedule next opens if closed at the given time, or next closes if open.  The
+// second return value is false if the schedule never changes again, e.g. its windows overlap without a gap.
+func (s *Schedule) NextTransition(t time.Time) (time.Time, bool) {
+	end, open := s.openUntil(t)
+	if !open {
+		var next time.Time
+		for _, window := range s.windows {
+			if start := window.start.Next(t); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+				next = start
+			}
+		}
+		return next, !next.IsZero()
+	}
+	// the schedule stays open as long as another window is open at the end of the current one
+	for i := 0; i < maxMergedWindows; i++ {
+		later, stillOpen := s.openUntil(end)
+		if !stillOpen {
+			return end, true
+		}
+		end = later
+	}
+	return time.Time{}, false
+}
+
+// openUntil returns the latest end among the windows open at the given time, and false if none is open
+func (s *Schedule) openUntil(t time.Time) (time.Time, bool) {
+	var end time.Time
+	open := false
+	for _, window := range s.windows {
+		// the first start after the window would have closed again is at or before t if the window is open at t
+		start := window.start.Next(t.Add(-window.duration))
+		if start.IsZero() || start.After(t) {
+			continue
+		}
+		if windowEnd := start.Add(window.duration); !open || windowEnd.After(end) {
+			end = windowEnd
+		}
+		open = true
+	}
+	return end, open
+}
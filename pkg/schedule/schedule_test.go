@@ -0,0 +1,61 @@
+package schedule_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/schedule"
+	"time"
+)
+
+// at returns the given time of day on Wednesday, 14 October 2026, in UTC
+func at(hour, minute int) time.Time {
+	return time.Date(2026, time.October, 14, hour, minute, 0, 0, time.UTC)
+}
+
+var _ = Describe("Schedule", func() {
+	// nights is open from 22:00 to 06:00 on the nights following the working days
+	var nights = schedule.Window{Start: "CRON_TZ=UTC 0 22 * * 1-5", Duration: 8 * time.Hour}
+	// lunch is open from 12:00 to 13:00 every day
+	var lunch = schedule.Window{Start: "CRON_TZ=UTC 0 12 * * *", Duration: time.Hour}
+
+	DescribeTable("telling whether the schedule is open and when it next changes",
+		func(windows []schedule.Window, now time.Time, expectedOpen bool, expectedNext time.Time) {
+			s, err := schedule.NewSchedule(windows...)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(s.IsOpen(now)).To(Equal(expectedOpen))
+			next, found := s.NextTransition(now)
+			Expect(found).To(BeTrue())
+			Expect(next).To(BeTemporally("==", expectedNext))
+		},
+		Entry("before a window", []schedule.Window{nights}, at(21, 59), false, at(22, 0)),
+		Entry("at the opening of a window", []schedule.Window{nights}, at(22, 0), true, at(22, 0).Add(8*time.Hour)),
+		Entry("within a window opened the day before", []schedule.Window{nights}, at(5, 0), true, at(6, 0)),
+		Entry("at the closing of a window", []schedule.Window{nights}, at(6, 0), false, at(22, 0)),
+		Entry("between two windows", []schedule.Window{nights, lunch}, at(9, 30), false, at(12, 0)),
+		Entry("within one of two windows", []schedule.Window{nights, lunch}, at(12, 30), true, at(13, 0)),
+		Entry("within overlapping windows", []schedule.Window{lunch,
+			{Start: "CRON_TZ=UTC 30 12 * * *", Duration: time.Hour}}, at(12, 15), true, at(13, 30)),
+		Entry("within adjacent windows", []schedule.Window{lunch,
+			{Start: "CRON_TZ=UTC 0 13 * * *", Duration: 2 * time.Hour}}, at(12, 15), true, at(15, 0)),
+	)
+
+	It("never changes when its windows leave no gap", func() {
+		s, err := schedule.NewSchedule(schedule.Window{Start: "@hourly", Duration: 2 * time.Hour})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.IsOpen(at(3, 15))).To(BeTrue())
+		_, found := s.NextTransition(at(3, 15))
+		Expect(found).To(BeFalse())
+	})
+
+	DescribeTable("rejecting invalid windows",
+		func(windows []schedule.Window) {
+			_, err := schedule.NewSchedule(windows...)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("no window", []schedule.Window{}),
+		Entry("an invalid cron expression", []schedule.Window{{Start: "0 25 * * *", Duration: time.Hour}}),
+		Entry("a cron expression with seconds", []schedule.Window{{Start: "0 0 22 * * *", Duration: time.Hour}}),
+		Entry("a non-positive duration", []schedule.Window{{Start: "@daily", Duration: 0}}),
+	)
+})
@@ -0,0 +1,79 @@
+package target_registrar
+
+import (
+	"gopkg.in/yaml.v2"
+	"regexp"
+)
+
+// awsAccessKeyIdPattern matches the format of an AWS access key id
+var awsAccessKeyIdPattern = regexp.MustCompile(`^[A-Z0-9]{16,128}$`)
+
+// AWSTarget describes a structure for targets authenticating with an AWS access key pair, optionally with the session
+// token of temporary credentials
+type AWSTarget struct {
+	Id              string
+	Probetype       string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+}
+
+func (t AWSTarget) GetId() string {
+	return t.Id
+}
+
+func (t AWSTarget) GetProbeType() string {
+	return t.Probetype
+}
+
+func (t AWSTarget) Bytes() ([]byte, error) {
+	return yaml.Marshal(&t)
+}
+
+// Validate checks that the access key pair is set and that the access key id is well-formed
+func (t AWSTarget) Validate() error {
+	errs := requiredFields{{"AccessKeyId", t.AccessKeyId}, {"SecretAccessKey", t.SecretAccessKey}}.validate()
+	if t.AccessKeyId != "" && !awsAccessKeyIdPattern.MatchString(t.AccessKeyId) {
+		errs = append(errs, fieldError("AccessKeyId", "not a well-formed AWS access key id"))
+	}
+	return aggregate(errs)
+}
+
+// SafeString describes the target by its probe type and id, leaving out the access key pair
+func (t AWSTarget) SafeString() string {
+	return safeString(t.Probetype, t.Id)
+}
+
+// String redacts the target when formatted with %v or %s
+func (t AWSTarget) String() string {
+	return t.SafeString()
+}
+
+// GoString redacts the target when formatted with %#v
+func (t AWSTarget) GoString() string {
+	return t.SafeString()
+}
+
+// Make sure AWSTarget implements the Target interface, or a compilation error will result
+var _ Target = (*AWSTarget)(nil)
+var _ SafeStringer = (*AWSTarget)(nil)
+var _ Validator = (*AWSTarget)(nil)
+
+// AWSKind is the kind under which AWSTarget is registered with the default codec registry
+const AWSKind = "AWS"
+
+func init() {
+	if err := RegisterTargetKind(AWSKind, "v1", AWSTarget{}, func(bytes []byte) (Target, error) {
+		var target AWSTarget
+		err := AWSTargetFromBytes(bytes, &target)
+		return target, err
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// Unmarshal the input byte array into a AWSTarget object
+func AWSTargetFromBytes(bytes []byte, target *AWSTarget) error {
+	return yaml.Unmarshal(bytes, target)
+}
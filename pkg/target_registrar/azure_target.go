@@ -0,0 +1,84 @@
+package target_registrar
+
+import (
+	"gopkg.in/yaml.v2"
+	"regexp"
+)
+
+// azureGuidPattern matches the GUID format of Azure client and subscription ids
+var azureGuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// AzureServicePrincipalTarget describes a structure for targets authenticating with an Azure service principal
+type AzureServicePrincipalTarget struct {
+	Id             string
+	Probetype      string
+	TenantId       string
+	ClientId       string
+	ClientSecret   string
+	SubscriptionId string
+}
+
+func (t AzureServicePrincipalTarget) GetId() string {
+	return t.Id
+}
+
+func (t AzureServicePrincipalTarget) GetProbeType() string {
+	return t.Probetype
+}
+
+func (t AzureServicePrincipalTarget) Bytes() ([]byte, error) {
+	return yaml.Marshal(&t)
+}
+
+// Validate checks that the service principal is fully set and that the client and subscription ids are GUIDs
+func (t AzureServicePrincipalTarget) Validate() error {
+	errs := requiredFields{{"TenantId", t.TenantId}, {"ClientId", t.ClientId}, {"ClientSecret", t.ClientSecret},
+		{"SubscriptionId", t.SubscriptionId}}.validate()
+	if t.ClientId != "" && !azureGuidPattern.MatchString(t.ClientId) {
+		errs = append(errs, fieldError("ClientId", "not a GUID"))
+	}
+	if t.SubscriptionId != "" && !azureGuidPattern.MatchString(t.SubscriptionId) {
+		errs = append(errs, fieldError("SubscriptionId", "not a GUID"))
+	}
+	return aggregate(errs)
+}
+
+// SafeString describes the target by its probe type and id, leaving out the client secret
+func (t AzureServicePrincipalTarget) SafeString() string {
+	return safeString(t.Probetype, t.Id)
+}
+
+// String redacts the target when formatted with %v or %s
+func (t AzureServicePrincipalTarget) String() string {
+	return t.SafeString()
+}
+
+// GoString redacts the target when formatted with %#v
+func (t AzureServicePrincipalTarget) GoString() string {
+	return t.SafeString()
+}
+
+// Make sure AzureServicePrincipalTarget implements the Target interface, or a compilation error will result
+var _ Target = (*AzureServicePrincipalTarget)(nil)
+var _ SafeStringer = (*AzureServicePrincipalTarget)(nil)
+var _ Validator = (*AzureServicePrincipalTarget)(nil)
+
+// AzureServicePrincipalKind is the kind under which AzureServicePrincipalTarget is registered with the default codec
+// registry
+const AzureServicePrincipalKind = "AzureServicePrincipal"
+
+func init() {
+	if err := RegisterTargetKind(AzureServicePrincipalKind, "v1", AzureServicePrincipalTarget{},
+		func(bytes []byte) (Target, error) {
+			var target AzureServicePrincipalTarget
+			err := AzureServicePrincipalTargetFromBytes(bytes, &target)
+			return target, err
+		}); err != nil {
+		panic(err)
+	}
+}
+
+// Unmarshal the input byte array into a AzureServicePrincipalTarget object
+func AzureServicePrincipalTargetFromBytes(bytes []byte, target *AzureServicePrincipalTarget) error {
+	return yaml.Unmarshal(bytes, target)
+}
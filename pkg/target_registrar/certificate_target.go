@@ -0,0 +1,98 @@
+package target_registrar
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"gopkg.in/yaml.v2"
+)
+
+// CertificateTarget describes a structure for targets authenticating with a client certificate.  The certificate, the
+// private key and the optional CA bundle are all PEM-encoded.
+type CertificateTarget struct {
+	Id          string
+	Probetype   string
+	Certificate string
+	Key         string
+	CA          string
+}
+
+func (t CertificateTarget) GetId() string {
+	return t.Id
+}
+
+func (t CertificateTarget) GetProbeType() string {
+	return t.Probetype
+}
+
+func (t CertificateTarget) Bytes() ([]byte, error) {
+	return yaml.Marshal(&t)
+}
+
+// Validate checks that the certificate and the key are set, parse as PEM and match each other, and that the CA bundle,
+// if set, contains only PEM certificates
+func (t CertificateTarget) Validate() error {
+	errs := requiredFields{{"Certificate", t.Certificate}, {"Key", t.Key}}.validate()
+	if len(errs) == 0 {
+		if _, err := tls.X509KeyPair([]byte(t.Certificate), []byte(t.Key)); err != nil {
+			errs = append(errs, fieldError("Key", "not a valid PEM key pair with the certificate: %v", err))
+		}
+	}
+	if t.CA != "" && !isPEMCertificateBundle([]byte(t.CA)) {
+		errs = append(errs, fieldError("CA", "not a valid bundle of PEM certificates"))
+	}
+	return aggregate(errs)
+}
+
+// isPEMCertificateBundle returns true if the input consists of one or more PEM-encoded x509 certificates
+func isPEMCertificateBundle(bundle []byte) bool {
+	found := false
+	for block, rest := pem.Decode(bundle); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			return false
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return false
+		}
+		found = true
+	}
+	return found
+}
+
+// SafeString describes the target by its probe type and id, leaving out the certificate and the key
+func (t CertificateTarget) SafeString() string {
+	return safeString(t.Probetype, t.Id)
+}
+
+// String redacts the target when formatted with %v or %s
+func (t CertificateTarget) String() string {
+	return t.SafeString()
+}
+
+// GoString redacts the target when formatted with %#v
+func (t CertificateTarget) GoString() string {
+	return t.SafeString()
+}
+
+// Make sure CertificateTarget implements the Target interface, or a compilation error will result
+var _ Target = (*CertificateTarget)(nil)
+var _ SafeStringer = (*CertificateTarget)(nil)
+var _ Validator = (*CertificateTarget)(nil)
+
+// CertificateKind is the kind under which CertificateTarget is registered with the default codec registry
+const CertificateKind = "Certificate"
+
+func init() {
+	if err := RegisterTargetKind(CertificateKind, "v1", CertificateTarget{}, func(bytes []byte) (Target, error) {
+		var target CertificateTarget
+		err := CertificateTargetFromBytes(bytes, &target)
+		return target, err
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// Unmarshal the input byte array into a CertificateTarget object
+func CertificateTargetFromBytes(bytes []byte, target *CertificateTarget) error {
+	return yaml.Unmarshal(bytes, target)
+}
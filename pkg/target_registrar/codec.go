@@ -0,0 +1,200 @@
+package target_registrar
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"sync"
+)
+
+const (
+	// RawKind is the kind recorded for targets whose Go type is not registered with the codec registry
+	RawKind = "Raw"
+	// RawVersion is the version recorded for targets whose Go type is not registered with the codec registry
+	RawVersion = "v1"
+)
+
+// TargetDecoder decodes the bytes of a target, as returned by Target.Bytes(), back to the concrete target
+type TargetDecoder func(data []byte) (Target, error)
+
+// LocationBoundTarget is a target whose payload is bound to the probe type and id it is stored under, e.g. a sealed
+// target, so that its payload copied or moved to another target in the backend fails the decoding rather than being
+// read back under the probe type and id recorded in the payload
+type LocationBoundTarget interface {
+	Target
+	// CheckLocation returns an error if the target cannot be stored under the given probe type and id
+	CheckLocation(probeType string, id string) error
+}
+
+// TargetEnvelope is what a registrar stores for each target: the bytes of the target along with its kind and version,
+// so that the target can be decoded back to its concrete type.  The payload is kept as a string for readability; yaml
+// encodes it in base64 if it is not valid UTF-8.
+type TargetEnvelope struct {
+	Kind    string `yaml:"kind"`
+	Version string `yaml:"version"`
+	Payload string `yaml:"payload"`
+}
+
+// kindVersion identifies a registered target decoder
+type kindVersion struct {
+	kind    string
+	version string
+}
+
+// CodecRegistry maps the Go types of targets to their kinds and versions, and the kinds and versions to the decoders
+type CodecRegistry struct {
+	lock sync.RWMutex
+	// decoders maps every registered kind and version to its decoder
+	decoders map[kindVersion]TargetDecoder
+	// typeKinds maps every registered Go type to the kind and version used to encode it
+	typeKinds map[reflect.Type]kindVersion
+	// probeTypeKinds maps a probe type to the kind used to decode its targets stored without an envelope
+	probeTypeKinds map[string]kindVersion
+}
+
+// DefaultCodecRegistry is the codec registry used by the registrars, with all the built-in target types registered
+var DefaultCodecRegistry = NewCodecRegistry()
+
+// NewCodecRegistry constructs an empty CodecRegistry
+func NewCodecRegistry() *CodecRegistry {
+	return &CodecRegistry{
+		decoders:       map[kindVersion]TargetDecoder{},
+		typeKinds:      map[reflect.Type]kindVersion{},
+		probeTypeKinds: map[string]kindVersion{},
+	}
+}
+
+// RegisterKind registers the Go type of the prototype target under the given kind and version, along with the decoder
+// of that kind and version.  Registering a newer version for the same Go type makes it the version used for encoding,
+// while the decoders of the older versions remain available to read the targets stored before.
+func (r *CodecRegistry) RegisterKind(kind string, version string, prototype Target, decoder TargetDecoder) error {
+	if kind == "" || version == "" || decoder == nil {
+		return fmt.Errorf("failed to register target kind %q version %q without a kind, a version and a decoder",
+			kind, version)
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := kindVersion{kind: kind, version: version}
+	if _, found := r.decoders[key]; found {
+		return fmt.Errorf("target kind %v version %v is already registered", kind, version)
+	}
+	r.decoders[key] = decoder
+	if prototype != nil {
+		r.typeKinds[targetType(prototype)] = key
+	}
+	return nil
+}
+
+// RegisterProbeTypeKind registers the kind and version to decode the targets of the given probe type that have been
+// stored without an envelope, i.e. by an earlier version of this library
+func (r *CodecRegistry) RegisterProbeTypeKind(probeType string, kind string, version string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := kindVersion{kind: kind, version: version}
+	if _, found := r.decoders[key]; !found {
+		return fmt.Errorf("failed to register probe type %v with target kind %v version %v that is not registered",
+			probeType, kind, version)
+	}
+	r.probeTypeKinds[probeType] = key
+	return nil
+}
+
+// Encode encodes the target into an envelope recording its kind and version.  A RawTarget keeps the kind and version
+// it has been decoded with; a target of an unregistered Go type is recorded as the raw kind.
+func (r *CodecRegistry) Encode(target Target) ([]byte, error) {
+	payload, err := target.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	envelope := TargetEnvelope{Kind: RawKind, Version: RawVersion, Payload: string(payload)}
+	if rawTarget, isRaw := target.(RawTarget); isRaw && rawTarget.Kind != "" {
+		envelope.Kind, envelope.Version = rawTarget.Kind, rawTarget.Version
+	} else if key, found := r.kindOf(target); found {
+		envelope.Kind, envelope.Version = key.kind, key.version
+	}
+	return yaml.Marshal(&envelope)
+}
+
+// Decode decodes the stored bytes of the target of the given probe type and id back to its concrete type.  Bytes
+// stored without an envelope are decoded with the kind registered for the probe type.  If no decoder is found, the
+// target is returned as a RawTarget.  A LocationBoundTarget stored under another probe type or id than its own fails
+// the decoding.
+func (r *CodecRegistry) Decode(probeType string, id string, data []byte) (Target, error) {
+	rawTarget := RawTarget{Id: id, Probetype: probeType, Data: data}
+	var envelope TargetEnvelope
+	if err := yaml.Unmarshal(data, &envelope); err == nil && envelope.Kind != "" && envelope.Version != "" {
+		rawTarget.Kind, rawTarget.Version, rawTarget.Data = envelope.Kind, envelope.Version, []byte(envelope.Payload)
+	} else if key, found := r.probeTypeKind(probeType); found {
+		rawTarget.Kind, rawTarget.Version = key.kind, key.version
+	}
+
+	decoder, found := r.decoder(kindVersion{kind: rawTarget.Kind, version: rawTarget.Version})
+	if !found {
+		return rawTarget, nil
+	}
+	target, err := decoder(rawTarget.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode target %v of probe type %v as kind %v version %v\n%v",
+			id, probeType, rawTarget.Kind, rawTarget.Version, err)
+	}
+	if boundTarget, isBound := target.(LocationBoundTarget); isBound {
+		if err := boundTarget.CheckLocation(probeType, id); err != nil {
+			return nil, err
+		}
+	}
+	return target, nil
+}
+
+// kindOf returns the kind and version registered for the Go type of the target
+func (r *CodecRegistry) kindOf(target Target) (kindVersion, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	key, found := r.typeKinds[targetType(target)]
+	return key, found
+}
+
+// probeTypeKind returns the kind and version registered for the targets of the probe type stored without an envelope
+func (r *CodecRegistry) probeTypeKind(probeType string) (kindVersion, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	key, found := r.probeTypeKinds[probeType]
+	return key, found
+}
+
+// decoder returns the decoder registered for the given kind and version
+func (r *CodecRegistry) decoder(key kindVersion) (TargetDecoder, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	decoder, found := r.decoders[key]
+	return decoder, found
+}
+
+// targetType returns the Go type of the target, dereferencing pointers so that a type and its pointer share a kind
+func targetType(target Target) reflect.Type {
+	t := reflect.TypeOf(target)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// RegisterTargetKind registers a target kind with the default codec registry; see CodecRegistry.RegisterKind
+func RegisterTargetKind(kind string, version string, prototype Target, decoder TargetDecoder) error {
+	return DefaultCodecRegistry.RegisterKind(kind, version, prototype, decoder)
+}
+
+// RegisterProbeTypeKind registers the kind of a probe type with the default codec registry; see
+// CodecRegistry.RegisterProbeTypeKind
+func RegisterProbeTypeKind(probeType string, kind string, version string) error {
+	return DefaultCodecRegistry.RegisterProbeTypeKind(probeType, kind, version)
+}
+
+// EncodeTarget encodes the target with the default codec registry; see CodecRegistry.Encode
+func EncodeTarget(target Target) ([]byte, error) {
+	return DefaultCodecRegistry.Encode(target)
+}
+
+// DecodeTarget decodes the stored bytes of a target with the default codec registry; see CodecRegistry.Decode
+func DecodeTarget(probeType string, id string, data []byte) (Target, error) {
+	return DefaultCodecRegistry.Decode(probeType, id, data)
+}
@@ -0,0 +1,104 @@
+package target_registrar_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"gopkg.in/yaml.v2"
+)
+
+// tokenTargetV1 and tokenTargetV2 are two versions of a test target kind, the latter renaming a field
+type tokenTargetV1 struct {
+	Id        string
+	Probetype string
+	Key       string
+}
+
+func (t tokenTargetV1) GetId() string          { return t.Id }
+func (t tokenTargetV1) GetProbeType() string   { return t.Probetype }
+func (t tokenTargetV1) Bytes() ([]byte, error) { return yaml.Marshal(&t) }
+
+type tokenTargetV2 struct {
+	Id        string
+	Probetype string
+	Token     string
+}
+
+func (t tokenTargetV2) GetId() string          { return t.Id }
+func (t tokenTargetV2) GetProbeType() string   { return t.Probetype }
+func (t tokenTargetV2) Bytes() ([]byte, error) { return yaml.Marshal(&t) }
+
+var _ = Describe("Test target codec registry", func() {
+	var codecs *target_registrar.CodecRegistry
+
+	BeforeEach(func() {
+		codecs = target_registrar.NewCodecRegistry()
+		Expect(codecs.RegisterKind("Token", "v1", tokenTargetV1{}, func(bytes []byte) (target_registrar.Target, error) {
+			var v1 tokenTargetV1
+			if err := yaml.Unmarshal(bytes, &v1); err != nil {
+				return nil, err
+			}
+			// migrate to the latest version upon decoding
+			return tokenTargetV2{Id: v1.Id, Probetype: v1.Probetype, Token: v1.Key}, nil
+		})).To(Succeed())
+	})
+
+	It("round-trips a target of a registered kind", func() {
+		target := target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter", Username: "user1", Password: "pass1"}
+		bytes, err := target_registrar.EncodeTarget(target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target_registrar.DecodeTarget("vcenter", "Moid1", bytes)).To(Equal(target))
+	})
+
+	It("decodes the targets stored under an older version of a kind", func() {
+		oldBytes, err := codecs.Encode(tokenTargetV1{Id: "Moid1", Probetype: "pure", Key: "secret"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(codecs.RegisterKind("Token", "v2", tokenTargetV2{}, func(bytes []byte) (target_registrar.Target, error) {
+			var v2 tokenTargetV2
+			err := yaml.Unmarshal(bytes, &v2)
+			return v2, err
+		})).To(Succeed())
+
+		expectedTarget := tokenTargetV2{Id: "Moid1", Probetype: "pure", Token: "secret"}
+		Expect(codecs.Decode("pure", "Moid1", oldBytes)).To(Equal(expectedTarget))
+		newBytes, err := codecs.Encode(expectedTarget)
+		Expect(err).NotTo(HaveOccurred())
+		var envelope target_registrar.TargetEnvelope
+		Expect(yaml.Unmarshal(newBytes, &envelope)).To(Succeed())
+		Expect(envelope.Version).To(Equal("v2"))
+		Expect(codecs.Decode("pure", "Moid1", newBytes)).To(Equal(expectedTarget))
+	})
+
+	It("keeps the kind of a target without a decoder across a round trip", func() {
+		bytes, err := codecs.Encode(target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter"})
+		Expect(err).NotTo(HaveOccurred())
+		target, err := codecs.Decode("vcenter", "Moid1", bytes)
+		Expect(err).NotTo(HaveOccurred())
+		rawTarget, isRaw := target.(target_registrar.RawTarget)
+		Expect(isRaw).To(BeTrue())
+		Expect(rawTarget.Kind).To(Equal(target_registrar.RawKind))
+
+		rawTarget.Kind, rawTarget.Version = "Future", "v9"
+		bytes, err = codecs.Encode(rawTarget)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(codecs.Decode("vcenter", "Moid1", bytes)).To(Equal(rawTarget))
+	})
+
+	It("decodes the targets stored without an envelope by their probe type", func() {
+		legacyBytes, err := tokenTargetV1{Id: "Moid1", Probetype: "pure", Key: "secret"}.Bytes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(codecs.Decode("pure", "Moid1", legacyBytes)).To(Equal(
+			target_registrar.RawTarget{Id: "Moid1", Probetype: "pure", Data: legacyBytes}))
+
+		Expect(codecs.RegisterProbeTypeKind("pure", "Token", "v1")).To(Succeed())
+		Expect(codecs.Decode("pure", "Moid1", legacyBytes)).To(Equal(
+			tokenTargetV2{Id: "Moid1", Probetype: "pure", Token: "secret"}))
+	})
+
+	It("refuses to register a kind twice or a probe type with an unknown kind", func() {
+		Expect(codecs.RegisterKind("Token", "v1", tokenTargetV1{}, func(bytes []byte) (target_registrar.Target, error) {
+			return nil, nil
+		})).NotTo(Succeed())
+		Expect(codecs.RegisterProbeTypeKind("pure", "Token", "v3")).NotTo(Succeed())
+	})
+})
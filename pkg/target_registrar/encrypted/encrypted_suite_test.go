@@ -0,0 +1,13 @@
+package encrypted_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestEncrypted(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Encrypting Registrar Suite")
+}
@@ -0,0 +1,289 @@
+package encrypted
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// dataKeySize is the size of the AES-256 data keys generated for every target
+const dataKeySize = 32
+
+// EncryptingRegistrar wraps any Registrar so that the target info is encrypted before it reaches the backend.  Each
+// target is sealed with AES-GCM under its own random data key, and the data key is wrapped by a key-encryption key held
+// by a KMS.  The wrapped registrar only ever sees SealedTarget objects, which leave the probe type and the id in clear.
+type EncryptingRegistrar struct {
+	registrar target_registrar.Registrar
+	kms       KMS
+	// plaintextMigration accepts the targets stored in clear before the encryption has been turned on
+	plaintextMigration bool
+}
+
+// NewEncryptingRegistrar constructs an EncryptingRegistrar storing the sealed targets in the given registrar, with the
+// data keys wrapped by the given KMS
+func NewEncryptingRegistrar(registrar target_registrar.Registrar, kms KMS) *EncryptingRegistrar {
+	return &EncryptingRegistrar{registrar: registrar, kms: kms}
+}
+
+// WithPlaintextMigration accepts reading the targets stored in clear by the wrapped registrar before the encryption has
+// been turned on, until RotateKeys() or a new registration seals them.  Without it, a target stored in clear is
+// rejected, so that the encryption cannot be downgraded by writing target info in clear to the backend.
+func (r *EncryptingRegistrar) WithPlaintextMigration() *EncryptingRegistrar {
+	r.plaintextMigration = true
+	return r
+}
+
+// ValidateTarget passes the target on to the wrapped registrar for validation, if it puts any extra constraint on the
+// targets
+func (r *EncryptingRegistrar) ValidateTarget(target target_registrar.Target) error {
+	if validatingRegistrar, ok := r.registrar.(target_registrar.ValidatingRegistrar); ok {
+		return validatingRegistrar.ValidateTarget(target)
+	}
+	return nil
+}
+
+// RegisterTarget seals the target and registers it with the wrapped registrar.  As sealing the same target twice
+// gives different ciphertexts, the target is compared with the one registered already sealed before sealing it, and is
+// left alone if the two are the same.  A target stored in clear is replaced with the sealed one.
+func (r *EncryptingRegistrar) RegisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	storedTarget, err := r.registrar.GetTarget(target.GetProbeType(), target.GetId())
+	if err != nil {
+		return target_registrar.RegistrationResult{}, err
+	}
+	if _, isSealed := storedTarget.(SealedTarget); isSealed {
+		existingTarget, err := r.unseal(storedTarget, target.GetProbeType(), target.GetId())
+		if err != nil {
+			return target_registrar.RegistrationResult{}, err
+		}
+		existingData, err := target_registrar.EncodeTarget(existingTarget)
+		if err != nil {
+			return target_registrar.RegistrationResult{}, err
+		}
+		newData, err := target_registrar.EncodeTarget(target)
+		if err != nil {
+			return target_registrar.RegistrationResult{}, err
+		}
+		if bytes.Equal(existingData, newData) {
+			count, err := r.registrar.CountTargets(target.GetProbeType())
+			if err != nil {
+				return target_registrar.RegistrationResult{}, err
+			}
+			return target_registrar.NewRegisterResult(existingData, true, newData, count), nil
+		}
+	}
+	sealedTarget, err := r.seal(target)
+	if err != nil {
+		return target_registrar.RegistrationResult{}, err
+	}
+	return r.registrar.RegisterTarget(sealedTarget)
+}
+
+// UnregisterTarget unregisters the target from the wrapped registrar; no key is needed for that
+func (r *EncryptingRegistrar) UnregisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	return r.registrar.UnregisterTarget(target)
+}
+
+// ListProbeTypes returns the probe types listed by the wrapped registrar
+func (r *EncryptingRegistrar) ListProbeTypes() ([]string, error) {
+	return r.registrar.ListProbeTypes()
+}
+
+// CountTargets returns the number of targets counted by the wrapped registrar
+func (r *EncryptingRegistrar) CountTargets(probeType string) (int, error) {
+	return r.registrar.CountTargets(probeType)
+}
+
+// ListTargets lists the targets of the given probe type from the wrapped registrar and unseals them, each bound to the
+// probe type listed and the id it is listed under: the wrapped registrar fails to decode a sealed target stored under
+// another id than its own, as a SealedTarget is a target_registrar.LocationBoundTarget.  A sealed target listed twice
+// has been copied to another target in the backend, and fails the listing as well.  The targets registered before the
+// encryption has been turned on are returned as they are with the plaintext migration only.
+func (r *EncryptingRegistrar) ListTargets(probeType string) ([]target_registrar.Target, error) {
+	storedTargets, err := r.registrar.ListTargets(probeType)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]target_registrar.Target, 0, len(storedTargets))
+	listed := map[string]bool{}
+	for _, storedTarget := range storedTargets {
+		if listed[storedTarget.GetId()] {
+			return nil, fmt.Errorf("target %v is stored more than once", target_registrar.SafeString(storedTarget))
+		}
+		listed[storedTarget.GetId()] = true
+		target, err := r.unseal(storedTarget, probeType, storedTarget.GetId())
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// GetTarget gets the target of the given probe type and id from the wrapped registrar and unseals it, or returns nil if
+// not found.  The sealed target must have been sealed for that probe type and id.
+func (r *EncryptingRegistrar) GetTarget(probeType string, id string) (target_registrar.Target, error) {
+	storedTarget, err := r.registrar.GetTarget(probeType, id)
+	if err != nil || storedTarget == nil {
+		return nil, err
+	}
+	return r.unseal(storedTarget, probeType, id)
+}
+
+// RotateKeys re-wraps the data key of every stored target that has not been wrapped by the current key-encryption key
+// of the KMS, leaving the sealed target info itself untouched.  The targets registered before the encryption has been
+// turned on get sealed in the process.  It returns the number of targets rewritten, along with all the errors met on
+// the way.
+func (r *EncryptingRegistrar) RotateKeys() (int, error) {
+	currentKeyId, err := r.kms.CurrentKeyId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the current key-encryption key\n%v", err)
+	}
+	probeTypes, err := r.registrar.ListProbeTypes()
+	if err != nil {
+		return 0, err
+	}
+	rewritten := 0
+	var errs []error
+	for _, probeType := range probeTypes {
+		storedTargets, err := r.registrar.ListTargets(probeType)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, storedTarget := range storedTargets {
+			var newTarget target_registrar.Target
+			if sealedTarget, isSealed := storedTarget.(SealedTarget); !isSealed {
+				newTarget, err = r.seal(storedTarget)
+			} else if sealedTarget.KeyId != currentKeyId {
+				newTarget, err = r.rewrap(sealedTarget)
+			} else {
+				continue
+			}
+			if err == nil {
+				_, err = r.registrar.RegisterTarget(newTarget)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to rotate the key of target %v\n%v",
+					target_registrar.SafeString(storedTarget), err))
+				continue
+			}
+			rewritten++
+		}
+	}
+	return rewritten, utilerrors.NewAggregate(errs)
+}
+
+// seal encodes the target, encrypts it under a new data key and wraps the data key with the current key-encryption key
+func (r *EncryptingRegistrar) seal(target target_registrar.Target) (SealedTarget, error) {
+	plaintext, err := target_registrar.EncodeTarget(target)
+	if err != nil {
+		return SealedTarget{}, fmt.Errorf("failed to encode target %v\n%v", target_registrar.SafeString(target), err)
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return SealedTarget{}, fmt.Errorf("failed to generate a data key for target %v\n%v",
+			target_registrar.SafeString(target), err)
+	}
+	ciphertext, err := seal(dataKey, plaintext, additionalData(target.GetProbeType(), target.GetId()))
+	if err != nil {
+		return SealedTarget{}, fmt.Errorf("failed to seal target %v\n%v", target_registrar.SafeString(target), err)
+	}
+	keyId, wrappedKey, err := r.kms.Wrap(dataKey)
+	if err != nil {
+		return SealedTarget{}, fmt.Errorf("failed to wrap the data key of target %v\n%v",
+			target_registrar.SafeString(target), err)
+	}
+	return SealedTarget{
+		Id:         target.GetId(),
+		Probetype:  target.GetProbeType(),
+		KeyId:      keyId,
+		WrappedKey: wrappedKey,
+		Ciphertext: ciphertext,
+	}, nil
+}
+
+// unseal unwraps the data key of the target stored under the given probe type and id, decrypts the target info bound
+// to that location and decodes it to its concrete type.  A stored target that is not sealed is returned as it is with
+// the plaintext migration only.
+func (r *EncryptingRegistrar) unseal(storedTarget target_registrar.Target, probeType string,
+	id string) (target_registrar.Target, error) {
+	sealedTarget, isSealed := storedTarget.(SealedTarget)
+	if !isSealed {
+		if !r.plaintextMigration {
+			return nil, fmt.Errorf("target %v is stored in clear, which is only accepted with the plaintext migration",
+				target_registrar.SafeString(storedTarget))
+		}
+		return storedTarget, nil
+	}
+	if sealedTarget.Probetype != probeType || sealedTarget.Id != id {
+		return nil, fmt.Errorf("target %v is stored as target %v/%v", sealedTarget.SafeString(), probeType, id)
+	}
+	dataKey, err := r.kms.Unwrap(sealedTarget.KeyId, sealedTarget.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key of target %v\n%v", sealedTarget.SafeString(), err)
+	}
+	plaintext, err := open(dataKey, sealedTarget.Ciphertext, additionalData(probeType, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal target %v\n%v", sealedTarget.SafeString(), err)
+	}
+	return target_registrar.DecodeTarget(probeType, id, plaintext)
+}
+
+// rewrap wraps the data key of the sealed target with the current key-encryption key
+func (r *EncryptingRegistrar) rewrap(sealedTarget SealedTarget) (SealedTarget, error) {
+	dataKey, err := r.kms.Unwrap(sealedTarget.KeyId, sealedTarget.WrappedKey)
+	if err != nil {
+		return SealedTarget{}, err
+	}
+	sealedTarget.KeyId, sealedTarget.WrappedKey, err = r.kms.Wrap(dataKey)
+	return sealedTarget, err
+}
+
+// additionalData binds the sealed target info to the probe type and id it is stored under, so that it cannot be moved
+// to another target in the backend without being detected
+func additionalData(probeType string, id string) []byte {
+	return []byte(probeType + "\x00" + id)
+}
+
+// SetOverrideMode passes the override mode on to the wrapped registrar, in clear as it carries no credentials
+func (r *EncryptingRegistrar) SetOverrideMode(probeType string, mode target_registrar.OverrideMode) error {
+	overrideRegistrar, err := r.overrideRegistrar()
+	if err != nil {
+		return err
+	}
+	return overrideRegistrar.SetOverrideMode(probeType, mode)
+}
+
+// GetOverrideMode returns the override mode of the given probe type kept by the wrapped registrar
+func (r *EncryptingRegistrar) GetOverrideMode(probeType string) (target_registrar.OverrideMode, error) {
+	overrideRegistrar, err := r.overrideRegistrar()
+	if err != nil {
+		return "", err
+	}
+	return overrideRegistrar.GetOverrideMode(probeType)
+}
+
+// ListOverrideModes returns the override modes kept by the wrapped registrar
+func (r *EncryptingRegistrar) ListOverrideModes() (map[string]target_registrar.OverrideMode, error) {
+	overrideRegistrar, err := r.overrideRegistrar()
+	if err != nil {
+		return nil, err
+	}
+	return overrideRegistrar.ListOverrideModes()
+}
+
+// overrideRegistrar returns the wrapped registrar as an OverrideRegistrar, or an error if it cannot keep overrides
+func (r *EncryptingRegistrar) overrideRegistrar() (target_registrar.OverrideRegistrar, error) {
+	overrideRegistrar, ok := r.registrar.(target_registrar.OverrideRegistrar)
+	if !ok {
+		return nil, fmt.Errorf("the wrapped registrar %T cannot keep the override modes of the probes", r.registrar)
+	}
+	return overrideRegistrar, nil
+}
+
+// Make sure EncryptingRegistrar implements the Registrar interface, or a compilation error will result
+var _ target_registrar.Registrar = (*EncryptingRegistrar)(nil)
+var _ target_registrar.ValidatingRegistrar = (*EncryptingRegistrar)(nil)
+var _ target_registrar.OverrideRegistrar = (*EncryptingRegistrar)(nil)
@@ -0,0 +1,243 @@
+package encrypted_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/encrypted"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/in_memory"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/k8s_secret"
+	"io/ioutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"os"
+	"path/filepath"
+)
+
+func newTarget(probeType string, id string) target_registrar.UserPassTarget {
+	return target_registrar.UserPassTarget{Id: id, Probetype: probeType, Username: "user-" + id, Password: "pass-" + id}
+}
+
+func newKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+var _ = Describe("Encrypting registrar", func() {
+	var kms *encrypted.LocalKMS
+	var backend *in_memory.InMemoryRegistrar
+	var registrar *encrypted.EncryptingRegistrar
+
+	BeforeEach(func() {
+		var err error
+		kms, err = encrypted.NewLocalKMS("key-1", map[string][]byte{"key-1": newKey(1)})
+		Expect(err).NotTo(HaveOccurred())
+		backend = in_memory.NewInMemoryRegistrar()
+		registrar = encrypted.NewEncryptingRegistrar(backend, kms)
+	})
+
+	It("hands over only sealed targets to the wrapped registrar", func() {
+		result, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsFirstTarget()).To(BeTrue())
+
+		stored, err := backend.GetTarget("vcenter", "t1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored).To(BeAssignableToTypeOf(encrypted.SealedTarget{}))
+		storedBytes, err := stored.Bytes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(storedBytes)).NotTo(ContainSubstring("pass-t1"))
+		Expect(string(storedBytes)).NotTo(ContainSubstring("user-t1"))
+
+		target, err := registrar.GetTarget("vcenter", "t1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(newTarget("vcenter", "t1")))
+		targets, err := registrar.ListTargets("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targets).To(Equal([]target_registrar.Target{newTarget("vcenter", "t1")}))
+
+		result, err = registrar.UnregisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsLastTarget()).To(BeTrue())
+	})
+
+	It("leaves a target registered again with the same info sealed as it was", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		stored, err := backend.GetTarget("vcenter", "t1")
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationUnchanged, PreviousCount: 1, NewCount: 1}))
+		Expect(backend.GetTarget("vcenter", "t1")).To(Equal(stored))
+
+		updatedTarget := newTarget("vcenter", "t1")
+		updatedTarget.Password = "new-password"
+		result, err = registrar.RegisterTarget(updatedTarget)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Change).To(Equal(target_registrar.RegistrationUpdated))
+		Expect(registrar.GetTarget("vcenter", "t1")).To(Equal(updatedTarget))
+	})
+
+	It("detects a sealed target moved to another target", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		stored, err := backend.GetTarget("vcenter", "t1")
+		Expect(err).NotTo(HaveOccurred())
+		moved := stored.(encrypted.SealedTarget)
+		moved.Id = "t2"
+		_, err = backend.RegisterTarget(moved)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = registrar.GetTarget("vcenter", "t2")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("failed to unseal target vcenter/t2"))
+	})
+
+	It("detects the sealed targets of two ids swapped in the secret", func() {
+		client := fake.NewSimpleClientset().CoreV1()
+		secretRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, "turbonomic")
+		Expect(err).NotTo(HaveOccurred())
+		registrar := encrypted.NewEncryptingRegistrar(secretRegistrar, kms)
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t2"))
+		Expect(err).NotTo(HaveOccurred())
+
+		secret, err := client.Secrets("turbonomic").Get("vcenter", v1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		secret.Data["t1"], secret.Data["t2"] = secret.Data["t2"], secret.Data["t1"]
+		_, err = client.Secrets("turbonomic").Update(secret)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = registrar.ListTargets("vcenter")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("is stored as target vcenter/t1"))
+		_, err = registrar.GetTarget("vcenter", "t2")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("is stored as target vcenter/t2"))
+	})
+
+	It("re-wraps the data keys with the new key and seals the targets stored in clear when rotating keys", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = backend.RegisterTarget(newTarget("vcenter", "legacy"))
+		Expect(err).NotTo(HaveOccurred())
+		stored, err := backend.GetTarget("vcenter", "t1")
+		Expect(err).NotTo(HaveOccurred())
+		ciphertextBefore := stored.(encrypted.SealedTarget).Ciphertext
+
+		// The targets stored in clear are readable before the rotation with the plaintext migration only
+		_, err = registrar.GetTarget("vcenter", "legacy")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("target vcenter/legacy is stored in clear"))
+		_, err = registrar.ListTargets("vcenter")
+		Expect(err).To(HaveOccurred())
+		target, err := registrar.WithPlaintextMigration().GetTarget("vcenter", "legacy")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(newTarget("vcenter", "legacy")))
+
+		Expect(kms.AddKey("key-2", newKey(2))).To(Succeed())
+		Expect(kms.SetCurrentKey("key-2")).To(Succeed())
+		rewritten, err := registrar.RotateKeys()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rewritten).To(Equal(2))
+
+		for _, id := range []string{"t1", "legacy"} {
+			stored, err := backend.GetTarget("vcenter", id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored.(encrypted.SealedTarget).KeyId).To(Equal("key-2"))
+		}
+		stored, err = backend.GetTarget("vcenter", "t1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.(encrypted.SealedTarget).Ciphertext).To(Equal(ciphertextBefore))
+
+		// Nothing left to rotate, and the old key is no longer needed
+		rewritten, err = registrar.RotateKeys()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rewritten).To(Equal(0))
+		newKMS, err := encrypted.NewLocalKMS("key-2", map[string][]byte{"key-2": newKey(2)})
+		Expect(err).NotTo(HaveOccurred())
+		targets, err := encrypted.NewEncryptingRegistrar(backend, newKMS).ListTargets("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targets).To(Equal([]target_registrar.Target{newTarget("vcenter", "legacy"), newTarget("vcenter", "t1")}))
+	})
+
+	It("fails to read the targets without the key-encryption key", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		otherKMS, err := encrypted.NewLocalKMS("key-2", map[string][]byte{"key-2": newKey(2)})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = encrypted.NewEncryptingRegistrar(backend, otherKMS).GetTarget("vcenter", "t1")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("unknown key-encryption key \"key-1\""))
+	})
+
+	It("works with the secret registrar", func() {
+		client := fake.NewSimpleClientset().CoreV1()
+		secretRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, "turbonomic")
+		Expect(err).NotTo(HaveOccurred())
+		registrar := encrypted.NewEncryptingRegistrar(secretRegistrar, kms)
+
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		secret, err := client.Secrets("turbonomic").Get("vcenter", v1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(secret.Data["t1"])).To(ContainSubstring("kind: Sealed"))
+		Expect(string(secret.Data["t1"])).NotTo(ContainSubstring("pass-t1"))
+
+		target, err := registrar.GetTarget("vcenter", "t1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(newTarget("vcenter", "t1")))
+	})
+
+	It("detects a sealed target copied to another target of the secret as it is", func() {
+		client := fake.NewSimpleClientset().CoreV1()
+		secretRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, "turbonomic")
+		Expect(err).NotTo(HaveOccurred())
+		registrar := encrypted.NewEncryptingRegistrar(secretRegistrar, kms)
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+
+		secret, err := client.Secrets("turbonomic").Get("vcenter", v1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		secret.Data["t2"] = secret.Data["t1"]
+		_, err = client.Secrets("turbonomic").Update(secret)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = registrar.GetTarget("vcenter", "t2")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("is stored as target vcenter/t2"))
+		_, err = registrar.ListTargets("vcenter")
+		Expect(err).To(HaveOccurred())
+		Expect(registrar.GetTarget("vcenter", "t1")).To(Equal(newTarget("vcenter", "t1")))
+	})
+
+	It("loads the keys from a key file", func() {
+		dir, err := ioutil.TempDir("", "keys-")
+		Expect(err).NotTo(HaveOccurred())
+		defer os.RemoveAll(dir)
+		keyFile := filepath.Join(dir, "keys.yaml")
+		Expect(ioutil.WriteFile(keyFile, []byte("current: key-2\nkeys:\n  key-1: "+
+			base64.StdEncoding.EncodeToString(newKey(1))+"\n  key-2: "+
+			base64.StdEncoding.EncodeToString(newKey(2))+"\n"), 0600)).To(Succeed())
+
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		fileKMS, err := encrypted.NewLocalKMSFromFile(keyFile)
+		Expect(err).NotTo(HaveOccurred())
+		keyId, err := fileKMS.CurrentKeyId()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keyId).To(Equal("key-2"))
+		target, err := encrypted.NewEncryptingRegistrar(backend, fileKMS).GetTarget("vcenter", "t1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(newTarget("vcenter", "t1")))
+
+		Expect(ioutil.WriteFile(keyFile, []byte("current: key-3\nkeys:\n  key-3: c2hvcnQ=\n"), 0600)).To(Succeed())
+		_, err = encrypted.NewLocalKMSFromFile(keyFile)
+		Expect(err).To(HaveOccurred())
+	})
+})
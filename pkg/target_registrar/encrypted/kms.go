@@ -0,0 +1,177 @@
+package encrypted
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"sync"
+)
+
+// KMS wraps and unwraps data keys with key-encryption keys it holds, so that the key-encryption keys never leave it.
+// Implement this interface to plug in an external key management service.
+type KMS interface {
+	// CurrentKeyId returns the id of the key-encryption key that Wrap uses
+	CurrentKeyId() (string, error)
+	// Wrap encrypts the data key with the current key-encryption key, and returns the id of that key along with the
+	// wrapped data key
+	Wrap(dataKey []byte) (keyId string, wrappedKey []byte, err error)
+	// Unwrap decrypts the data key wrapped by the key-encryption key of the given id
+	Unwrap(keyId string, wrappedKey []byte) ([]byte, error)
+}
+
+// keyFile is the content of a local key file: the key-encryption keys in base64 keyed by their ids, and the id of the
+// key to wrap the new data keys with
+type keyFile struct {
+	Current string            `yaml:"current"`
+	Keys    map[string]string `yaml:"keys"`
+}
+
+// LocalKMS implements the KMS interface with AES key-encryption keys held in memory, typically loaded from a local
+// key file.  The older keys are kept to unwrap the data keys wrapped before a rotation.  It is safe for concurrent use.
+type LocalKMS struct {
+	lock         sync.RWMutex
+	currentKeyId string
+	keys         map[string][]byte
+}
+
+// NewLocalKMS constructs a LocalKMS with the given AES keys of 16, 24 or 32 bytes keyed by their ids, wrapping the new
+// data keys with the key of the given current id
+func NewLocalKMS(currentKeyId string, keys map[string][]byte) (*LocalKMS, error) {
+	kms := &LocalKMS{keys: map[string][]byte{}}
+	for keyId, key := range keys {
+		if err := kms.AddKey(keyId, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := kms.SetCurrentKey(currentKeyId); err != nil {
+		return nil, err
+	}
+	return kms, nil
+}
+
+// NewLocalKMSFromFile constructs a LocalKMS from a yaml key file listing the keys in base64 under "keys", keyed by
+// their ids, along with the id of the current key under "current"
+func NewLocalKMSFromFile(path string) (*LocalKMS, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the key file %v\n%v", path, err)
+	}
+	var file keyFile
+	if err := yaml.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse the key file %v\n%v", path, err)
+	}
+	keys := map[string][]byte{}
+	for keyId, encodedKey := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode key %v in the key file %v\n%v", keyId, path, err)
+		}
+		keys[keyId] = key
+	}
+	kms, err := NewLocalKMS(file.Current, keys)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %v\n%v", path, err)
+	}
+	return kms, nil
+}
+
+// AddKey adds a key-encryption key, without making it the current one
+func (k *LocalKMS) AddKey(keyId string, key []byte) error {
+	if keyId == "" {
+		return fmt.Errorf("key-encryption key without an id")
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("invalid key-encryption key %v\n%v", keyId, err)
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	k.keys[keyId] = append([]byte(nil), key...)
+	return nil
+}
+
+// SetCurrentKey makes the key of the given id the one to wrap the new data keys with
+func (k *LocalKMS) SetCurrentKey(keyId string) error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if _, found := k.keys[keyId]; !found {
+		return fmt.Errorf("unknown key-encryption key %q", keyId)
+	}
+	k.currentKeyId = keyId
+	return nil
+}
+
+// CurrentKeyId returns the id of the key to wrap the new data keys with
+func (k *LocalKMS) CurrentKeyId() (string, error) {
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+	return k.currentKeyId, nil
+}
+
+// Wrap encrypts the data key with the current key using AES-GCM
+func (k *LocalKMS) Wrap(dataKey []byte) (string, []byte, error) {
+	k.lock.RLock()
+	keyId, key := k.currentKeyId, k.keys[k.currentKeyId]
+	k.lock.RUnlock()
+	wrappedKey, err := seal(key, dataKey, []byte(keyId))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to wrap the data key with key %v\n%v", keyId, err)
+	}
+	return keyId, wrappedKey, nil
+}
+
+// Unwrap decrypts the data key wrapped by the key of the given id
+func (k *LocalKMS) Unwrap(keyId string, wrappedKey []byte) ([]byte, error) {
+	k.lock.RLock()
+	key, found := k.keys[keyId]
+	k.lock.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unknown key-encryption key %q", keyId)
+	}
+	dataKey, err := open(key, wrappedKey, []byte(keyId))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the data key with key %v\n%v", keyId, err)
+	}
+	return dataKey, nil
+}
+
+// seal encrypts the plaintext with AES-GCM under the given key, binding it to the additional data, and returns the
+// random nonce followed by the ciphertext
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts what seal has returned
+func open(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Make sure LocalKMS implements the KMS interface, or a compilation error will result
+var _ KMS = (*LocalKMS)(nil)
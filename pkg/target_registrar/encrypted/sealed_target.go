@@ -0,0 +1,116 @@
+package encrypted
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"gopkg.in/yaml.v2"
+)
+
+// SealedTarget is what the EncryptingRegistrar hands over to the registrar it wraps: the encoded target encrypted with
+// AES-GCM under a random data key, along with that data key wrapped by a key-encryption key.  Only the probe type and
+// the id are left in clear, since the wrapped registrar needs them to store the target.
+type SealedTarget struct {
+	Id        string
+	Probetype string
+	// KeyId is the id of the key-encryption key that has wrapped the data key
+	KeyId string
+	// WrappedKey is the data key wrapped by the key-encryption key
+	WrappedKey []byte
+	// Ciphertext is the encoded target sealed by the data key, prefixed with the nonce
+	Ciphertext []byte
+}
+
+// sealedPayload is the stored form of a SealedTarget, with the binary fields in base64 to keep the payload readable
+type sealedPayload struct {
+	Id         string `yaml:"id"`
+	Probetype  string `yaml:"probetype"`
+	KeyId      string `yaml:"keyId"`
+	WrappedKey string `yaml:"wrappedKey"`
+	Ciphertext string `yaml:"ciphertext"`
+}
+
+func (t SealedTarget) GetId() string {
+	return t.Id
+}
+
+func (t SealedTarget) GetProbeType() string {
+	return t.Probetype
+}
+
+func (t SealedTarget) Bytes() ([]byte, error) {
+	return yaml.Marshal(&sealedPayload{
+		Id:         t.Id,
+		Probetype:  t.Probetype,
+		KeyId:      t.KeyId,
+		WrappedKey: base64.StdEncoding.EncodeToString(t.WrappedKey),
+		Ciphertext: base64.StdEncoding.EncodeToString(t.Ciphertext),
+	})
+}
+
+// CheckLocation returns an error if the target has been sealed for another probe type or id than the given ones, i.e.
+// it has been copied or moved to another target in the backend
+func (t SealedTarget) CheckLocation(probeType string, id string) error {
+	if t.Probetype != probeType || t.Id != id {
+		return fmt.Errorf("target %v is stored as target %v/%v", t.SafeString(), probeType, id)
+	}
+	return nil
+}
+
+// SafeString describes the target by its probe type, id and key-encryption key id
+func (t SealedTarget) SafeString() string {
+	return fmt.Sprintf("%v/%v (sealed by key %v)", t.Probetype, t.Id, t.KeyId)
+}
+
+// String describes the target when formatted with %v or %s, leaving out the sealed bytes
+func (t SealedTarget) String() string {
+	return t.SafeString()
+}
+
+// GoString describes the target when formatted with %#v, leaving out the sealed bytes
+func (t SealedTarget) GoString() string {
+	return t.SafeString()
+}
+
+// Make sure SealedTarget implements the Target interface, or a compilation error will result
+var _ target_registrar.Target = (*SealedTarget)(nil)
+var _ target_registrar.SafeStringer = (*SealedTarget)(nil)
+var _ target_registrar.LocationBoundTarget = (*SealedTarget)(nil)
+
+// SealedKind is the kind under which SealedTarget is registered with the default codec registry
+const SealedKind = "Sealed"
+
+func init() {
+	if err := target_registrar.RegisterTargetKind(SealedKind, "v1", SealedTarget{},
+		func(bytes []byte) (target_registrar.Target, error) {
+			var target SealedTarget
+			err := SealedTargetFromBytes(bytes, &target)
+			return target, err
+		}); err != nil {
+		panic(err)
+	}
+}
+
+// Unmarshal the input byte array into a SealedTarget object
+func SealedTargetFromBytes(bytes []byte, target *SealedTarget) error {
+	var payload sealedPayload
+	if err := yaml.Unmarshal(bytes, &payload); err != nil {
+		return err
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(payload.WrappedKey)
+	if err != nil {
+		return fmt.Errorf("invalid wrapped key\n%v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(payload.Ciphertext)
+	if err != nil {
+		return fmt.Errorf("invalid ciphertext\n%v", err)
+	}
+	*target = SealedTarget{
+		Id:         payload.Id,
+		Probetype:  payload.Probetype,
+		KeyId:      payload.KeyId,
+		WrappedKey: wrappedKey,
+		Ciphertext: ciphertext,
+	}
+	return nil
+}
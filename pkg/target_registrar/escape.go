@@ -0,0 +1,32 @@
+package target_registrar
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EscapeName turns a probe type or a target id into a single segment of a path, such as a file name or a segment of a
+// secret path: every byte other than an ASCII letter, a digit, '-' or '_' is percent-escaped, so that no name can add,
+// traverse or hide in path segments.  Distinct names are escaped into distinct segments.
+func EscapeName(name string) string {
+	var escaped strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+// CheckPathKey rejects an empty probe type or id, which would escape into an empty path segment, so that the path of
+// the target would be the path of its probe type, or the root under which the probe types are kept, rather than the
+// path of a target
+func CheckPathKey(probeType string, id string) error {
+	if probeType == "" || id == "" {
+		return fmt.Errorf("a target needs both a probe type and an id")
+	}
+	return nil
+}
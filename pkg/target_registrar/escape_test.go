@@ -0,0 +1,27 @@
+package target_registrar_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+)
+
+var _ = Describe("Test escaping names", func() {
+	DescribeTable("test escaping a probe type or a target id into a single path segment",
+		func(name string, expectedSegment string) {
+			Expect(target_registrar.EscapeName(name)).To(Equal(expectedSegment))
+		},
+		Entry("a safe name is kept as is", "vcenter-probe_1", "vcenter-probe_1"),
+		Entry("a name traversing directories", "../..", "%2E%2E%2F%2E%2E"),
+		Entry("a hidden name", ".lock", "%2Elock"),
+		Entry("a URL", "https://10.10.10.10:443/sdk", "https%3A%2F%2F10%2E10%2E10%2E10%3A443%2Fsdk"),
+		Entry("a percent sign, escaped so that the escaping stays unambiguous", "100%", "100%25"),
+	)
+
+	It("rejects a probe type or a target id that would escape into an empty path segment", func() {
+		Expect(target_registrar.CheckPathKey("vcenter", "t1")).To(Succeed())
+		Expect(target_registrar.CheckPathKey("vcenter", "")).NotTo(Succeed())
+		Expect(target_registrar.CheckPathKey("", "t1")).NotTo(Succeed())
+	})
+})
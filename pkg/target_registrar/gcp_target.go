@@ -0,0 +1,91 @@
+package target_registrar
+
+import (
+	"encoding/json"
+	"gopkg.in/yaml.v2"
+)
+
+// GCPServiceAccountTarget describes a structure for targets authenticating with the JSON key of a GCP service account
+type GCPServiceAccountTarget struct {
+	Id                 string
+	Probetype          string
+	ServiceAccountJSON string
+}
+
+// gcpServiceAccountKey lists the fields of a GCP service account JSON key that are checked upon validation
+type gcpServiceAccountKey struct {
+	Type        string `json:"type"`
+	ProjectId   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+}
+
+func (t GCPServiceAccountTarget) GetId() string {
+	return t.Id
+}
+
+func (t GCPServiceAccountTarget) GetProbeType() string {
+	return t.Probetype
+}
+
+func (t GCPServiceAccountTarget) Bytes() ([]byte, error) {
+	return yaml.Marshal(&t)
+}
+
+// Validate checks that the service account JSON key is set, and that it parses as a service account key with a
+// project, a client email and a private key
+func (t GCPServiceAccountTarget) Validate() error {
+	errs := requiredFields{{"ServiceAccountJSON", t.ServiceAccountJSON}}.validate()
+	if len(errs) > 0 {
+		return aggregate(errs)
+	}
+	var key gcpServiceAccountKey
+	if err := json.Unmarshal([]byte(t.ServiceAccountJSON), &key); err != nil {
+		return fieldError("ServiceAccountJSON", "not a valid JSON document")
+	}
+	if key.Type != "service_account" {
+		errs = append(errs, fieldError("ServiceAccountJSON", "not a service account key"))
+	}
+	errs = append(errs, requiredFields{{"ServiceAccountJSON.project_id", key.ProjectId},
+		{"ServiceAccountJSON.client_email", key.ClientEmail}, {"ServiceAccountJSON.private_key", key.PrivateKey}}.validate()...)
+	return aggregate(errs)
+}
+
+// SafeString describes the target by its probe type and id, leaving out the service account key
+func (t GCPServiceAccountTarget) SafeString() string {
+	return safeString(t.Probetype, t.Id)
+}
+
+// String redacts the target when formatted with %v or %s
+func (t GCPServiceAccountTarget) String() string {
+	return t.SafeString()
+}
+
+// GoString redacts the target when formatted with %#v
+func (t GCPServiceAccountTarget) GoString() string {
+	return t.SafeString()
+}
+
+// Make sure GCPServiceAccountTarget implements the Target interface, or a compilation error will result
+var _ Target = (*GCPServiceAccountTarget)(nil)
+var _ SafeStringer = (*GCPServiceAccountTarget)(nil)
+var _ Validator = (*GCPServiceAccountTarget)(nil)
+
+// GCPServiceAccountKind is the kind under which GCPServiceAccountTarget is registered with the default codec registry
+const GCPServiceAccountKind = "GCPServiceAccount"
+
+func init() {
+	if err := RegisterTargetKind(GCPServiceAccountKind, "v1", GCPServiceAccountTarget{},
+		func(bytes []byte) (Target, error) {
+			var target GCPServiceAccountTarget
+			err := GCPServiceAccountTargetFromBytes(bytes, &target)
+			return target, err
+		}); err != nil {
+		panic(err)
+	}
+}
+
+// Unmarshal the input byte array into a GCPServiceAccountTarget object
+func GCPServiceAccountTargetFromBytes(bytes []byte, target *GCPServiceAccountTarget) error {
+	return yaml.Unmarshal(bytes, target)
+}
@@ -0,0 +1,177 @@
+package in_memory
+
+import (
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/fault_injection"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"sort"
+	"sync"
+)
+
+// InMemoryRegistrar implements the Registrar interface keeping the target info in memory.  It records the history of
+// the calls made to it and supports fault injection through the embedded FaultInjector, which makes it a drop-in
+// replacement of the other registrars in tests.  It is safe for concurrent use.
+type InMemoryRegistrar struct {
+	*fault_injection.FaultInjector
+	lock sync.RWMutex
+	// targets keeps the encoded target info keyed by the probe type and then by the target id
+	targets map[string]map[string][]byte
+	// overrides keeps the override modes other than OverrideAuto keyed by the probe type
+	overrides map[string]target_registrar.OverrideMode
+}
+
+// NewInMemoryRegistrar constructs an empty InMemoryRegistrar
+func NewInMemoryRegistrar() *InMemoryRegistrar {
+	return &InMemoryRegistrar{
+		FaultInjector: fault_injection.NewFaultInjector(),
+		targets:       map[string]map[string][]byte{},
+		overrides:     map[string]target_registrar.OverrideMode{},
+	}
+}
+
+// RegisterTarget keeps the target info in memory, unless the same info is kept already
+func (r *InMemoryRegistrar) RegisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	if err := r.Inject("RegisterTarget", target.GetProbeType(), target.GetId()); err != nil {
+		return target_registrar.RegistrationResult{}, err
+	}
+	data, err := target_registrar.EncodeTarget(target)
+	if err != nil {
+		return target_registrar.RegistrationResult{}, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	previousData, existed := r.targets[target.GetProbeType()][target.GetId()]
+	result := target_registrar.NewRegisterResult(previousData, existed, data, len(r.targets[target.GetProbeType()]))
+	if result.Change == target_registrar.RegistrationUnchanged {
+		return result, nil
+	}
+	if r.targets[target.GetProbeType()] == nil {
+		r.targets[target.GetProbeType()] = map[string][]byte{}
+	}
+	r.targets[target.GetProbeType()][target.GetId()] = data
+	return result, nil
+}
+
+// UnregisterTarget removes the target info from memory
+func (r *InMemoryRegistrar) UnregisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	if err := r.Inject("UnregisterTarget", target.GetProbeType(), target.GetId()); err != nil {
+		return target_registrar.RegistrationResult{}, err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	_, existed := r.targets[target.GetProbeType()][target.GetId()]
+	result := target_registrar.NewUnregisterResult(existed, len(r.targets[target.GetProbeType()]))
+	delete(r.targets[target.GetProbeType()], target.GetId())
+	if len(r.targets[target.GetProbeType()]) == 0 {
+		delete(r.targets, target.GetProbeType())
+	}
+	return result, nil
+}
+
+// ListProbeTypes returns the probe types having at least one target, sorted
+func (r *InMemoryRegistrar) ListProbeTypes() ([]string, error) {
+	if err := r.Inject("ListProbeTypes"); err != nil {
+		return nil, err
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	probeTypes := make([]string, 0, len(r.targets))
+	for probeType := range r.targets {
+		probeTypes = append(probeTypes, probeType)
+	}
+	sort.Strings(probeTypes)
+	return probeTypes, nil
+}
+
+// CountTargets returns the number of targets of the given probe type
+func (r *InMemoryRegistrar) CountTargets(probeType string) (int, error) {
+	if err := r.Inject("CountTargets", probeType); err != nil {
+		return 0, err
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return len(r.targets[probeType]), nil
+}
+
+// ListTargets returns the targets of the given probe type, sorted by the target id
+func (r *InMemoryRegistrar) ListTargets(probeType string) ([]target_registrar.Target, error) {
+	if err := r.Inject("ListTargets", probeType); err != nil {
+		return nil, err
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	ids := make([]string, 0, len(r.targets[probeType]))
+	for id := range r.targets[probeType] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	targets := make([]target_registrar.Target, 0, len(ids))
+	for _, id := range ids {
+		target, err := target_registrar.DecodeTarget(probeType, id, r.targets[probeType][id])
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// GetTarget returns the target of the given probe type and id, or nil if not found
+func (r *InMemoryRegistrar) GetTarget(probeType string, id string) (target_registrar.Target, error) {
+	if err := r.Inject("GetTarget", probeType, id); err != nil {
+		return nil, err
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	data, found := r.targets[probeType][id]
+	if !found {
+		return nil, nil
+	}
+	return target_registrar.DecodeTarget(probeType, id, data)
+}
+
+// SetOverrideMode keeps the override mode of the given probe type in memory
+func (r *InMemoryRegistrar) SetOverrideMode(probeType string, mode target_registrar.OverrideMode) error {
+	if err := r.Inject("SetOverrideMode", probeType, string(mode)); err != nil {
+		return err
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if mode == target_registrar.OverrideAuto {
+		delete(r.overrides, probeType)
+	} else {
+		r.overrides[probeType] = mode
+	}
+	return nil
+}
+
+// GetOverrideMode returns the override mode of the given probe type, or OverrideAuto if none is kept
+func (r *InMemoryRegistrar) GetOverrideMode(probeType string) (target_registrar.OverrideMode, error) {
+	if err := r.Inject("GetOverrideMode", probeType); err != nil {
+		return "", err
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if mode, found := r.overrides[probeType]; found {
+		return mode, nil
+	}
+	return target_registrar.OverrideAuto, nil
+}
+
+// ListOverrideModes returns a copy of the override modes kept in memory
+func (r *InMemoryRegistrar) ListOverrideModes() (map[string]target_registrar.OverrideMode, error) {
+	if err := r.Inject("ListOverrideModes"); err != nil {
+		return nil, err
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	overrides := make(map[string]target_registrar.OverrideMode, len(r.overrides))
+	for probeType, mode := range r.overrides {
+		overrides[probeType] = mode
+	}
+	return overrides, nil
+}
+
+// Make sure InMemoryRegistrar implements the Registrar and the OverrideRegistrar interfaces, or a compilation error
+// will result
+var _ target_registrar.Registrar = (*InMemoryRegistrar)(nil)
+var _ target_registrar.OverrideRegistrar = (*InMemoryRegistrar)(nil)
@@ -0,0 +1,137 @@
+package in_memory_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/in_memory"
+)
+
+func newTarget(probeType string, id string) target_registrar.UserPassTarget {
+	return target_registrar.UserPassTarget{Id: id, Probetype: probeType, Username: "user-" + id, Password: "pass-" + id}
+}
+
+var _ = Describe("In memory registrar", func() {
+	var registrar *in_memory.InMemoryRegistrar
+
+	BeforeEach(func() {
+		registrar = in_memory.NewInMemoryRegistrar()
+	})
+
+	DescribeTable("registering and unregistering targets",
+		func(registered []string, toUnregister []string, expectedLastTarget bool, expectedCount int) {
+			for _, id := range registered {
+				result, err := registrar.RegisterTarget(newTarget("vcenter", id))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.NewCount).To(BeNumerically(">", 0))
+			}
+			var result target_registrar.RegistrationResult
+			for _, id := range toUnregister {
+				var err error
+				result, err = registrar.UnregisterTarget(newTarget("vcenter", id))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(result.IsLastTarget()).To(Equal(expectedLastTarget))
+			count, err := registrar.CountTargets("vcenter")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(expectedCount))
+		},
+		Entry("unregister the only target", []string{"t1"}, []string{"t1"}, true, 0),
+		Entry("unregister one of two targets", []string{"t1", "t2"}, []string{"t1"}, false, 1),
+		Entry("unregister both targets", []string{"t1", "t2"}, []string{"t2", "t1"}, true, 0),
+		Entry("unregister a target never registered", []string{"t1"}, []string{"t2"}, false, 1),
+		Entry("unregister from a probe type never registered", []string{}, []string{"t1"}, false, 0),
+		Entry("register the same target twice", []string{"t1", "t1"}, []string{"t1"}, true, 0),
+	)
+
+	It("reports whether a target has been created, updated or left unchanged", func() {
+		result, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationCreated, PreviousCount: 0, NewCount: 1}))
+		Expect(result.IsFirstTarget()).To(BeTrue())
+
+		result, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationUnchanged, PreviousCount: 1, NewCount: 1}))
+
+		updatedTarget := newTarget("vcenter", "t1")
+		updatedTarget.Password = "new-password"
+		result, err = registrar.RegisterTarget(updatedTarget)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationUpdated, PreviousCount: 1, NewCount: 1}))
+		Expect(registrar.GetTarget("vcenter", "t1")).To(Equal(updatedTarget))
+
+		result, err = registrar.RegisterTarget(newTarget("vcenter", "t2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationCreated, PreviousCount: 1, NewCount: 2}))
+		Expect(result.IsFirstTarget()).To(BeFalse())
+	})
+
+	It("reports whether a target has been deleted or was not registered", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+
+		result, err := registrar.UnregisterTarget(newTarget("vcenter", "t2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationUnchanged, PreviousCount: 1, NewCount: 1}))
+
+		result, err = registrar.UnregisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationDeleted, PreviousCount: 1, NewCount: 0}))
+		Expect(result.IsLastTarget()).To(BeTrue())
+
+		result, err = registrar.UnregisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationUnchanged, PreviousCount: 0, NewCount: 0}))
+		Expect(result.IsLastTarget()).To(BeFalse())
+	})
+
+	It("lists the probe types having targets and reads the targets back", func() {
+		for _, target := range []target_registrar.Target{newTarget("vcenter", "t2"), newTarget("vcenter", "t1"),
+			newTarget("aws", "t1")} {
+			_, err := registrar.RegisterTarget(target)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(registrar.ListProbeTypes()).To(Equal([]string{"aws", "vcenter"}))
+		Expect(registrar.ListTargets("vcenter")).To(Equal([]target_registrar.Target{newTarget("vcenter", "t1"),
+			newTarget("vcenter", "t2")}))
+		Expect(registrar.GetTarget("vcenter", "t3")).To(BeNil())
+
+		// a probe type losing its last target is no longer listed
+		_, err := registrar.UnregisterTarget(newTarget("aws", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(registrar.ListProbeTypes()).To(Equal([]string{"vcenter"}))
+		Expect(registrar.ListTargets("aws")).To(BeEmpty())
+	})
+
+	It("keeps the override modes other than auto", func() {
+		Expect(registrar.GetOverrideMode("vcenter")).To(Equal(target_registrar.OverrideAuto))
+		Expect(registrar.SetOverrideMode("vcenter", target_registrar.OverrideForceDisabled)).To(Succeed())
+		Expect(registrar.SetOverrideMode("pure", target_registrar.OverrideForceEnabled)).To(Succeed())
+		Expect(registrar.GetOverrideMode("vcenter")).To(Equal(target_registrar.OverrideForceDisabled))
+		Expect(registrar.SetOverrideMode("pure", target_registrar.OverrideAuto)).To(Succeed())
+		Expect(registrar.ListOverrideModes()).To(Equal(map[string]target_registrar.OverrideMode{
+			"vcenter": target_registrar.OverrideForceDisabled}))
+	})
+
+	It("records the calls and fails the ones it is told to, leaving the targets alone", func() {
+		registrar.FailNthCall("RegisterTarget", 2, fmt.Errorf("registrar is down"))
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t2"))
+		Expect(err).To(MatchError("registrar is down"))
+		Expect(registrar.CountTargets("vcenter")).To(Equal(1))
+		calls := registrar.CallsTo("RegisterTarget")
+		Expect(calls).To(HaveLen(2))
+		Expect(calls[1].Args).To(Equal([]string{"vcenter", "t2"}))
+	})
+})
@@ -2,23 +2,30 @@ package k8s_secret
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	clientv1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	clientretry "k8s.io/client-go/util/retry"
+	"sort"
+	"strings"
 )
 
-// K8sSecretsRegistrar implements the Registrar interface using Kubernetes secrets to store target info
+// K8sSecretsRegistrar implements the Registrar interface using Kubernetes secrets to store target info.  How the
+// targets of a probe type are spread across secrets is decided by its SecretLayout.  Every secret it creates is labeled
+// as managed by it, and the secrets it has not created are dealt with according to its AdoptionPolicy.
 type K8sSecretsRegistrar struct {
-	client    clientv1.CoreV1Interface
-	namespace string
+	client         clientv1.CoreV1Interface
+	namespace      string
+	layout         SecretLayout
+	adoptionPolicy AdoptionPolicy
 }
 
 // NewK8sSecretsTargetRegistrarForConfig constructs a K8sSecretsRegistrar given the input kubeconfig and the namespace
@@ -30,107 +37,437 @@ func NewK8sSecretsTargetRegistrarForConfig(config *rest.Config, namespace string
 	return NewK8sSecretsTargetRegistrarFromClient(kubeClient.CoreV1(), namespace)
 }
 
-// NewK8sSecretsTargetRegistrarFromClient constructs a K8sSecretsRegistrar given the kube client and the namespace
+// NewK8sSecretsTargetRegistrarFromClient constructs a K8sSecretsRegistrar given the kube client and the namespace.  It
+// keeps all the targets of a probe type in one secret, unless another layout is set with WithLayout, and refuses the
+// secrets it has not created, unless another adoption policy is set with WithAdoptionPolicy.
 func NewK8sSecretsTargetRegistrarFromClient(client clientv1.CoreV1Interface, namespace string) (*K8sSecretsRegistrar, error) {
 	return &K8sSecretsRegistrar{
-		client:    client,
-		namespace: namespace,
+		client:         client,
+		namespace:      namespace,
+		layout:         PerProbeTypeLayout{},
+		adoptionPolicy: AdoptionPolicyRefuse,
 	}, nil
 }
 
-// TargetToSecret converts the input Target to a k8s secret, using yaml.Marshal()
+// WithLayout sets the layout of the secrets keeping the targets
+func (r *K8sSecretsRegistrar) WithLayout(layout SecretLayout) *K8sSecretsRegistrar {
+	r.layout = layout
+	return r
+}
+
+// TargetToSecret converts the input Target to a k8s secret named after its probe type, keeping the target in an
+// envelope recording its kind.  The envelope is kept in the binary Data of the secret so that certificates, keys and
+// the like are never altered.
 func TargetToSecret(target target_registrar.Target) (*apiv1.Secret, error) {
-	bytes, err := target.Bytes()
+	bytes, err := target_registrar.EncodeTarget(target)
 	if err != nil {
 		return nil, err
 	}
-	return &apiv1.Secret{
+	return newSecret(EncodeSecretName(target.GetProbeType()), target, bytes), nil
+}
+
+// newSecret returns a secret of the given name keeping the given encoded target, labeled as managed by the registrar
+func newSecret(name string, target target_registrar.Target, data []byte) *apiv1.Secret {
+	key := EncodeDataKey(target.GetId())
+	secret := &apiv1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: target.GetProbeType(),
+			Name: name,
 		},
-		StringData: map[string]string {
-			target.GetId(): string(bytes),
+		Data: map[string][]byte{
+			key: data,
 		},
-	}, nil
+	}
+	setOwnership(secret, target.GetProbeType())
+	setTargetIdInSecret(secret, key, target.GetId())
+	return secret
 }
 
-// RegisterTarget registers the target by storing its info as a Kubernetes secret.  It returns true if the registration
-// is successful and the probe type has now one or more target, or false otherwise.
-func (r *K8sSecretsRegistrar) RegisterTarget(target target_registrar.Target) (bool, error) {
-	existingSecret, err := r.findSecret(target)
+// ValidateTarget checks that the target can be kept in a secret once its probe type and id are encoded: the name the
+// layout gives its secret, suffixes included, must be a valid secret name, i.e. a DNS-1123 subdomain, other than the
+// reserved name of the overrides secret.  Any id can be encoded into a valid key of the secret data.
+func (r *K8sSecretsRegistrar) ValidateTarget(target target_registrar.Target) error {
+	var errs target_registrar.ValidationErrors
+	if target.GetProbeType() != "" {
+		name := r.layout.SecretName(EncodeSecretName(target.GetProbeType()), target.GetId())
+		if messages := validation.IsDNS1123Subdomain(name); len(messages) > 0 {
+			errs = append(errs, &target_registrar.ValidationError{Field: "Probetype",
+				Reason: fmt.Sprintf("not a valid secret name once encoded as %v: %v", name, strings.Join(messages, "; "))})
+		} else if name == OverridesSecretName {
+			errs = append(errs, &target_registrar.ValidationError{Field: "Probetype",
+				Reason: "encoded as " + name + ", the name of the secret keeping the override modes"})
+		}
+	}
+	return target_registrar.IdentifyValidationErrors(target, errs)
+}
+
+// RegisterTarget registers the target by storing its info as a Kubernetes secret.  It returns whether the target has
+// been created, updated or left unchanged, along with the number of targets of the probe type before and after.
+func (r *K8sSecretsRegistrar) RegisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	result, err := r.registerTarget(target)
 	if err != nil {
-		return false, err
+		return target_registrar.RegistrationResult{}, fmt.Errorf(
+			"failed to store target %v in a secret in namespace %v\n%v", target_registrar.SafeString(target),
+			r.namespace, err)
 	}
+	return result, nil
+}
 
-	if existingSecret == nil {
-		// No existingSecret matches with the probe type of this target; create a new existingSecret
-		secret, err := TargetToSecret(target)
-		if err != nil {
-			return false, err
+// registerTarget stores the target info in the secret the layout assigns it to, creating the secret if not yet
+// created, and removes it from any other secret of its probe type, e.g. one it has been kept in under another layout.
+// Nothing is written if the target is kept with the same info in the secret the layout assigns it to only.
+func (r *K8sSecretsRegistrar) registerTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	var result target_registrar.RegistrationResult
+	existingSecrets, err := r.findSecretsByProbeType(target.GetProbeType())
+	if err != nil {
+		return result, err
+	}
+	if err := r.adoptSecrets(existingSecrets, target.GetProbeType()); err != nil {
+		return result, err
+	}
+	newData, err := target_registrar.EncodeTarget(target)
+	if err != nil {
+		return result, err
+	}
+	previousData, existed := targetDataInSecrets(existingSecrets)[target.GetId()]
+	result = target_registrar.NewRegisterResult(previousData, existed, newData, countTargetsInSecrets(existingSecrets))
+	name := r.layout.SecretName(EncodeSecretName(target.GetProbeType()), target.GetId())
+	var secretsToRemoveFrom []*apiv1.Secret
+	for i := range existingSecrets {
+		if existingSecrets[i].Name == name {
+			continue
 		}
-		existingSecret, err = r.client.Secrets(r.namespace).Create(secret)
-		if err == nil {
-			return true, nil
+		if _, found := targetDataInSecret(&existingSecrets[i])[target.GetId()]; found {
+			secretsToRemoveFrom = append(secretsToRemoveFrom, &existingSecrets[i])
+		}
+	}
+	if result.Change == target_registrar.RegistrationUnchanged && len(secretsToRemoveFrom) == 0 {
+		return result, nil
+	}
+	if err := r.storeTargetInSecret(findSecretByName(existingSecrets, name), name, target, newData); err != nil {
+		return result, err
+	}
+	for _, secret := range secretsToRemoveFrom {
+		if _, err := r.removeTargetFromSecret(secret, target.GetId()); err != nil {
+			return result, err
 		}
+	}
+	return result, nil
+}
+
+// storeTargetInSecret stores the encoded target in the given secret, or creates the secret of the given name if nil
+func (r *K8sSecretsRegistrar) storeTargetInSecret(existingSecret *apiv1.Secret, name string,
+	target target_registrar.Target, newData []byte) error {
+	if existingSecret == nil {
+		// No secret of this name yet; create a new one
+		_, err := r.client.Secrets(r.namespace).Create(newSecret(name, target, newData))
 		if !errors.IsAlreadyExists(err) {
-			return false, err
+			return err
+		}
+		// secret already exists: fall through with the existing secret to the following update procedure, as long as
+		// the registrar may keep targets in it
+		if existingSecret, err = r.client.Secrets(r.namespace).Get(name, metav1.GetOptions{}); err != nil {
+			return err
+		}
+		if err := r.checkOwnership(existingSecret, target.GetProbeType()); err != nil {
+			return err
 		}
-		// secret already exists: fall through with the existing secret to the following update procedure
 	}
+	adopting := !isManagedSecret(existingSecret)
 
-	// Secret of this probe type found; update the existingSecret in a separate copy
-	newData, err := target.Bytes()
+	// Secret found; update the existingSecret in a separate copy
+	_, err := r.updateSecret(existingSecret, func(updatedSecret *apiv1.Secret) error {
+		key := EncodeDataKey(target.GetId())
+		if err := checkDataKeyFor(updatedSecret, key, target.GetId()); err != nil {
+			return err
+		}
+		if updatedSecret.Data == nil {
+			updatedSecret.Data = map[string][]byte{}
+		}
+		updatedSecret.Data[key] = newData
+		// StringData takes precedence over Data upon writes; make sure no stale copy of this target overrides the update
+		delete(updatedSecret.StringData, key)
+		setOwnership(updatedSecret, target.GetProbeType())
+		setTargetIdInSecret(updatedSecret, key, target.GetId())
+		if adopting {
+			updatedSecret.Annotations[AdoptedAnnotation] = "true"
+		}
+		return nil
+	})
+	return err
+}
+
+// UnregisterTarget unregisters the target, by removing its info from the Kubernetes secrets.  It returns whether the
+// target has been deleted or was not registered, along with the number of targets of the probe type before and after.
+func (r *K8sSecretsRegistrar) UnregisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	result, err := r.unregisterTarget(target)
+	if err != nil {
+		return target_registrar.RegistrationResult{}, fmt.Errorf(
+			"failed to remove target %v from its secret in namespace %v\n%v", target_registrar.SafeString(target),
+			r.namespace, err)
+	}
+	return result, nil
+}
+
+// unregisterTarget removes the target info from the secrets of its probe type, and counts the targets left in them
+func (r *K8sSecretsRegistrar) unregisterTarget(
+	target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	existingSecrets, err := r.findSecretsByProbeType(target.GetProbeType())
 	if err != nil {
-		return false, err
+		return target_registrar.RegistrationResult{}, err
+	}
+	if err := r.adoptSecrets(existingSecrets, target.GetProbeType()); err != nil {
+		return target_registrar.RegistrationResult{}, err
+	}
+	existed := false
+	remainingSecrets := make([]apiv1.Secret, 0, len(existingSecrets))
+	for i := range existingSecrets {
+		secret := &existingSecrets[i]
+		if _, found := targetDataInSecret(secret)[target.GetId()]; found {
+			existed = true
+			if secret, err = r.removeTargetFromSecret(secret, target.GetId()); err != nil {
+				return target_registrar.RegistrationResult{}, err
+			}
+		}
+		remainingSecrets = append(remainingSecrets, *secret)
+	}
+	// No secret of the probe type found essentially means this probe type has no targets
+	result := target_registrar.NewUnregisterResult(existed, countTargetsInSecrets(existingSecrets))
+	result.NewCount = countTargetsInSecrets(remainingSecrets)
+	return result, nil
+}
+
+// removeTargetFromSecret removes the target of the given id from the secret, and returns the secret as updated, which
+// may have been read again upon conflicts.  The secret is deleted if it is left with no target and the layout says so.
+func (r *K8sSecretsRegistrar) removeTargetFromSecret(existingSecret *apiv1.Secret, id string) (*apiv1.Secret, error) {
+	key := EncodeDataKey(id)
+	removeTarget := func(updatedSecret *apiv1.Secret) error {
+		if keptId, found := targetIdUnderKey(updatedSecret, key); !found || keptId != id {
+			// the key keeps no target, or the target of another id whose key is the same, which must be left alone
+			return nil
+		}
+		delete(updatedSecret.StringData, key)
+		delete(updatedSecret.Data, key) // in a real k8s cluster, StringData is converted into Data in []byte form
+		setTargetIdInSecret(updatedSecret, key, "")
+		return nil
 	}
-	err = clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
+	updatedSecret := existingSecret.DeepCopy()
+	_ = removeTarget(updatedSecret)
+	if countTargetsInSecret(updatedSecret) == 0 && r.layout.DeletesEmptySecrets() {
+		err := r.client.Secrets(r.namespace).Delete(existingSecret.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		return updatedSecret, nil
+	}
+	return r.updateSecret(existingSecret, removeTarget)
+}
+
+// updateSecret applies the given update to a copy of the secret and writes the secret with it.  The write carries the
+// resourceVersion of the secret read, so that the API server rejects it with a conflict if another writer has changed
+// the secret since; the secret is then read again and the update retried, so that no data key written concurrently is
+// lost.  An error returned by the update aborts the write.  It returns the secret written by the last successful
+// update.
+func (r *K8sSecretsRegistrar) updateSecret(existingSecret *apiv1.Secret,
+	update func(*apiv1.Secret) error) (*apiv1.Secret, error) {
+	var writtenSecret *apiv1.Secret
+	err := clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
 		updatedSecret := existingSecret.DeepCopy()
-		if updatedSecret.StringData == nil {
-			updatedSecret.StringData = map[string]string{}
+		if err := update(updatedSecret); err != nil {
+			return err
+		}
+		savedSecret, err := r.client.Secrets(r.namespace).Update(updatedSecret)
+		if err == nil {
+			writtenSecret = savedSecret
+		}
+		if errors.IsConflict(err) {
+			if latestSecret, getErr := r.client.Secrets(r.namespace).Get(existingSecret.Name,
+				metav1.GetOptions{}); getErr == nil {
+				existingSecret = latestSecret
+			}
 		}
-		updatedSecret.StringData[target.GetId()] = string(newData)
-		existingSecret, err = r.patchSecret(existingSecret, updatedSecret)
 		return err
 	})
-	return true, err
+	if err != nil {
+		return nil, err
+	}
+	return writtenSecret, nil
+}
+
+// ListProbeTypes returns the probe types having a secret managed by the registrar in the namespace, sorted
+func (r *K8sSecretsRegistrar) ListProbeTypes() ([]string, error) {
+	secrets, err := r.client.Secrets(r.namespace).List(metav1.ListOptions{LabelSelector: ManagedSecretsSelector()})
+	if err != nil {
+		return nil, err
+	}
+	uniqueProbeTypes := map[string]bool{}
+	for i := range secrets.Items {
+		if probeType, isTargetSecret := ProbeTypeForSecret(&secrets.Items[i]); isTargetSecret {
+			uniqueProbeTypes[probeType] = true
+		}
+	}
+	probeTypes := make([]string, 0, len(uniqueProbeTypes))
+	for probeType := range uniqueProbeTypes {
+		probeTypes = append(probeTypes, probeType)
+	}
+	sort.Strings(probeTypes)
+	return probeTypes, nil
 }
 
-// UnregisterTarget unregisters the target, by removing the corresponding Kubernetes secret.  It returns true if the
-// unregistering has been successful and this probe type has now no more targets, or false otherwise.
-func (r *K8sSecretsRegistrar) UnregisterTarget(target target_registrar.Target) (bool, error) {
-	existingSecret, err := r.findSecret(target)
+// ProbeTypeForSecret returns the probe type whose target info is kept in the given secret, as recorded in its
+// annotations or labels.  The second return value is false if the secret is not managed by the registrar, so that
+// unrelated secrets, service account tokens, TLS certificates and the like are not mistaken for target info, or if it
+// is the overrides secret.
+func ProbeTypeForSecret(secret *apiv1.Secret) (string, bool) {
+	if !isManagedSecret(secret) || secret.Type != "" && secret.Type != apiv1.SecretTypeOpaque ||
+		IsOverridesSecret(secret) {
+		return "", false
+	}
+	if probeType, found := secret.Annotations[ProbeTypeAnnotation]; found {
+		return probeType, true
+	}
+	probeType, found := secret.Labels[ProbeTypeLabel]
+	return probeType, found
+}
+
+// CountTargets returns the number of targets kept in the secrets of the given probe type
+func (r *K8sSecretsRegistrar) CountTargets(probeType string) (int, error) {
+	existingSecrets, err := r.findSecretsByProbeType(probeType)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
-	if existingSecret == nil {
-		// No existingSecret of the probe type found, which essentially means this probe type has no targets
-		return true, nil
+	return countTargetsInSecrets(existingSecrets), nil
+}
+
+// ListTargets returns the targets kept in the secrets of the given probe type, sorted by the target id.  Each target is
+// decoded back to its concrete type through the default codec registry.
+func (r *K8sSecretsRegistrar) ListTargets(probeType string) ([]target_registrar.Target, error) {
+	existingSecrets, err := r.findSecretsByProbeType(probeType)
+	if err != nil {
+		return nil, err
+	}
+	targetData := targetDataInSecrets(existingSecrets)
+	ids := make([]string, 0, len(targetData))
+	for id := range targetData {
+		ids = append(ids, id)
 	}
+	sort.Strings(ids)
+	targets := make([]target_registrar.Target, 0, len(ids))
+	for _, id := range ids {
+		target, err := target_registrar.DecodeTarget(probeType, id, targetData[id])
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
 
-	// Secret of this probe type found; update the existingSecret in a separate copy
-	var updatedSecret *apiv1.Secret
-	err = clientretry.RetryOnConflict(clientretry.DefaultRetry, func() error {
-		updatedSecret = existingSecret.DeepCopy()
-		delete(updatedSecret.StringData, target.GetId())
-		delete(updatedSecret.Data, target.GetId()) // in a real k8s cluster, StringData is converted into Data in []byte form
-		existingSecret, err = r.patchSecret(existingSecret, updatedSecret)
-		return err
-	})
+// GetTarget returns the target of the given probe type and id kept in the secrets, or nil if not found
+func (r *K8sSecretsRegistrar) GetTarget(probeType string, id string) (target_registrar.Target, error) {
+	existingSecrets, err := r.findSecretsByProbeType(probeType)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return len(updatedSecret.StringData) == 0 && len(updatedSecret.Data) == 0, nil
+	data, found := targetDataInSecrets(existingSecrets)[id]
+	if !found {
+		return nil, nil
+	}
+	return target_registrar.DecodeTarget(probeType, id, data)
 }
 
-// findSecret returns the secret associated with the input target if found, or nil if not found
-func (r *K8sSecretsRegistrar) findSecret(target target_registrar.Target) (*apiv1.Secret, error) {
-	// Fetch the secret associated with this target to decide if this is the first target of this probe type
-	selectByNameAsProbeType := fields.OneTermEqualSelector("metadata.name", target.GetProbeType())
-	matchedSecrets, err := r.client.Secrets(r.namespace).List(metav1.ListOptions{FieldSelector: selectByNameAsProbeType.String()})
-	if err != nil || len(matchedSecrets.Items) == 0 {
+// targetDataInSecret decodes the target info in a secret keyed by the original target id.  The Data takes precedence
+// over the StringData, as that is what a real k8s cluster keeps after converting the StringData upon writes.
+func targetDataInSecret(secret *apiv1.Secret) map[string][]byte {
+	ids := targetIdsInSecret(secret)
+	idOf := func(key string) string {
+		if id, found := ids[key]; found {
+			return id
+		}
+		return key
+	}
+	targetData := map[string][]byte{}
+	for key, data := range secret.StringData {
+		targetData[idOf(key)] = []byte(data)
+	}
+	for key, data := range secret.Data {
+		targetData[idOf(key)] = data
+	}
+	return targetData
+}
+
+// targetDataInSecrets decodes the target info in all the given secrets keyed by the target id
+func targetDataInSecrets(secrets []apiv1.Secret) map[string][]byte {
+	targetData := map[string][]byte{}
+	for i := range secrets {
+		for id, data := range targetDataInSecret(&secrets[i]) {
+			targetData[id] = data
+		}
+	}
+	return targetData
+}
+
+// countTargetsInSecret counts the distinct target ids in both the Data and the StringData of a secret; in a real k8s
+// cluster StringData is converted into Data, but the two may coexist on an object that hasn't gone through the server.
+func countTargetsInSecret(secret *apiv1.Secret) int {
+	count := len(secret.Data)
+	for id := range secret.StringData {
+		if _, found := secret.Data[id]; !found {
+			count++
+		}
+	}
+	return count
+}
+
+// countTargetsInSecrets counts the distinct target ids in all the given secrets
+func countTargetsInSecrets(secrets []apiv1.Secret) int {
+	return len(targetDataInSecrets(secrets))
+}
+
+// findSecretsByProbeType returns the secrets managed by the registrar that keep the targets of the given probe type.
+// Unless the adoption policy is to refuse them, it also looks for an unmanaged secret named after the probe type, as
+// created by earlier versions of this library, and returns it as it is to be adopted by the writes, or reports it.
+func (r *K8sSecretsRegistrar) findSecretsByProbeType(probeType string) ([]apiv1.Secret, error) {
+	listedSecrets, err := r.client.Secrets(r.namespace).List(managedSecretsListOptions(probeType))
+	if err != nil {
+		return nil, err
+	}
+	// Distinct probe types may share the same label value in the unlikely event of a hash collision
+	var matchedSecrets []apiv1.Secret
+	for i := range listedSecrets.Items {
+		if secretProbeType, _ := ProbeTypeForSecret(&listedSecrets.Items[i]); secretProbeType == probeType {
+			matchedSecrets = append(matchedSecrets, listedSecrets.Items[i])
+		}
+	}
+	name, lookedFor := r.unmanagedSecretName(probeType)
+	if !lookedFor || findSecretByName(matchedSecrets, name) != nil {
+		return matchedSecrets, nil
+	}
+	unmanagedSecret, err := r.client.Secrets(r.namespace).Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return matchedSecrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isManagedSecret(unmanagedSecret) ||
+		unmanagedSecret.Type != "" && unmanagedSecret.Type != apiv1.SecretTypeOpaque {
+		// managed for another probe type, or not meant to keep target info at all
+		return matchedSecrets, nil
+	}
+	if err := r.checkOwnership(unmanagedSecret, probeType); err != nil {
 		return nil, err
 	}
-	return &matchedSecrets.Items[0], nil
+	return append(matchedSecrets, *unmanagedSecret), nil
+}
+
+// findSecretByName returns the secret of the given name among the given secrets, or nil if not found
+func findSecretByName(secrets []apiv1.Secret, name string) *apiv1.Secret {
+	for i := range secrets {
+		if secrets[i].Name == name {
+			return &secrets[i]
+		}
+	}
+	return nil
 }
 
 // patchSecret patches a secret to the given new version.  The old version is also passed in to calculate the diff.
@@ -149,3 +486,7 @@ func (r *K8sSecretsRegistrar) patchSecret(oldSecret *apiv1.Secret, newSecret *ap
 	}
 	return r.client.Secrets(r.namespace).Patch(newSecret.GetName(), types.StrategicMergePatchType, patchBytes)
 }
+
+// Make sure K8sSecretsRegistrar implements the Registrar interface, or a compilation error will result
+var _ target_registrar.Registrar = (*K8sSecretsRegistrar)(nil)
+var _ target_registrar.ValidatingRegistrar = (*K8sSecretsRegistrar)(nil)
@@ -1,17 +1,23 @@
 package k8s_secret_test
 
 import (
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
 	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/k8s_secret"
 	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes/fake"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/testing"
+	"strings"
 )
 
 var (
@@ -61,6 +67,19 @@ func getFakeClient(existingTargets []target_registrar.Target, probeTypeToFilter
 	return client, nil
 }
 
+// suffixingLayout keeps all the targets of a probe type in one secret, named after the probe type with a fixed suffix
+type suffixingLayout struct {
+	suffix string
+}
+
+func (l suffixingLayout) SecretName(probeType string, id string) string {
+	return probeType + l.suffix
+}
+
+func (l suffixingLayout) DeletesEmptySecrets() bool {
+	return false
+}
+
 // filterTargets iterates the given list of targets and filters out those with the same type and id as the targetToSkip
 func filterTargets(targetsToFilter []target_registrar.Target, targetToSkip target_registrar.Target) []target_registrar.Target {
 	var result []target_registrar.Target
@@ -79,10 +98,9 @@ func checkTargets(client corev1.CoreV1Interface, targetsToCheck []target_registr
 		secret, err := client.Secrets(testNamespace).Get(expectedTarget.GetProbeType(), metav1.GetOptions{})
 		Expect(err).NotTo(HaveOccurred())
 
-		// Unmarshal the secret to retrieve the target info
-		retrievedBytes := []byte (secret.StringData[expectedTarget.GetId()])
-		var retrievedTarget target_registrar.UserPassTarget
-		err = target_registrar.UserPassTargetFromBytes(retrievedBytes, &retrievedTarget)
+		// Decode the secret to retrieve the target info
+		retrievedBytes := secret.Data[expectedTarget.GetId()]
+		retrievedTarget, err := target_registrar.DecodeTarget(expectedTarget.GetProbeType(), expectedTarget.GetId(), retrievedBytes)
 		Expect(err).NotTo(HaveOccurred())
 		Expect(retrievedTarget).To(Equal(expectedTarget))
 	}
@@ -98,9 +116,17 @@ var _ = Describe("Test k8s secret registrar", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			// Register the new expectedTarget
-			probeTypeHasTarget, err := targetRegistrar.RegisterTarget(newTarget)
+			result, err := targetRegistrar.RegisterTarget(newTarget)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(probeTypeHasTarget).To(Equal(true))
+			// Check the change reported against the targets existing before
+			expectedChange := target_registrar.RegistrationCreated
+			for _, tgt := range existingTargets {
+				if tgt.GetProbeType() == newTarget.GetProbeType() && tgt.GetId() == newTarget.GetId() {
+					expectedChange = target_registrar.RegistrationUpdated
+				}
+			}
+			Expect(result.Change).To(Equal(expectedChange))
+			Expect(result.NewCount).To(BeNumerically(">", 0))
 
 			// Retrieve secrets from the fake client to confirm all targets supposed to be there are there
 			targetsToCheck := filterTargets(existingTargets, newTarget)
@@ -129,16 +155,22 @@ var _ = Describe("Test k8s secret registrar", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			// Unregister the target
-			probeTypeHasNoTarget, err := targetRegistrar.UnregisterTarget(targetToUnregister)
+			result, err := targetRegistrar.UnregisterTarget(targetToUnregister)
 			Expect(err).NotTo(HaveOccurred())
-			// Check if we expect the corresponding probe type still has a target
-			expectedNoTarget := true
+			// Check if we expect the target to have been the last one of the corresponding probe type
+			existed, remaining := false, 0
 			for _, tgt := range existingTargets {
-				if tgt.GetProbeType() == targetToUnregister.GetProbeType() && tgt.GetId() != targetToUnregister.GetId() {
-					expectedNoTarget = false
+				if tgt.GetProbeType() != targetToUnregister.GetProbeType() {
+					continue
+				}
+				if tgt.GetId() == targetToUnregister.GetId() {
+					existed = true
+				} else {
+					remaining++
 				}
 			}
-			Expect(probeTypeHasNoTarget).To(Equal(expectedNoTarget))
+			Expect(result.NewCount).To(Equal(remaining))
+			Expect(result.IsLastTarget()).To(Equal(existed && remaining == 0))
 
 			// Retrieve secrets from the fake client to confirm all targets supposed to be there are there
 			targetsToCheck := filterTargets(existingTargets, targetToUnregister)
@@ -156,4 +188,583 @@ var _ = Describe("Test k8s secret registrar", func() {
 			[]target_registrar.Target{target_registrar.UserPassTarget{Id: "Moid1", Probetype:"vcenter", Username:"user1", Password:"pass1"}},
 			target_registrar.UserPassTarget{Id: "Moid1", Probetype:"vcenter", Username:"user1", Password:"pass1"}),
 	)
+
+	DescribeTable("test counting the targets of a probe type",
+		func(existingTargets []target_registrar.Target, probeType string, expectedCount int) {
+			client, err := getFakeClient(existingTargets, probeType)
+			Expect(err).NotTo(HaveOccurred())
+
+			targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			count, err := targetRegistrar.CountTargets(probeType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(expectedCount))
+		},
+		Entry("count the targets when no secrets exist", []target_registrar.Target{}, "vcenter", 0),
+		Entry("count the targets when only a secret of a different probe type exists",
+			[]target_registrar.Target{target_registrar.UserPassTarget{Id: "Moid2", Probetype:"pure", Username:"user2", Password:"pass2"}},
+			"vcenter", 0),
+		Entry("count the only target of the probe type",
+			[]target_registrar.Target{target_registrar.UserPassTarget{Id: "Moid1", Probetype:"vcenter", Username:"user1", Password:"pass1"}},
+			"vcenter", 1),
+	)
+
+	It("lists only the probe types of the secrets keeping target info", func() {
+		client := fake.NewSimpleClientset().CoreV1()
+		secret, err := k8s_secret.TargetToSecret(target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter", Username: "user1", Password: "pass1"})
+		Expect(err).NotTo(HaveOccurred())
+		unrelatedSecrets := []*apiv1.Secret{
+			{ObjectMeta: metav1.ObjectMeta{Name: "db-credentials"}, Data: map[string][]byte{"password": []byte("hunter2")}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "app-config"}, StringData: map[string]string{"config": "id: config\nprobetype: other\n"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "default-token"}, Type: apiv1.SecretTypeServiceAccountToken},
+		}
+		for _, existingSecret := range append(unrelatedSecrets, secret) {
+			_, err := client.Secrets(testNamespace).Create(existingSecret)
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targetRegistrar.ListProbeTypes()).To(Equal([]string{"vcenter"}))
+	})
+
+	DescribeTable("test listing and getting the targets of a probe type",
+		func(existingTargets []target_registrar.Target, probeType string, expectedTargets []target_registrar.Target) {
+			client, err := getFakeClient(existingTargets, probeType)
+			Expect(err).NotTo(HaveOccurred())
+
+			targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+
+			targets, err := targetRegistrar.ListTargets(probeType)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(HaveLen(len(expectedTargets)))
+			for i, expectedTarget := range expectedTargets {
+				Expect(targets[i]).To(Equal(expectedTarget))
+
+				target, err := targetRegistrar.GetTarget(probeType, expectedTarget.GetId())
+				Expect(err).NotTo(HaveOccurred())
+				Expect(target).To(Equal(targets[i]))
+			}
+
+			target, err := targetRegistrar.GetTarget(probeType, "MoidNotFound")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target).To(BeNil())
+		},
+		Entry("list the targets when no secrets exist", []target_registrar.Target{}, "vcenter",
+			[]target_registrar.Target{}),
+		Entry("list the targets when only a secret of a different probe type exists",
+			[]target_registrar.Target{target_registrar.UserPassTarget{Id: "Moid2", Probetype:"pure", Username:"user2", Password:"pass2"}},
+			"vcenter", []target_registrar.Target{}),
+		Entry("list the only target of the probe type",
+			[]target_registrar.Target{target_registrar.UserPassTarget{Id: "Moid1", Probetype:"vcenter", Username:"user1", Password:"pass1"}},
+			"vcenter",
+			[]target_registrar.Target{target_registrar.UserPassTarget{Id: "Moid1", Probetype:"vcenter", Username:"user1", Password:"pass1"}}),
+	)
+
+	It("never reveals the credentials of a target in its errors", func() {
+		fakeClientSet := fake.NewSimpleClientset()
+		fakeClientSet.Fake.PrependReactor("*", "secrets",
+			func(action testing.Action) (handled bool, ret runtime.Object, err error) {
+				return true, nil, fmt.Errorf("api server is down")
+			})
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(fakeClientSet.CoreV1(), testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		target := target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter", Username: "user1", Password: "pass1"}
+
+		_, registerErr := targetRegistrar.RegisterTarget(target)
+		_, unregisterErr := targetRegistrar.UnregisterTarget(target)
+		for _, err := range []error{registerErr, unregisterErr} {
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("vcenter/Moid1"))
+			Expect(err.Error()).NotTo(ContainSubstring("pass1"))
+		}
+	})
+
+	It("keeps binary target info intact", func() {
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(fake.NewSimpleClientset().CoreV1(), testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		target := target_registrar.CertificateTarget{Id: "Moid1", Probetype: "netapp",
+			Certificate: string([]byte{0x30, 0x82, 0x01, 0x0a, 0xff, 0xfe}), Key: string([]byte{0x00, 0xc3, 0x28, 0xa0, 0xa1})}
+
+		_, err = targetRegistrar.RegisterTarget(target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targetRegistrar.GetTarget("netapp", "Moid1")).To(Equal(target))
+	})
+
+	DescribeTable("test validating a target against the secret naming rules",
+		func(target target_registrar.Target, expectedFields []string) {
+			targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(fake.NewSimpleClientset().CoreV1(), testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			var fields []string
+			for _, validationErr := range target_registrar.AsValidationErrors(targetRegistrar.ValidateTarget(target)) {
+				fields = append(fields, validationErr.Field)
+			}
+			Expect(fields).To(Equal(expectedFields))
+		},
+		Entry("a valid target", target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter"}, nil),
+		Entry("a probe type that is not a DNS-1123 subdomain, encoded into one", target_registrar.UserPassTarget{
+			Id: "Moid1", Probetype: "vCenter_Probe"}, nil),
+		Entry("an id that is not a valid data key, encoded into one", target_registrar.UserPassTarget{Id: "Moid:1/2",
+			Probetype: "vcenter"}, nil),
+		Entry("an id that starts with '..', encoded into a valid data key", target_registrar.UserPassTarget{
+			Id: "../Moid1", Probetype: "vcenter"}, nil),
+		Entry("an id that is '.', encoded into a valid data key", target_registrar.UserPassTarget{Id: ".",
+			Probetype: "vcenter"}, nil),
+	)
+
+	It("validates the secret name the layout gives a target, suffix included", func() {
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(fake.NewSimpleClientset().CoreV1(), testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		targetRegistrar.WithLayout(suffixingLayout{suffix: "-" + strings.Repeat("x", 250)})
+		validationErrs := target_registrar.AsValidationErrors(targetRegistrar.ValidateTarget(
+			target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter"}))
+		Expect(validationErrs).To(HaveLen(1))
+		Expect(validationErrs[0].Field).To(Equal("Probetype"))
+		Expect(validationErrs[0].Reason).To(ContainSubstring("must be no more than 253 characters"))
+	})
+
+	DescribeTable("test keeping targets whose probe type and id break the Kubernetes naming rules",
+		func(probeType string, ids []string, expectedName string) {
+			client := fake.NewSimpleClientset().CoreV1()
+			targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			var expectedTargets []target_registrar.Target
+			for _, id := range ids {
+				target := target_registrar.UserPassTarget{Id: id, Probetype: probeType, Username: "user", Password: "pass"}
+				_, err := targetRegistrar.RegisterTarget(target)
+				Expect(err).NotTo(HaveOccurred())
+				expectedTargets = append(expectedTargets, target)
+			}
+
+			secret, err := client.Secrets(testNamespace).Get(k8s_secret.EncodeSecretName(probeType), metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret.Name).To(MatchRegexp(expectedName))
+			Expect(validation.IsDNS1123Subdomain(secret.Name)).To(BeEmpty())
+			Expect(validation.IsValidLabelValue(secret.Labels[k8s_secret.ProbeTypeLabel])).To(BeEmpty())
+			Expect(secret.Data).To(HaveLen(len(ids)))
+			for key := range secret.Data {
+				Expect(validation.IsConfigMapKey(key)).To(BeEmpty())
+			}
+
+			Expect(targetRegistrar.ListProbeTypes()).To(Equal([]string{probeType}))
+			Expect(targetRegistrar.ListTargets(probeType)).To(ConsistOf(expectedTargets))
+			for _, target := range expectedTargets {
+				Expect(targetRegistrar.GetTarget(probeType, target.GetId())).To(Equal(target))
+			}
+			for i, target := range expectedTargets {
+				result, err := targetRegistrar.UnregisterTarget(target)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.IsLastTarget()).To(Equal(i == len(expectedTargets)-1))
+			}
+		},
+		Entry("valid names are kept as they are", "vcenter", []string{"Moid1", "Moid2"}, "^vcenter$"),
+		Entry("a probe type that is not a DNS-1123 subdomain", "vCenter_Probe", []string{"Moid1"},
+			"^vcenter-probe-[0-9a-f]{10}$"),
+		Entry("a probe type too long for a label value", strings.Repeat("vcenter", 10), []string{"Moid1"},
+			"^(vcenter)+v?c?e?n?t?e?r?-[0-9a-f]{10}$"),
+		Entry("ids that are not valid data keys, including two that escape the same way", "vcenter",
+			[]string{"Moid:1/2", "Moid:1:2", "https://10.10.10.10:443/sdk"}, "^vcenter$"),
+		Entry("ids made of or starting with dots", "vcenter", []string{".", "..", "../Moid1", ".hidden"}, "^vcenter$"),
+	)
+
+	DescribeTable("test refusing to keep a target under the data key of another id",
+		func(keptId string, refusedId string) {
+			client := fake.NewSimpleClientset().CoreV1()
+			targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			keptTarget := target_registrar.UserPassTarget{Id: keptId, Probetype: "vcenter", Username: "user1",
+				Password: "pass1"}
+			_, err = targetRegistrar.RegisterTarget(keptTarget)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8s_secret.EncodeDataKey(refusedId)).To(Equal(k8s_secret.EncodeDataKey(keptId)))
+
+			refusedTarget := target_registrar.UserPassTarget{Id: refusedId, Probetype: "vcenter", Username: "user2",
+				Password: "pass2"}
+			_, err = targetRegistrar.RegisterTarget(refusedTarget)
+			Expect(err).To(HaveOccurred())
+			result, err := targetRegistrar.UnregisterTarget(refusedTarget)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Change).To(Equal(target_registrar.RegistrationUnchanged))
+			Expect(targetRegistrar.ListTargets("vcenter")).To(ConsistOf(keptTarget))
+			Expect(targetRegistrar.GetTarget("vcenter", keptId)).To(Equal(keptTarget))
+			Expect(targetRegistrar.GetTarget("vcenter", refusedId)).To(BeNil())
+		},
+		Entry("an encoded id after an id that is its data key as is", "a_b."+hashOf("a/b"), "a/b"),
+		Entry("an id that is a data key as is after the id encoded into it", "a/b", "a_b."+hashOf("a/b")),
+	)
+})
+
+// hashOf returns the hash EncodeDataKey suffixes to the key of an id that is not a valid data key
+func hashOf(id string) string {
+	key := k8s_secret.EncodeDataKey(id)
+	return key[strings.LastIndex(key, ".")+1:]
+}
+
+var _ = Describe("Test k8s secret layouts", func() {
+	DescribeTable("test counting the targets the same way under each layout",
+		func(layout k8s_secret.SecretLayout, expectedSecrets int) {
+			client := fake.NewSimpleClientset().CoreV1()
+			targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			targetRegistrar.WithLayout(layout)
+
+			ids := []string{"Moid1", "Moid2", "Moid3", "Moid4", "Moid5", "Moid6"}
+			for i, id := range ids {
+				result, err := targetRegistrar.RegisterTarget(target_registrar.UserPassTarget{Id: id,
+					Probetype: "vcenter", Username: "user-" + id, Password: "pass-" + id})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result).To(Equal(target_registrar.RegistrationResult{
+					Change: target_registrar.RegistrationCreated, PreviousCount: i, NewCount: i + 1}))
+			}
+			secrets, err := client.Secrets(testNamespace).List(metav1.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(secrets.Items)).To(BeNumerically("<=", expectedSecrets))
+			for _, secret := range secrets.Items {
+				Expect(secret.Labels).To(HaveKeyWithValue(k8s_secret.ProbeTypeLabel, "vcenter"))
+			}
+			Expect(targetRegistrar.CountTargets("vcenter")).To(Equal(len(ids)))
+			Expect(targetRegistrar.ListProbeTypes()).To(Equal([]string{"vcenter"}))
+			targets, err := targetRegistrar.ListTargets("vcenter")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targets).To(HaveLen(len(ids)))
+			Expect(targetRegistrar.GetTarget("vcenter", "Moid3")).To(Equal(target_registrar.UserPassTarget{
+				Id: "Moid3", Probetype: "vcenter", Username: "user-Moid3", Password: "pass-Moid3"}))
+
+			for i, id := range ids {
+				result, err := targetRegistrar.UnregisterTarget(target_registrar.UserPassTarget{Id: id,
+					Probetype: "vcenter"})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.IsLastTarget()).To(Equal(i == len(ids)-1))
+				Expect(targetRegistrar.CountTargets("vcenter")).To(Equal(len(ids) - i - 1))
+			}
+		},
+		Entry("one secret per probe type", k8s_secret.PerProbeTypeLayout{}, 1),
+		Entry("one secret per target", k8s_secret.PerTargetLayout{}, 6),
+		Entry("targets sharded across 3 secrets", k8s_secret.NewShardedLayout(3), 3),
+		Entry("targets of a zero-value sharded layout kept in a single shard", &k8s_secret.ShardedLayout{}, 1),
+	)
+
+	It("moves a target to the secret of the new layout when it is updated", func() {
+		client := fake.NewSimpleClientset().CoreV1()
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		target := target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter", Username: "user1", Password: "pass1"}
+		_, err = targetRegistrar.RegisterTarget(target)
+		Expect(err).NotTo(HaveOccurred())
+
+		targetRegistrar.WithLayout(k8s_secret.PerTargetLayout{})
+		Expect(targetRegistrar.CountTargets("vcenter")).To(Equal(1))
+		_, err = targetRegistrar.RegisterTarget(target)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targetRegistrar.CountTargets("vcenter")).To(Equal(1))
+		// The secret of the old layout is left empty, and deleted as the new layout does with empty secrets
+		_, err = client.Secrets(testNamespace).Get("vcenter", metav1.GetOptions{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("keeps the targets written concurrently when removing a target is retried upon a conflict", func() {
+		fakeClientSet := fake.NewSimpleClientset()
+		enforceResourceVersions(fakeClientSet)
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(fakeClientSet.CoreV1(), testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		// The ids are not valid data keys, so that the secret records them in its annotations as well
+		for _, id := range []string{"https://vc1", "https://vc2"} {
+			_, err := targetRegistrar.RegisterTarget(target_registrar.UserPassTarget{Id: id, Probetype: "vcenter",
+				Username: "user", Password: "pass"})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		// Another writer adds a target right after the secret is listed, so that the first update is stale
+		conflicted := false
+		fakeClientSet.Fake.PrependReactor("list", "secrets",
+			func(action testing.Action) (handled bool, ret runtime.Object, err error) {
+				if conflicted {
+					return false, nil, nil
+				}
+				conflicted = true
+				gvr := apiv1.SchemeGroupVersion.WithResource("secrets")
+				listed, err := fakeClientSet.Tracker().List(gvr, apiv1.SchemeGroupVersion.WithKind("Secret"),
+					testNamespace)
+				Expect(err).NotTo(HaveOccurred())
+				obj, err := fakeClientSet.Tracker().Get(gvr, testNamespace, "vcenter")
+				Expect(err).NotTo(HaveOccurred())
+				secret := obj.(*apiv1.Secret)
+				data, err := target_registrar.EncodeTarget(target_registrar.UserPassTarget{Id: "https://vc3",
+					Probetype: "vcenter", Username: "user", Password: "pass"})
+				Expect(err).NotTo(HaveOccurred())
+				ids := map[string]string{}
+				for _, id := range []string{"https://vc1", "https://vc2", "https://vc3"} {
+					ids[k8s_secret.EncodeDataKey(id)] = id
+				}
+				annotation, err := json.Marshal(ids)
+				Expect(err).NotTo(HaveOccurred())
+				secret.Data[k8s_secret.EncodeDataKey("https://vc3")] = data
+				secret.Annotations[k8s_secret.TargetIdsAnnotation] = string(annotation)
+				secret.ResourceVersion += "-concurrent"
+				Expect(fakeClientSet.Tracker().Update(gvr, secret, testNamespace)).To(Succeed())
+				return true, listed, nil
+			})
+
+		result, err := targetRegistrar.UnregisterTarget(target_registrar.UserPassTarget{Id: "https://vc1",
+			Probetype: "vcenter"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflicted).To(BeTrue())
+		Expect(result.PreviousCount).To(Equal(2))
+		Expect(result.NewCount).To(Equal(2))
+		for _, id := range []string{"https://vc2", "https://vc3"} {
+			target, err := targetRegistrar.GetTarget("vcenter", id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target).NotTo(BeNil())
+		}
+	})
+})
+
+// enforceResourceVersions has the fake client bump the resourceVersion of a secret upon every write, and reject with a
+// conflict an update carrying another resourceVersion than the current one, as the API server does
+func enforceResourceVersions(fakeClientSet *fake.Clientset) {
+	gvr := apiv1.SchemeGroupVersion.WithResource("secrets")
+	version := 0
+	bump := func(secret *apiv1.Secret) {
+		version++
+		secret.ResourceVersion = fmt.Sprint(version)
+	}
+	fakeClientSet.Fake.PrependReactor("create", "secrets",
+		func(action testing.Action) (handled bool, ret runtime.Object, err error) {
+			bump(action.(testing.CreateAction).GetObject().(*apiv1.Secret))
+			return false, nil, nil
+		})
+	fakeClientSet.Fake.PrependReactor("update", "secrets",
+		func(action testing.Action) (handled bool, ret runtime.Object, err error) {
+			secret := action.(testing.UpdateAction).GetObject().(*apiv1.Secret)
+			obj, err := fakeClientSet.Tracker().Get(gvr, action.GetNamespace(), secret.Name)
+			if err != nil {
+				return false, nil, nil
+			}
+			if current := obj.(*apiv1.Secret).ResourceVersion; secret.ResourceVersion != current {
+				return true, nil, errors.NewConflict(gvr.GroupResource(), secret.Name,
+					fmt.Errorf("resourceVersion %v is not the current %v", secret.ResourceVersion, current))
+			}
+			bump(secret)
+			return false, nil, nil
+		})
+}
+
+var _ = Describe("Test k8s secret ownership", func() {
+	// unmanagedSecret is a secret named after a probe type that the registrar has not created, e.g. one created by an
+	// earlier version of this library, or an unrelated secret that happens to bear that name
+	var unmanagedSecret = func() *apiv1.Secret {
+		secret, err := k8s_secret.TargetToSecret(target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter",
+			Username: "user1", Password: "pass1"})
+		Expect(err).NotTo(HaveOccurred())
+		secret.Namespace, secret.Labels, secret.Annotations = testNamespace, nil, nil
+		return secret
+	}
+
+	DescribeTable("test dealing with a secret the registrar has not created",
+		func(policy k8s_secret.AdoptionPolicy, expectedCountBefore int, expectRegisterErr bool, expectedCountAfter int) {
+			client := fake.NewSimpleClientset(unmanagedSecret()).CoreV1()
+			targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+			Expect(err).NotTo(HaveOccurred())
+			targetRegistrar.WithAdoptionPolicy(policy)
+			Expect(targetRegistrar.ListProbeTypes()).To(BeEmpty())
+
+			count, err := targetRegistrar.CountTargets("vcenter")
+			if policy == k8s_secret.AdoptionPolicyError {
+				var unmanagedErr *k8s_secret.UnmanagedSecretError
+				Expect(goerrors.As(err, &unmanagedErr)).To(BeTrue())
+			} else {
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(count).To(Equal(expectedCountBefore))
+			// Reading the targets leaves the secret alone whatever the policy
+			secret, err := client.Secrets(testNamespace).Get("vcenter", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret.Labels).To(BeEmpty())
+			adopting := policy == k8s_secret.AdoptionPolicyAdopt || policy == k8s_secret.AdoptionPolicyAdoptLegacy
+			if adopting {
+				Expect(targetRegistrar.ListTargets("vcenter")).To(HaveLen(1))
+				Expect(targetRegistrar.GetTarget("vcenter", "Moid1")).NotTo(BeNil())
+				Expect(targetRegistrar.ListProbeTypes()).To(BeEmpty())
+			}
+
+			_, err = targetRegistrar.RegisterTarget(target_registrar.UserPassTarget{Id: "Moid2", Probetype: "vcenter",
+				Username: "user2", Password: "pass2"})
+			Expect(err != nil).To(Equal(expectRegisterErr))
+			if expectRegisterErr {
+				Expect(err.Error()).To(ContainSubstring("is not managed by"))
+			}
+
+			secret, err = client.Secrets(testNamespace).Get("vcenter", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(secret.Data).To(HaveLen(expectedCountAfter))
+			if adopting {
+				Expect(secret.Labels).To(HaveKeyWithValue(k8s_secret.ManagedByLabel, k8s_secret.ManagedByValue))
+				Expect(secret.Annotations).To(HaveKeyWithValue(k8s_secret.AdoptedAnnotation, "true"))
+				Expect(targetRegistrar.ListProbeTypes()).To(Equal([]string{"vcenter"}))
+			} else {
+				Expect(secret.Labels).To(BeEmpty())
+			}
+		},
+		Entry("refuse the secret", k8s_secret.AdoptionPolicyRefuse, 0, true, 1),
+		Entry("adopt the secret", k8s_secret.AdoptionPolicyAdopt, 1, false, 2),
+		Entry("adopt the secret of the legacy name", k8s_secret.AdoptionPolicyAdoptLegacy, 1, false, 2),
+		Entry("report the secret", k8s_secret.AdoptionPolicyError, 0, true, 1),
+	)
+
+	It("ignores by default an unlabeled secret named after a probe type", func() {
+		unrelatedSecret := &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "vcenter", Namespace: testNamespace},
+			Type:       apiv1.SecretTypeOpaque,
+			Data:       map[string][]byte{"other": []byte("unrelated")},
+		}
+		client := fake.NewSimpleClientset(unrelatedSecret).CoreV1()
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(targetRegistrar.CountTargets("vcenter")).To(Equal(0))
+		Expect(targetRegistrar.ListProbeTypes()).To(BeEmpty())
+		_, err = targetRegistrar.RegisterTarget(target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter",
+			Username: "user1", Password: "pass1"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("is not managed by"))
+		secret, err := client.Secrets(testNamespace).Get("vcenter", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Labels).To(BeEmpty())
+		Expect(secret.Data).To(Equal(map[string][]byte{"other": []byte("unrelated")}))
+	})
+
+	It("adopts a secret written by an earlier version of this library once legacy adoption is turned on", func() {
+		// Earlier versions kept the bare target info in the StringData of an unlabeled secret named after the probe type
+		bytes, err := target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter", Username: "user1",
+			Password: "pass1"}.Bytes()
+		Expect(err).NotTo(HaveOccurred())
+		legacySecret := &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "vcenter", Namespace: testNamespace},
+			StringData: map[string]string{"Moid1": string(bytes)},
+		}
+		client := fake.NewSimpleClientset(legacySecret).CoreV1()
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		targetRegistrar.WithAdoptionPolicy(k8s_secret.AdoptionPolicyAdoptLegacy)
+
+		Expect(targetRegistrar.CountTargets("vcenter")).To(Equal(1))
+		target, err := targetRegistrar.GetTarget("vcenter", "Moid1")
+		Expect(err).NotTo(HaveOccurred())
+		// No kind is registered for the probe type, so the bare target info is read back as it is
+		Expect(target).To(Equal(target_registrar.RawTarget{Id: "Moid1", Probetype: "vcenter", Data: bytes}))
+
+		result, err := targetRegistrar.RegisterTarget(target_registrar.UserPassTarget{Id: "Moid2", Probetype: "vcenter",
+			Username: "user2", Password: "pass2"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.PreviousCount).To(Equal(1))
+		Expect(result.NewCount).To(Equal(2))
+		secret, err := client.Secrets(testNamespace).Get("vcenter", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Labels).To(HaveKeyWithValue(k8s_secret.ManagedByLabel, k8s_secret.ManagedByValue))
+		Expect(secret.Annotations).To(HaveKeyWithValue(k8s_secret.AdoptedAnnotation, "true"))
+		Expect(targetRegistrar.ListProbeTypes()).To(Equal([]string{"vcenter"}))
+		Expect(targetRegistrar.CountTargets("vcenter")).To(Equal(2))
+	})
+
+	It("refuses under legacy adoption an unmanaged secret bearing another name it would keep targets in", func() {
+		// Under the per-target layout the secret of the target is not the one earlier versions created
+		name := k8s_secret.PerTargetLayout{}.SecretName("vcenter", "Moid1")
+		unrelatedSecret := &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+			Data:       map[string][]byte{"other": []byte("unrelated")},
+		}
+		client := fake.NewSimpleClientset(unrelatedSecret).CoreV1()
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		targetRegistrar.WithLayout(k8s_secret.PerTargetLayout{}).
+			WithAdoptionPolicy(k8s_secret.AdoptionPolicyAdoptLegacy)
+
+		_, err = targetRegistrar.RegisterTarget(target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter",
+			Username: "user1", Password: "pass1"})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("is not managed by"))
+		secret, err := client.Secrets(testNamespace).Get(name, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Labels).To(BeEmpty())
+		Expect(secret.Data).To(Equal(map[string][]byte{"other": []byte("unrelated")}))
+	})
+
+	It("tells the probe type of an unmanaged secret only if the adoption policy reads the targets in it", func() {
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(fake.NewSimpleClientset().CoreV1(),
+			testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		legacySecret := &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "vcenter", Namespace: testNamespace}}
+		_, adoptable := targetRegistrar.AdoptableProbeType(legacySecret)
+		Expect(adoptable).To(BeFalse())
+
+		targetRegistrar.WithAdoptionPolicy(k8s_secret.AdoptionPolicyAdoptLegacy)
+		probeType, adoptable := targetRegistrar.AdoptableProbeType(legacySecret)
+		Expect(adoptable).To(BeTrue())
+		Expect(probeType).To(Equal("vcenter"))
+		tlsSecret := &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "vcenter", Namespace: testNamespace},
+			Type: apiv1.SecretTypeTLS}
+		_, adoptable = targetRegistrar.AdoptableProbeType(tlsSecret)
+		Expect(adoptable).To(BeFalse())
+		overridesSecret := &apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: k8s_secret.OverridesSecretName,
+			Namespace: testNamespace}}
+		_, adoptable = targetRegistrar.AdoptableProbeType(overridesSecret)
+		Expect(adoptable).To(BeFalse())
+	})
+
+	It("labels and annotates the secrets it creates", func() {
+		client := fake.NewSimpleClientset().CoreV1()
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = targetRegistrar.RegisterTarget(target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter",
+			Username: "user1", Password: "pass1"})
+		Expect(err).NotTo(HaveOccurred())
+		secret, err := client.Secrets(testNamespace).Get("vcenter", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Labels).To(Equal(map[string]string{k8s_secret.ManagedByLabel: k8s_secret.ManagedByValue,
+			k8s_secret.ProbeTypeLabel: "vcenter"}))
+		Expect(secret.Annotations).To(HaveKeyWithValue(k8s_secret.ProbeTypeAnnotation, "vcenter"))
+		probeType, isTargetSecret := k8s_secret.ProbeTypeForSecret(secret)
+		Expect(isTargetSecret).To(BeTrue())
+		Expect(probeType).To(Equal("vcenter"))
+	})
+
+	It("keeps the override modes in a secret of their own", func() {
+		client := fake.NewSimpleClientset().CoreV1()
+		targetRegistrar, err := k8s_secret.NewK8sSecretsTargetRegistrarFromClient(client, testNamespace)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = targetRegistrar.RegisterTarget(target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter",
+			Username: "user1", Password: "pass1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targetRegistrar.SetOverrideMode("vcenter", target_registrar.OverrideForceDisabled)).To(Succeed())
+		Expect(targetRegistrar.SetOverrideMode("aws/ec2", target_registrar.OverrideForceEnabled)).To(Succeed())
+		Expect(targetRegistrar.ListProbeTypes()).To(Equal([]string{"vcenter"}))
+		secret, err := client.Secrets(testNamespace).Get(k8s_secret.OverridesSecretName, metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Data).To(HaveKey(k8s_secret.EncodeDataKey("aws/ec2")))
+		Expect(secret.Labels).To(HaveKeyWithValue(k8s_secret.SecretKindLabel, k8s_secret.OverridesSecretKind))
+		_, isTargetSecret := k8s_secret.ProbeTypeForSecret(secret)
+		Expect(isTargetSecret).To(BeFalse())
+		overrides, isOverridesSecret := k8s_secret.OverrideModesForSecret(secret)
+		Expect(isOverridesSecret).To(BeTrue())
+		Expect(overrides).To(HaveKeyWithValue("aws/ec2", target_registrar.OverrideForceEnabled))
+
+		// a probe type whose secret would be the overrides secret is rejected
+		validationErrs := target_registrar.AsValidationErrors(targetRegistrar.ValidateTarget(
+			target_registrar.UserPassTarget{Id: "Moid1", Probetype: k8s_secret.OverridesSecretName}))
+		Expect(validationErrs).To(HaveLen(1))
+		Expect(validationErrs[0].Field).To(Equal("Probetype"))
+
+		Expect(targetRegistrar.GetOverrideMode("aws/ec2")).To(Equal(target_registrar.OverrideForceEnabled))
+		// a probe type that is the data key of another probe type as is is refused rather than overriding it
+		collidingProbeType := "aws_ec2." + hashOf("aws/ec2")
+		err = targetRegistrar.SetOverrideMode(collidingProbeType, target_registrar.OverrideForceDisabled)
+		Expect(err).To(HaveOccurred())
+		Expect(targetRegistrar.SetOverrideMode(collidingProbeType, target_registrar.OverrideAuto)).To(Succeed())
+		Expect(targetRegistrar.GetOverrideMode("pure")).To(Equal(target_registrar.OverrideAuto))
+		Expect(targetRegistrar.SetOverrideMode("aws/ec2", target_registrar.OverrideAuto)).To(Succeed())
+		Expect(targetRegistrar.ListOverrideModes()).To(Equal(map[string]target_registrar.OverrideMode{
+			"vcenter": target_registrar.OverrideForceDisabled,
+		}))
+	})
 })
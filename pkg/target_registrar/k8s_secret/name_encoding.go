@@ -0,0 +1,132 @@
+package k8s_secret
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+const (
+	// TargetIdsAnnotation records the original ids of the targets whose ids had to be encoded to be used as data keys,
+	// as a JSON object mapping the data keys to the ids
+	TargetIdsAnnotation = "probe-lifecycle-manager.turbonomic.com/target-ids"
+	// hashSuffixLength is the number of hex digits of the hash suffixed to an encoded name or key
+	hashSuffixLength = 10
+)
+
+// EncodeSecretName turns the probe type into a name that is valid both as a secret name and as a label value.  A probe
+// type that is already valid as both is kept as is, so that the secrets created before this encoding keep their
+// names.  Any other probe type is lowercased, has its invalid characters replaced with '-', and gets a hash of the
+// original suffixed so that distinct probe types don't end up with the same name.  The original probe type is kept in
+// the ProbeTypeAnnotation of the secret.
+func EncodeSecretName(probeType string) string {
+	if len(validation.IsDNS1123Subdomain(probeType)) == 0 && len(validation.IsValidLabelValue(probeType)) == 0 {
+		return probeType
+	}
+	var escaped strings.Builder
+	for _, c := range strings.ToLower(probeType) {
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+			escaped.WriteRune(c)
+		} else if escaped.Len() > 0 && !strings.HasSuffix(escaped.String(), "-") {
+			escaped.WriteByte('-')
+		}
+	}
+	prefix := strings.TrimSuffix(escaped.String(), "-")
+	if maxLength := validation.DNS1123LabelMaxLength - hashSuffixLength - 1; len(prefix) > maxLength {
+		prefix = strings.TrimSuffix(prefix[:maxLength], "-")
+	}
+	if prefix == "" {
+		prefix = "probe"
+	}
+	return prefix + "-" + hashSuffix(probeType)
+}
+
+// EncodeDataKey turns the target id into a valid key of the secret data.  An id that is already valid is kept as is,
+// so that the targets stored before this encoding keep their keys.  Any other id has its invalid characters replaced
+// with '_', a leading '.' included so that the key never is "." or starts with "..", and gets a hash of the original
+// suffixed so that distinct ids don't end up with the same key.  The original id is kept in the TargetIdsAnnotation of
+// the secret.  A valid id may still be the very key another id is encoded into; the registrar refuses to keep the
+// second of the two in the same secret.
+func EncodeDataKey(id string) string {
+	if len(validation.IsConfigMapKey(id)) == 0 {
+		return id
+	}
+	escaped := []byte(id)
+	for i, c := range escaped {
+		if i == 0 && c == '.' || !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			escaped[i] = '_'
+		}
+	}
+	prefix := string(escaped)
+	if maxLength := validation.DNS1123SubdomainMaxLength - hashSuffixLength - 1; len(prefix) > maxLength {
+		prefix = prefix[:maxLength]
+	}
+	return prefix + "." + hashSuffix(id)
+}
+
+// hashSuffix returns a short hash of the given original name or id
+func hashSuffix(original string) string {
+	hash := sha256.Sum256([]byte(original))
+	return hex.EncodeToString(hash[:])[:hashSuffixLength]
+}
+
+// targetIdsInSecret returns the original ids of the targets recorded in the secret, keyed by their data keys
+func targetIdsInSecret(secret *apiv1.Secret) map[string]string {
+	ids := map[string]string{}
+	if annotation, found := secret.Annotations[TargetIdsAnnotation]; found {
+		// an annotation that cannot be decoded leaves the data keys as the ids
+		_ = json.Unmarshal([]byte(annotation), &ids)
+	}
+	return ids
+}
+
+// targetIdUnderKey returns the id of the target kept in the secret under the given data key, and whether one is kept
+func targetIdUnderKey(secret *apiv1.Secret, key string) (string, bool) {
+	_, inData := secret.Data[key]
+	_, inStringData := secret.StringData[key]
+	if !inData && !inStringData {
+		return "", false
+	}
+	if id, found := targetIdsInSecret(secret)[key]; found {
+		return id, true
+	}
+	return key, true
+}
+
+// checkDataKeyFor returns an error if the secret keeps the target of another id under the data key of the given id.
+// An id that is a valid key is kept as is, so it may be the very key another id is encoded into, e.g. "a_b.<hash>"
+// for "a/b"; the second target to come would otherwise silently overwrite the first.
+func checkDataKeyFor(secret *apiv1.Secret, key string, id string) error {
+	if keptId, found := targetIdUnderKey(secret, key); found && keptId != id {
+		return fmt.Errorf("data key %v of secret %v already keeps target %v, which target %v cannot replace", key,
+			secret.Name, keptId, id)
+	}
+	return nil
+}
+
+// setTargetIdInSecret records the original id of the target kept under the given data key, or forgets it if the id
+// is empty or the same as the key
+func setTargetIdInSecret(secret *apiv1.Secret, key string, id string) {
+	ids := targetIdsInSecret(secret)
+	if id == "" || id == key {
+		if _, found := ids[key]; !found {
+			return
+		}
+		delete(ids, key)
+	} else {
+		ids[key] = id
+	}
+	if len(ids) == 0 {
+		delete(secret.Annotations, TargetIdsAnnotation)
+		return
+	}
+	annotation, _ := json.Marshal(ids)
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[TargetIdsAnnotation] = string(annotation)
+}
@@ -0,0 +1,86 @@
+package k8s_secret
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+)
+
+// SecretLayout decides how the targets of a probe type are spread across secrets.  Whatever the layout, each target is
+// kept under its id in the data of a single secret, and the secrets of a probe type are found by their labels, so the
+// layouts only differ in how many secrets there are.
+type SecretLayout interface {
+	// SecretName returns the name of the secret to keep the target of the given probe type and id in; the probe type
+	// is passed in already encoded by EncodeSecretName
+	SecretName(probeType string, id string) string
+	// DeletesEmptySecrets returns true if a secret left with no target should be deleted
+	DeletesEmptySecrets() bool
+}
+
+// PerProbeTypeLayout keeps all the targets of a probe type in one secret named after the probe type.  This is the
+// default layout, and the one the secrets created by earlier versions of this library follow.
+type PerProbeTypeLayout struct{}
+
+// SecretName returns the probe type
+func (PerProbeTypeLayout) SecretName(probeType string, id string) string {
+	return probeType
+}
+
+// DeletesEmptySecrets returns false: the secret of a probe type is kept after its last target is removed
+func (PerProbeTypeLayout) DeletesEmptySecrets() bool {
+	return false
+}
+
+// PerTargetLayout keeps each target in its own secret, named after the probe type and a hash of the target id.
+// Updating a target only rewrites its own secret.
+type PerTargetLayout struct{}
+
+// SecretName returns the probe type suffixed with a hash of the target id
+func (PerTargetLayout) SecretName(probeType string, id string) string {
+	hash := sha256.Sum256([]byte(id))
+	return probeType + "-" + hex.EncodeToString(hash[:8])
+}
+
+// DeletesEmptySecrets returns true: the secret of a target is deleted along with the target
+func (PerTargetLayout) DeletesEmptySecrets() bool {
+	return true
+}
+
+// ShardedLayout spreads the targets of a probe type across a fixed number of secrets by a hash of the target id.  It
+// keeps each secret well under the size limit of Kubernetes objects without creating a secret per target.
+type ShardedLayout struct {
+	// Shards is the number of secrets to spread the targets of each probe type across; 0 is taken as 1
+	Shards uint32
+}
+
+// NewShardedLayout constructs a ShardedLayout spreading the targets of each probe type across the given number of
+// secrets
+func NewShardedLayout(shards uint32) *ShardedLayout {
+	if shards == 0 {
+		shards = 1
+	}
+	return &ShardedLayout{Shards: shards}
+}
+
+// SecretName returns the probe type suffixed with the shard of the target id
+func (l *ShardedLayout) SecretName(probeType string, id string) string {
+	shards := l.Shards
+	if shards == 0 {
+		// a zero-value layout keeps all the targets of a probe type in a single shard
+		shards = 1
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(id))
+	return fmt.Sprintf("%v-shard-%d", probeType, hash.Sum32()%shards)
+}
+
+// DeletesEmptySecrets returns true: a shard is deleted when its last target is removed
+func (l *ShardedLayout) DeletesEmptySecrets() bool {
+	return true
+}
+
+// Make sure all the layouts implement the SecretLayout interface, or a compilation error will result
+var _ SecretLayout = PerProbeTypeLayout{}
+var _ SecretLayout = PerTargetLayout{}
+var _ SecretLayout = (*ShardedLayout)(nil)
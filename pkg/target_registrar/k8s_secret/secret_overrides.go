@@ -0,0 +1,173 @@
+package k8s_secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// OverridesSecretName is the name of the secret keeping the override modes of the probes.  It is labeled as managed
+	// by the registrar, and as the overrides secret by SecretKindLabel, so that it is never mistaken for a secret
+	// keeping targets.
+	OverridesSecretName = "probe-lifecycle-manager-overrides"
+	// SecretKindLabel tells the secrets managed by the registrar that keep something other than targets
+	SecretKindLabel = "probe-lifecycle-manager.turbonomic.com/kind"
+	// OverridesSecretKind is the value of SecretKindLabel on the overrides secret
+	OverridesSecretKind = "overrides"
+)
+
+// storedOverride is the value kept in the overrides secret for a probe type, under its encoded data key
+type storedOverride struct {
+	ProbeType string                        `json:"probeType"`
+	Mode      target_registrar.OverrideMode `json:"mode"`
+}
+
+// SetOverrideMode keeps the override mode of the given probe type in the overrides secret, creating the secret if not
+// yet created
+func (r *K8sSecretsRegistrar) SetOverrideMode(probeType string, mode target_registrar.OverrideMode) error {
+	if err := r.setOverrideMode(probeType, mode); err != nil {
+		return fmt.Errorf("failed to set the override mode of probe %v to %v in secret %v/%v\n%v", probeType, mode,
+			r.namespace, OverridesSecretName, err)
+	}
+	return nil
+}
+
+func (r *K8sSecretsRegistrar) setOverrideMode(probeType string, mode target_registrar.OverrideMode) error {
+	key := EncodeDataKey(probeType)
+	value, err := json.Marshal(storedOverride{ProbeType: probeType, Mode: mode})
+	if err != nil {
+		return err
+	}
+	existingSecret, err := r.getOverridesSecret()
+	if err != nil {
+		return err
+	}
+	if existingSecret == nil {
+		if mode == target_registrar.OverrideAuto {
+			return nil
+		}
+		secret := &apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: OverridesSecretName, Namespace: r.namespace},
+			Data:       map[string][]byte{key: value},
+		}
+		secret.Labels = map[string]string{ManagedByLabel: ManagedByValue, SecretKindLabel: OverridesSecretKind}
+		_, err = r.client.Secrets(r.namespace).Create(secret)
+		if !errors.IsAlreadyExists(err) {
+			return err
+		}
+		// created by another writer in between; fall through to the update of the existing secret
+		if existingSecret, err = r.getOverridesSecret(); err != nil || existingSecret == nil {
+			return err
+		}
+	}
+	_, err = r.updateSecret(existingSecret, func(updatedSecret *apiv1.Secret) error {
+		// a probe type that is a valid key as is may be the very key another probe type is encoded into
+		var kept storedOverride
+		if data, found := updatedSecret.Data[key]; found && json.Unmarshal(data, &kept) == nil &&
+			kept.ProbeType != "" && kept.ProbeType != probeType {
+			if mode == target_registrar.OverrideAuto {
+				// no override mode kept for this probe type to remove
+				return nil
+			}
+			return fmt.Errorf("data key %v already keeps the override mode of probe %v", key, kept.ProbeType)
+		}
+		updatedSecret.Labels[SecretKindLabel] = OverridesSecretKind
+		if mode == target_registrar.OverrideAuto {
+			delete(updatedSecret.Data, key)
+			return nil
+		}
+		if updatedSecret.Data == nil {
+			updatedSecret.Data = map[string][]byte{}
+		}
+		updatedSecret.Data[key] = value
+		return nil
+	})
+	return err
+}
+
+// GetOverrideMode returns the override mode of the given probe type, or OverrideAuto if none is kept
+func (r *K8sSecretsRegistrar) GetOverrideMode(probeType string) (target_registrar.OverrideMode, error) {
+	overrides, err := r.ListOverrideModes()
+	if err != nil {
+		return "", err
+	}
+	if mode, found := overrides[probeType]; found {
+		return mode, nil
+	}
+	return target_registrar.OverrideAuto, nil
+}
+
+// ListOverrideModes returns the override modes kept in the overrides secret, keyed by the original probe type
+func (r *K8sSecretsRegistrar) ListOverrideModes() (map[string]target_registrar.OverrideMode, error) {
+	secret, err := r.getOverridesSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %v/%v\n%v", r.namespace, OverridesSecretName, err)
+	}
+	if secret == nil {
+		return map[string]target_registrar.OverrideMode{}, nil
+	}
+	overrides, err := overrideModesInSecret(secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the override modes of secret %v/%v\n%v", r.namespace,
+			OverridesSecretName, err)
+	}
+	return overrides, nil
+}
+
+// IsOverridesSecret returns true if the given secret is the overrides secret managed by the registrar
+func IsOverridesSecret(secret *apiv1.Secret) bool {
+	return isManagedSecret(secret) && secret.Labels[SecretKindLabel] == OverridesSecretKind
+}
+
+// OverrideModesForSecret returns the override modes kept in the given secret, keyed by the original probe type.  The
+// second return value is false if the secret is not the overrides secret.  An override mode that cannot be decoded
+// is left out.
+func OverrideModesForSecret(secret *apiv1.Secret) (map[string]target_registrar.OverrideMode, bool) {
+	if !IsOverridesSecret(secret) {
+		return nil, false
+	}
+	overrides, _ := overrideModesInSecret(secret)
+	return overrides, true
+}
+
+// overrideModesInSecret decodes the override modes kept in the given secret, keyed by the original probe type.  It
+// returns the override modes decoded so far along with the first error.
+func overrideModesInSecret(secret *apiv1.Secret) (map[string]target_registrar.OverrideMode, error) {
+	overrides := map[string]target_registrar.OverrideMode{}
+	for key, value := range secret.Data {
+		var stored storedOverride
+		if err := json.Unmarshal(value, &stored); err != nil {
+			return overrides, fmt.Errorf("failed to decode the override mode under key %v\n%v", key, err)
+		}
+		overrides[stored.ProbeType] = stored.Mode
+	}
+	return overrides, nil
+}
+
+// getOverridesSecret returns the overrides secret, or nil if not yet created.  A secret of the same name that the
+// registrar has not created is reported rather than used.
+func (r *K8sSecretsRegistrar) getOverridesSecret() (*apiv1.Secret, error) {
+	secret, err := r.client.Secrets(r.namespace).Get(OverridesSecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !isManagedSecret(secret) {
+		return nil, fmt.Errorf("secret %v/%v exists but is not managed by %v", r.namespace, OverridesSecretName,
+			ManagedByValue)
+	}
+	if _, isTargetSecret := ProbeTypeForSecret(secret); isTargetSecret {
+		return nil, fmt.Errorf("secret %v/%v keeps targets rather than the override modes", r.namespace,
+			OverridesSecretName)
+	}
+	return secret, nil
+}
+
+// Make sure K8sSecretsRegistrar implements the OverrideRegistrar interface, or a compilation error will result
+var _ target_registrar.OverrideRegistrar = (*K8sSecretsRegistrar)(nil)
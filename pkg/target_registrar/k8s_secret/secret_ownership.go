@@ -0,0 +1,170 @@
+package k8s_secret
+
+import (
+	"fmt"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ManagedByLabel is the label marking the secrets created or adopted by the registrar
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedByValue is the value of ManagedByLabel on the secrets created or adopted by the registrar
+	ManagedByValue = "probe-lifecycle-manager"
+	// ProbeTypeLabel ties the secrets of a probe type together
+	ProbeTypeLabel = "probe-lifecycle-manager.turbonomic.com/probe-type"
+	// ProbeTypeAnnotation records the probe type whose targets are kept in the secret
+	ProbeTypeAnnotation = "probe-lifecycle-manager.turbonomic.com/probe-type"
+	// AdoptedAnnotation marks the secrets the registrar has not created but adopted
+	AdoptedAnnotation = "probe-lifecycle-manager.turbonomic.com/adopted"
+)
+
+// AdoptionPolicy decides what the registrar does with a secret it has not created, found where it would keep targets,
+// i.e. a secret without the managed-by label bearing the name of a probe type
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyRefuse leaves such a secret alone: it is ignored on reads, and a write that would need it fails;
+	// this is the default
+	AdoptionPolicyRefuse AdoptionPolicy = "Refuse"
+	// AdoptionPolicyAdoptLegacy adopts such a secret as AdoptionPolicyAdopt does only if it bears exactly the name
+	// earlier versions of this library gave the secret of the probe type, i.e. the probe type itself, and refuses any
+	// other as AdoptionPolicyRefuse does; turn it on when upgrading from such a version, so that the targets registered
+	// before the upgrade are still found and their probes are not stopped
+	AdoptionPolicyAdoptLegacy AdoptionPolicy = "AdoptLegacy"
+	// AdoptionPolicyAdopt reads the targets in such a secret, and labels it as managed by the registrar upon the first
+	// write of a target of its probe type, keeping targets in it from then on; use it to take over the secrets created
+	// by earlier versions of this library
+	AdoptionPolicyAdopt AdoptionPolicy = "Adopt"
+	// AdoptionPolicyError fails every read and write that comes across such a secret
+	AdoptionPolicyError AdoptionPolicy = "Error"
+)
+
+// UnmanagedSecretError reports a secret the registrar would keep targets in, but has not created
+type UnmanagedSecretError struct {
+	Namespace string
+	Name      string
+	ProbeType string
+}
+
+func (e *UnmanagedSecretError) Error() string {
+	return fmt.Sprintf("secret %v/%v for probe type %v exists but is not managed by %v", e.Namespace, e.Name,
+		e.ProbeType, ManagedByValue)
+}
+
+// ManagedSecretsSelector returns the label selector of all the secrets managed by the registrar
+func ManagedSecretsSelector() string {
+	return labels.SelectorFromSet(labels.Set{ManagedByLabel: ManagedByValue}).String()
+}
+
+// managedSecretsListOptions returns the options to list the secrets managed by the registrar for the given probe type
+func managedSecretsListOptions(probeType string) metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: labels.SelectorFromSet(labels.Set{
+		ManagedByLabel: ManagedByValue,
+		ProbeTypeLabel: EncodeSecretName(probeType),
+	}).String()}
+}
+
+// isManagedSecret returns true if the secret is managed by the registrar, for any probe type
+func isManagedSecret(secret *apiv1.Secret) bool {
+	return secret.Labels[ManagedByLabel] == ManagedByValue
+}
+
+// setOwnership labels and annotates the secret as managed by the registrar for the given probe type.  The probe type
+// label holds the probe type encoded to be a valid label value, while the annotation holds the original.
+func setOwnership(secret *apiv1.Secret, probeType string) {
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	secret.Labels[ManagedByLabel] = ManagedByValue
+	secret.Labels[ProbeTypeLabel] = EncodeSecretName(probeType)
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[ProbeTypeAnnotation] = probeType
+}
+
+// WithAdoptionPolicy sets what the registrar does with the secrets it has not created; the default is to refuse them
+func (r *K8sSecretsRegistrar) WithAdoptionPolicy(policy AdoptionPolicy) *K8sSecretsRegistrar {
+	r.adoptionPolicy = policy
+	return r
+}
+
+// checkOwnership returns nil if the registrar may keep the targets of the given probe type in the secret: the secret
+// is managed for that probe type, or it is not managed at all and the adoption policy says to adopt it
+func (r *K8sSecretsRegistrar) checkOwnership(secret *apiv1.Secret, probeType string) error {
+	if isManagedSecret(secret) {
+		managedProbeType, _ := ProbeTypeForSecret(secret)
+		if managedProbeType == probeType {
+			return nil
+		}
+		return fmt.Errorf("secret %v/%v is managed for probe type %v, not %v", r.namespace, secret.Name,
+			managedProbeType, probeType)
+	}
+	if r.adoptionPolicy == AdoptionPolicyAdopt ||
+		r.adoptionPolicy == AdoptionPolicyAdoptLegacy && secret.Name == legacySecretName(probeType) {
+		return nil
+	}
+	return &UnmanagedSecretError{Namespace: r.namespace, Name: secret.Name, ProbeType: probeType}
+}
+
+// unmanagedSecretName returns the name of the unmanaged secret the adoption policy has the registrar look for among the
+// secrets of the given probe type.  The second return value is false if the policy is to refuse every such secret, or
+// if no secret could ever have been created under the legacy name of the probe type.
+func (r *K8sSecretsRegistrar) unmanagedSecretName(probeType string) (string, bool) {
+	switch r.adoptionPolicy {
+	case AdoptionPolicyRefuse:
+		return "", false
+	case AdoptionPolicyAdoptLegacy:
+		name := legacySecretName(probeType)
+		return name, len(validation.IsDNS1123Subdomain(name)) == 0
+	default:
+		return EncodeSecretName(probeType), true
+	}
+}
+
+// AdoptableProbeType returns the probe type whose targets the adoption policy has the registrar read from the given
+// secret it has not created, i.e. the probe type the secret is named after, so that a controller watching the secrets
+// also notices the unlabeled ones written by other components.  The second return value is false if the secret is
+// managed, does not keep targets, or is left alone by the adoption policy.
+func (r *K8sSecretsRegistrar) AdoptableProbeType(secret *apiv1.Secret) (string, bool) {
+	if isManagedSecret(secret) || secret.Type != "" && secret.Type != apiv1.SecretTypeOpaque ||
+		secret.Name == OverridesSecretName {
+		return "", false
+	}
+	probeType := secret.Name
+	name, lookedFor := r.unmanagedSecretName(probeType)
+	return probeType, lookedFor && name == secret.Name
+}
+
+// legacySecretName returns the name earlier versions of this library gave the secret keeping the targets of the probe
+// type, before the probe types were encoded and the secrets labeled
+func legacySecretName(probeType string) string {
+	return probeType
+}
+
+// adoptSecret labels the unmanaged secret as managed by the registrar for the given probe type
+func (r *K8sSecretsRegistrar) adoptSecret(secret *apiv1.Secret, probeType string) (*apiv1.Secret, error) {
+	adoptedSecret := secret.DeepCopy()
+	setOwnership(adoptedSecret, probeType)
+	adoptedSecret.Annotations[AdoptedAnnotation] = "true"
+	return r.patchSecret(secret, adoptedSecret)
+}
+
+// adoptSecrets adopts the unmanaged secrets among the given secrets of the probe type, replacing them in place with
+// their adopted versions.  Only the write paths adopt secrets, so that reading targets never modifies a secret.
+func (r *K8sSecretsRegistrar) adoptSecrets(secrets []apiv1.Secret, probeType string) error {
+	for i := range secrets {
+		if isManagedSecret(&secrets[i]) {
+			continue
+		}
+		adoptedSecret, err := r.adoptSecret(&secrets[i], probeType)
+		if err != nil {
+			return err
+		}
+		secrets[i] = *adoptedSecret
+	}
+	return nil
+}
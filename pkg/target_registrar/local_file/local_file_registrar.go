@@ -0,0 +1,321 @@
+package local_file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/internal/atomic_file"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// lockFileName is the name of the lock file guarding the directory against concurrent writers
+	lockFileName = ".lock"
+	// targetFileSuffix is the suffix of the files keeping the target info
+	targetFileSuffix = ".target"
+	// overridesFileName is the name of the file keeping the override modes of the probes
+	overridesFileName = ".overrides"
+	// maxEscapedNameLength is the longest escaped name used as is for a file or a directory; longer names are shortened
+	// and suffixed with a hash to stay within the file name limits of common file systems
+	maxEscapedNameLength = 128
+	// DefaultLockTimeout is the default time to wait for the lock held by another writer
+	DefaultLockTimeout = 10 * time.Second
+)
+
+// storedTarget is the content of a target file: the target encoded in its envelope, along with its probe type and id
+// since those may not be recoverable from the file and directory names
+type storedTarget struct {
+	ProbeType string `yaml:"probeType"`
+	Id        string `yaml:"id"`
+	Target    string `yaml:"target"`
+}
+
+// LocalFileRegistrar implements the Registrar interface keeping the target info in a directory on the local disk, for
+// the deployments without Kubernetes.  Each probe type has a sub-directory with one file per target.  The files are
+// only readable by the owner and are written atomically, and a lock file guards the directory against concurrent
+// writers, including those in other processes.
+type LocalFileRegistrar struct {
+	dir         string
+	lockTimeout time.Duration
+}
+
+// NewLocalFileRegistrar constructs a LocalFileRegistrar keeping the target info in the given directory, creating the
+// directory if not already created
+func NewLocalFileRegistrar(dir string) (*LocalFileRegistrar, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the target directory %v\n%v", dir, err)
+	}
+	return &LocalFileRegistrar{
+		dir:         dir,
+		lockTimeout: DefaultLockTimeout,
+	}, nil
+}
+
+// WithLockTimeout sets the time to wait for the lock held by another writer
+func (r *LocalFileRegistrar) WithLockTimeout(lockTimeout time.Duration) *LocalFileRegistrar {
+	r.lockTimeout = lockTimeout
+	return r
+}
+
+// RegisterTarget registers the target by writing its info to a file, unless the file keeps the same info already
+func (r *LocalFileRegistrar) RegisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	var result target_registrar.RegistrationResult
+	if err := target_registrar.CheckPathKey(target.GetProbeType(), target.GetId()); err != nil {
+		return result, fmt.Errorf("failed to store target %v in directory %v\n%v",
+			target_registrar.SafeString(target), r.dir, err)
+	}
+	data, err := target_registrar.EncodeTarget(target)
+	if err != nil {
+		return result, fmt.Errorf("failed to encode target %v\n%v", target_registrar.SafeString(target), err)
+	}
+	content, err := yaml.Marshal(&storedTarget{ProbeType: target.GetProbeType(), Id: target.GetId(), Target: string(data)})
+	if err != nil {
+		return result, fmt.Errorf("failed to encode target %v\n%v", target_registrar.SafeString(target), err)
+	}
+	err = r.withLock(func() error {
+		files, err := r.targetFiles(target.GetProbeType())
+		if err != nil {
+			return err
+		}
+		targetFile := r.targetFile(target.GetProbeType(), target.GetId())
+		previous, err := readTargetFile(targetFile)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var previousData []byte
+		if previous != nil {
+			previousData = []byte(previous.Target)
+		}
+		result = target_registrar.NewRegisterResult(previousData, previous != nil, data, len(files))
+		if result.Change == target_registrar.RegistrationUnchanged {
+			return nil
+		}
+		if err := os.MkdirAll(r.probeDir(target.GetProbeType()), 0700); err != nil {
+			return err
+		}
+		return atomic_file.WriteFile(targetFile, content)
+	})
+	if err != nil {
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to store target %v in directory %v\n%v",
+			target_registrar.SafeString(target), r.dir, err)
+	}
+	return result, nil
+}
+
+// UnregisterTarget unregisters the target by removing its file, and the directory of the probe type along with its
+// last target if nothing else is left in it
+func (r *LocalFileRegistrar) UnregisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	var result target_registrar.RegistrationResult
+	if err := target_registrar.CheckPathKey(target.GetProbeType(), target.GetId()); err != nil {
+		return result, fmt.Errorf("failed to remove target %v from directory %v\n%v",
+			target_registrar.SafeString(target), r.dir, err)
+	}
+	err := r.withLock(func() error {
+		files, err := r.targetFiles(target.GetProbeType())
+		if err != nil {
+			return err
+		}
+		err = os.Remove(r.targetFile(target.GetProbeType(), target.GetId()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		result = target_registrar.NewUnregisterResult(err == nil, len(files))
+		if result.NewCount == 0 {
+			// Clean up the directory of the probe type, so that it is no longer listed; a directory that is not empty
+			// is left alone, as an empty one is not listed either
+			_ = os.Remove(r.probeDir(target.GetProbeType()))
+		}
+		return nil
+	})
+	if err != nil {
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to remove target %v from directory %v\n%v",
+			target_registrar.SafeString(target), r.dir, err)
+	}
+	return result, nil
+}
+
+// ListProbeTypes returns the probe types having a directory with at least one target file, sorted
+func (r *LocalFileRegistrar) ListProbeTypes() ([]string, error) {
+	entries, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the probe types in directory %v\n%v", r.dir, err)
+	}
+	var probeTypes []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		files, err := listTargetFiles(filepath.Join(r.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the probe types in directory %v\n%v", r.dir, err)
+		}
+		if len(files) == 0 {
+			continue
+		}
+		stored, err := readTargetFile(files[0])
+		if os.IsNotExist(err) {
+			// the last target of the probe type removed since listed
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the probe types in directory %v\n%v", r.dir, err)
+		}
+		probeTypes = append(probeTypes, stored.ProbeType)
+	}
+	sort.Strings(probeTypes)
+	return probeTypes, nil
+}
+
+// CountTargets returns the number of target files of the given probe type
+func (r *LocalFileRegistrar) CountTargets(probeType string) (int, error) {
+	files, err := r.targetFiles(probeType)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the targets of probe type %v in directory %v\n%v", probeType, r.dir, err)
+	}
+	return len(files), nil
+}
+
+// ListTargets returns the targets of the given probe type, sorted by the target id
+func (r *LocalFileRegistrar) ListTargets(probeType string) ([]target_registrar.Target, error) {
+	files, err := r.targetFiles(probeType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the targets of probe type %v in directory %v\n%v", probeType, r.dir, err)
+	}
+	targets := make([]target_registrar.Target, 0, len(files))
+	for _, file := range files {
+		stored, err := readTargetFile(file)
+		if os.IsNotExist(err) {
+			// removed since listed
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the targets of probe type %v in directory %v\n%v", probeType, r.dir, err)
+		}
+		target, err := target_registrar.DecodeTarget(stored.ProbeType, stored.Id, []byte(stored.Target))
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].GetId() < targets[j].GetId()
+	})
+	return targets, nil
+}
+
+// GetTarget returns the target of the given probe type and id, or nil if not found
+func (r *LocalFileRegistrar) GetTarget(probeType string, id string) (target_registrar.Target, error) {
+	if err := target_registrar.CheckPathKey(probeType, id); err != nil {
+		return nil, fmt.Errorf("failed to read target %v/%v in directory %v\n%v", probeType, id, r.dir, err)
+	}
+	stored, err := readTargetFile(r.targetFile(probeType, id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target %v/%v in directory %v\n%v", probeType, id, r.dir, err)
+	}
+	return target_registrar.DecodeTarget(stored.ProbeType, stored.Id, []byte(stored.Target))
+}
+
+// probeDir returns the path of the directory keeping the target files of the given probe type
+func (r *LocalFileRegistrar) probeDir(probeType string) string {
+	return filepath.Join(r.dir, escapeName(probeType))
+}
+
+// targetFile returns the path of the file keeping the info of the given target
+func (r *LocalFileRegistrar) targetFile(probeType string, id string) string {
+	return filepath.Join(r.probeDir(probeType), escapeName(id)+targetFileSuffix)
+}
+
+// targetFiles returns the paths of the target files of the given probe type
+func (r *LocalFileRegistrar) targetFiles(probeType string) ([]string, error) {
+	return listTargetFiles(r.probeDir(probeType))
+}
+
+// withLock runs the given function while holding an exclusive flock(2) on the lock file of the directory.  The lock
+// file is never deleted, so that every writer, in this process or another, locks the same file.  The kernel releases
+// the lock of a crashed writer along with its file descriptors, so that no lock is ever left behind.
+func (r *LocalFileRegistrar) withLock(fn func() error) error {
+	lockFile := filepath.Join(r.dir, lockFileName)
+	file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the lock file %v\n%v", lockFile, err)
+	}
+	defer file.Close()
+	fd := int(file.Fd())
+	deadline := time.Now().Add(r.lockTimeout)
+	backoff := time.Millisecond
+	for {
+		err := syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			return fmt.Errorf("failed to lock the lock file %v\n%v", lockFile, err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %v waiting for the lock file %v held by another writer", r.lockTimeout,
+				lockFile)
+		}
+		time.Sleep(backoff)
+		if backoff < 100*time.Millisecond {
+			backoff *= 2
+		}
+	}
+	defer syscall.Flock(fd, syscall.LOCK_UN)
+	return fn()
+}
+
+// listTargetFiles returns the paths of the target files in the given directory, or none if the directory is missing
+func listTargetFiles(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && strings.HasSuffix(entry.Name(), targetFileSuffix) {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// readTargetFile reads and decodes a target file
+func readTargetFile(file string) (*storedTarget, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var stored storedTarget
+	if err := yaml.Unmarshal(content, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode the target file %v\n%v", file, err)
+	}
+	return &stored, nil
+}
+
+// escapeName turns a probe type or a target id into a safe file name with target_registrar.EscapeName.  Long names are
+// shortened and suffixed with a hash of the original.
+func escapeName(name string) string {
+	escaped := target_registrar.EscapeName(name)
+	if len(escaped) <= maxEscapedNameLength {
+		return escaped
+	}
+	hash := sha256.Sum256([]byte(name))
+	return escaped[:maxEscapedNameLength-17] + "-" + hex.EncodeToString(hash[:8])
+}
+
+// Make sure LocalFileRegistrar implements the Registrar interface, or a compilation error will result
+var _ target_registrar.Registrar = (*LocalFileRegistrar)(nil)
@@ -0,0 +1,261 @@
+package local_file_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/local_file"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func newTarget(probeType string, id string) *target_registrar.UserPassTarget {
+	return &target_registrar.UserPassTarget{Id: id, Probetype: probeType, Username: "user-" + id, Password: "pass-" + id}
+}
+
+var _ = Describe("Local file registrar", func() {
+	var dir string
+	var registrar *local_file.LocalFileRegistrar
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "targets-")
+		Expect(err).NotTo(HaveOccurred())
+		registrar, err = local_file.NewLocalFileRegistrar(filepath.Join(dir, "store"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	DescribeTable("registering and unregistering targets",
+		func(registered []string, toUnregister []string, expectedLastTarget bool, expectedCount int) {
+			for _, id := range registered {
+				result, err := registrar.RegisterTarget(newTarget("vcenter", id))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.NewCount).To(BeNumerically(">", 0))
+			}
+			var result target_registrar.RegistrationResult
+			for _, id := range toUnregister {
+				var err error
+				result, err = registrar.UnregisterTarget(newTarget("vcenter", id))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(result.IsLastTarget()).To(Equal(expectedLastTarget))
+			count, err := registrar.CountTargets("vcenter")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(expectedCount))
+		},
+		Entry("unregister the only target", []string{"t1"}, []string{"t1"}, true, 0),
+		Entry("unregister one of two targets", []string{"t1", "t2"}, []string{"t1"}, false, 1),
+		Entry("unregister both targets", []string{"t1", "t2"}, []string{"t2", "t1"}, true, 0),
+		Entry("unregister a target never registered", []string{"t1"}, []string{"t2"}, false, 1),
+		Entry("unregister from a probe type never registered", []string{}, []string{"t1"}, false, 0),
+		Entry("register the same target twice", []string{"t1", "t1"}, []string{"t1"}, true, 0),
+	)
+
+	It("reports whether a target has been created, updated or left unchanged", func() {
+		result, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationCreated, PreviousCount: 0, NewCount: 1}))
+		Expect(result.IsFirstTarget()).To(BeTrue())
+
+		result, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationUnchanged, PreviousCount: 1, NewCount: 1}))
+
+		updatedTarget := newTarget("vcenter", "t1")
+		updatedTarget.Password = "new-password"
+		result, err = registrar.RegisterTarget(updatedTarget)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationUpdated, PreviousCount: 1, NewCount: 1}))
+		Expect(result.IsFirstTarget()).To(BeFalse())
+
+		result, err = registrar.UnregisterTarget(updatedTarget)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationDeleted, PreviousCount: 1, NewCount: 0}))
+		Expect(result.IsLastTarget()).To(BeTrue())
+
+		result, err = registrar.UnregisterTarget(updatedTarget)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationUnchanged, PreviousCount: 0, NewCount: 0}))
+	})
+
+	It("lists and gets the targets, including the ones with ids unsafe as file names", func() {
+		ids := []string{"../escape", ".hidden", "https://10.10.10.10:443/sdk", strings.Repeat("long-id/", 40)}
+		for _, id := range ids {
+			_, err := registrar.RegisterTarget(newTarget("vcenter", id))
+			Expect(err).NotTo(HaveOccurred())
+		}
+		_, err := registrar.RegisterTarget(newTarget("aws/ec2", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+
+		probeTypes, err := registrar.ListProbeTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(probeTypes).To(Equal([]string{"aws/ec2", "vcenter"}))
+
+		targets, err := registrar.ListTargets("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targets).To(HaveLen(len(ids)))
+		for _, id := range ids {
+			target, err := registrar.GetTarget("vcenter", id)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(target).To(Equal(*newTarget("vcenter", id)))
+		}
+		target, err := registrar.GetTarget("vcenter", "missing")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(BeNil())
+
+		_, err = os.Stat(filepath.Join(dir, "escape"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+
+	It("rejects a target without a probe type or an id rather than touching the registrar directory", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "Moid1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(registrar.SetOverrideMode("pure", target_registrar.OverrideForceEnabled)).To(Succeed())
+
+		_, err = registrar.UnregisterTarget(&target_registrar.UserPassTarget{Id: "Moid1"})
+		Expect(err).To(HaveOccurred())
+		_, err = registrar.UnregisterTarget(&target_registrar.UserPassTarget{Probetype: "vcenter"})
+		Expect(err).To(HaveOccurred())
+		_, err = registrar.RegisterTarget(&target_registrar.UserPassTarget{Id: "Moid1"})
+		Expect(err).To(HaveOccurred())
+		_, err = registrar.GetTarget("", "Moid1")
+		Expect(err).To(HaveOccurred())
+
+		Expect(registrar.GetTarget("vcenter", "Moid1")).To(Equal(*newTarget("vcenter", "Moid1")))
+		Expect(registrar.GetOverrideMode("pure")).To(Equal(target_registrar.OverrideForceEnabled))
+	})
+
+	It("leaves the directory of a probe type alone if anything but targets is left in it", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "Moid1"))
+		Expect(err).NotTo(HaveOccurred())
+		strayFile := filepath.Join(dir, "store", "vcenter", "notes.txt")
+		Expect(ioutil.WriteFile(strayFile, []byte("keep me"), 0600)).To(Succeed())
+
+		result, err := registrar.UnregisterTarget(newTarget("vcenter", "Moid1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.IsLastTarget()).To(BeTrue())
+		Expect(strayFile).To(BeAnExistingFile())
+		Expect(registrar.ListProbeTypes()).To(BeEmpty())
+	})
+
+	It("keeps the target files readable by the owner only", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		files, err := filepath.Glob(filepath.Join(dir, "store", "vcenter", "*"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		info, err := os.Stat(files[0])
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+	})
+
+	It("serializes concurrent writers sharing the directory", func() {
+		other, err := local_file.NewLocalFileRegistrar(filepath.Join(dir, "store"))
+		Expect(err).NotTo(HaveOccurred())
+		var wg sync.WaitGroup
+		for i, r := range []*local_file.LocalFileRegistrar{registrar, other} {
+			wg.Add(1)
+			go func(i int, r *local_file.LocalFileRegistrar) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					_, err := r.RegisterTarget(newTarget("vcenter", fmt.Sprintf("t%d-%d", i, j)))
+					Expect(err).NotTo(HaveOccurred())
+				}
+			}(i, r)
+		}
+		wg.Wait()
+		count, err := registrar.CountTargets("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(Equal(40))
+	})
+
+	It("times out waiting for a lock held by another writer, and takes it once released", func() {
+		lockFile := filepath.Join(dir, "store", ".lock")
+		file, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0600)
+		Expect(err).NotTo(HaveOccurred())
+		defer file.Close()
+		Expect(syscall.Flock(int(file.Fd()), syscall.LOCK_EX)).To(Succeed())
+		registrar.WithLockTimeout(50 * time.Millisecond)
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("timed out"))
+		Expect(err.Error()).NotTo(ContainSubstring("pass-t1"))
+
+		Expect(syscall.Flock(int(file.Fd()), syscall.LOCK_UN)).To(Succeed())
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		// the lock file is never deleted, so that every writer locks the same file
+		Expect(lockFile).To(BeAnExistingFile())
+	})
+
+	It("takes the lock in spite of a lock file left behind by a crashed writer", func() {
+		lockFile := filepath.Join(dir, "store", ".lock")
+		Expect(ioutil.WriteFile(lockFile, []byte("1 stale\n"), 0600)).To(Succeed())
+		registrar.WithLockTimeout(50 * time.Millisecond)
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("keeps concurrent writers from holding the lock at the same time", func() {
+		// Each writer registers a target of its own; holding the lock one at a time, they count 1 to 20 targets
+		const writers = 20
+		counts := make(chan int, writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			other, err := local_file.NewLocalFileRegistrar(filepath.Join(dir, "store"))
+			Expect(err).NotTo(HaveOccurred())
+			wg.Add(1)
+			go func(i int, r *local_file.LocalFileRegistrar) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				result, err := r.RegisterTarget(newTarget("vcenter", fmt.Sprintf("t%d", i)))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.NewCount).To(Equal(result.PreviousCount + 1))
+				counts <- result.NewCount
+			}(i, other)
+		}
+		wg.Wait()
+		close(counts)
+		seen := map[int]bool{}
+		for count := range counts {
+			Expect(seen).NotTo(HaveKey(count))
+			seen[count] = true
+		}
+		Expect(seen).To(HaveLen(writers))
+		Expect(registrar.CountTargets("vcenter")).To(Equal(writers))
+	})
+
+	It("keeps the override modes next to the targets without mistaking them for a probe type", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(registrar.SetOverrideMode("vcenter", target_registrar.OverrideForceDisabled)).To(Succeed())
+		Expect(registrar.SetOverrideMode("pure", target_registrar.OverrideForceEnabled)).To(Succeed())
+		Expect(registrar.ListProbeTypes()).To(Equal([]string{"vcenter"}))
+
+		reopened, err := local_file.NewLocalFileRegistrar(filepath.Join(dir, "store"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reopened.GetOverrideMode("vcenter")).To(Equal(target_registrar.OverrideForceDisabled))
+		Expect(reopened.GetOverrideMode("aws")).To(Equal(target_registrar.OverrideAuto))
+		Expect(reopened.SetOverrideMode("vcenter", target_registrar.OverrideAuto)).To(Succeed())
+		Expect(reopened.ListOverrideModes()).To(Equal(map[string]target_registrar.OverrideMode{
+			"pure": target_registrar.OverrideForceEnabled,
+		}))
+	})
+})
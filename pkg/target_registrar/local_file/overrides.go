@@ -0,0 +1,77 @@
+package local_file
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/internal/atomic_file"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SetOverrideMode keeps the override mode of the given probe type in the overrides file of the directory, which maps
+// the probe types to their modes
+func (r *LocalFileRegistrar) SetOverrideMode(probeType string, mode target_registrar.OverrideMode) error {
+	err := r.withLock(func() error {
+		overrides, err := r.readOverrides()
+		if err != nil {
+			return err
+		}
+		if mode == target_registrar.OverrideAuto {
+			if _, found := overrides[probeType]; !found {
+				return nil
+			}
+			delete(overrides, probeType)
+		} else {
+			overrides[probeType] = mode
+		}
+		content, err := yaml.Marshal(overrides)
+		if err != nil {
+			return err
+		}
+		return atomic_file.WriteFile(filepath.Join(r.dir, overridesFileName), content)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set the override mode of probe %v to %v in directory %v\n%v", probeType, mode,
+			r.dir, err)
+	}
+	return nil
+}
+
+// GetOverrideMode returns the override mode of the given probe type, or OverrideAuto if none is kept
+func (r *LocalFileRegistrar) GetOverrideMode(probeType string) (target_registrar.OverrideMode, error) {
+	overrides, err := r.readOverrides()
+	if err != nil {
+		return "", err
+	}
+	if mode, found := overrides[probeType]; found {
+		return mode, nil
+	}
+	return target_registrar.OverrideAuto, nil
+}
+
+// ListOverrideModes returns the override modes kept in the overrides file of the directory
+func (r *LocalFileRegistrar) ListOverrideModes() (map[string]target_registrar.OverrideMode, error) {
+	return r.readOverrides()
+}
+
+// readOverrides reads the overrides file of the directory, or returns no override if the file does not exist
+func (r *LocalFileRegistrar) readOverrides() (map[string]target_registrar.OverrideMode, error) {
+	overrides := map[string]target_registrar.OverrideMode{}
+	file := filepath.Join(r.dir, overridesFileName)
+	content, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return overrides, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the overrides file %v\n%v", file, err)
+	}
+	if err := yaml.Unmarshal(content, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to decode the overrides file %v\n%v", file, err)
+	}
+	return overrides, nil
+}
+
+// Make sure LocalFileRegistrar implements the OverrideRegistrar interface, or a compilation error will result
+var _ target_registrar.OverrideRegistrar = (*LocalFileRegistrar)(nil)
@@ -0,0 +1,78 @@
+package target_registrar
+
+import (
+	"gopkg.in/yaml.v2"
+	"net/url"
+)
+
+// OAuth2ClientTarget describes a structure for targets authenticating with the OAuth2 client credentials grant
+type OAuth2ClientTarget struct {
+	Id           string
+	Probetype    string
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+func (t OAuth2ClientTarget) GetId() string {
+	return t.Id
+}
+
+func (t OAuth2ClientTarget) GetProbeType() string {
+	return t.Probetype
+}
+
+func (t OAuth2ClientTarget) Bytes() ([]byte, error) {
+	return yaml.Marshal(&t)
+}
+
+// Validate checks that the client credentials are set and that the token URL is an absolute http(s) URL
+func (t OAuth2ClientTarget) Validate() error {
+	errs := requiredFields{{"TokenURL", t.TokenURL}, {"ClientID", t.ClientID}, {"ClientSecret", t.ClientSecret}}.validate()
+	if t.TokenURL != "" {
+		if tokenURL, err := url.Parse(t.TokenURL); err != nil || !tokenURL.IsAbs() ||
+			(tokenURL.Scheme != "https" && tokenURL.Scheme != "http") {
+			errs = append(errs, fieldError("TokenURL", "not an absolute http(s) URL"))
+		}
+	}
+	return aggregate(errs)
+}
+
+// SafeString describes the target by its probe type and id, leaving out the client secret
+func (t OAuth2ClientTarget) SafeString() string {
+	return safeString(t.Probetype, t.Id)
+}
+
+// String redacts the target when formatted with %v or %s
+func (t OAuth2ClientTarget) String() string {
+	return t.SafeString()
+}
+
+// GoString redacts the target when formatted with %#v
+func (t OAuth2ClientTarget) GoString() string {
+	return t.SafeString()
+}
+
+// Make sure OAuth2ClientTarget implements the Target interface, or a compilation error will result
+var _ Target = (*OAuth2ClientTarget)(nil)
+var _ SafeStringer = (*OAuth2ClientTarget)(nil)
+var _ Validator = (*OAuth2ClientTarget)(nil)
+
+// OAuth2ClientKind is the kind under which OAuth2ClientTarget is registered with the default codec registry
+const OAuth2ClientKind = "OAuth2Client"
+
+func init() {
+	if err := RegisterTargetKind(OAuth2ClientKind, "v1", OAuth2ClientTarget{}, func(bytes []byte) (Target, error) {
+		var target OAuth2ClientTarget
+		err := OAuth2ClientTargetFromBytes(bytes, &target)
+		return target, err
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// Unmarshal the input byte array into a OAuth2ClientTarget object
+func OAuth2ClientTargetFromBytes(bytes []byte, target *OAuth2ClientTarget) error {
+	return yaml.Unmarshal(bytes, target)
+}
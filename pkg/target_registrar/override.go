@@ -0,0 +1,35 @@
+package target_registrar
+
+import "fmt"
+
+// OverrideMode tells whether a probe follows its targets, or is pinned on or off by an administrator regardless of them
+type OverrideMode string
+
+const (
+	// OverrideAuto lets the probe run if and only if it has targets; it is the mode of every probe without an override
+	OverrideAuto OverrideMode = "Auto"
+	// OverrideForceEnabled keeps the probe running even without targets, e.g. to pre-warm it during an upgrade
+	OverrideForceEnabled OverrideMode = "ForceEnabled"
+	// OverrideForceDisabled keeps the probe off even with targets, e.g. while the API of a vendor is misbehaving
+	OverrideForceDisabled OverrideMode = "ForceDisabled"
+)
+
+// ValidateOverrideMode returns an error if the given mode is not one of the override modes
+func ValidateOverrideMode(mode OverrideMode) error {
+	switch mode {
+	case OverrideAuto, OverrideForceEnabled, OverrideForceDisabled:
+		return nil
+	}
+	return fmt.Errorf("unknown override mode %q; expected one of %v, %v or %v", mode, OverrideAuto,
+		OverrideForceEnabled, OverrideForceDisabled)
+}
+
+// OverrideRegistrar is implemented by registrars that can keep the override modes of the probes next to the targets
+type OverrideRegistrar interface {
+	// SetOverrideMode keeps the override mode of the given probe type; OverrideAuto removes any override kept
+	SetOverrideMode(probeType string, mode OverrideMode) error
+	// GetOverrideMode returns the override mode of the given probe type, or OverrideAuto if no override is kept
+	GetOverrideMode(probeType string) (OverrideMode, error)
+	// ListOverrideModes returns the override modes kept, other than OverrideAuto, keyed by the probe type
+	ListOverrideModes() (map[string]OverrideMode, error)
+}
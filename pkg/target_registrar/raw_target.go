@@ -0,0 +1,48 @@
+package target_registrar
+
+import "fmt"
+
+// RawTarget describes a target by the raw bytes it has been registered with, e.g. when the target info is read back
+// from a registrar without a decoder registered for its kind
+type RawTarget struct {
+	Id        string
+	Probetype string
+	// Kind and Version are those recorded in the envelope of the stored target, if any
+	Kind    string
+	Version string
+	Data    []byte
+}
+
+func (t RawTarget) GetId() string {
+	return t.Id
+}
+
+func (t RawTarget) GetProbeType() string {
+	return t.Probetype
+}
+
+func (t RawTarget) Bytes() ([]byte, error) {
+	return t.Data, nil
+}
+
+// SafeString describes the target by its probe type, id and kind, leaving out the raw bytes that may carry credentials
+func (t RawTarget) SafeString() string {
+	if t.Kind == "" {
+		return safeString(t.Probetype, t.Id)
+	}
+	return fmt.Sprintf("%v (%v/%v)", safeString(t.Probetype, t.Id), t.Kind, t.Version)
+}
+
+// String redacts the target when formatted with %v or %s
+func (t RawTarget) String() string {
+	return t.SafeString()
+}
+
+// GoString redacts the target when formatted with %#v
+func (t RawTarget) GoString() string {
+	return t.SafeString()
+}
+
+// Make sure RawTarget implements the Target interface, or a compilation error will result
+var _ Target = (*RawTarget)(nil)
+var _ SafeStringer = (*RawTarget)(nil)
@@ -0,0 +1,34 @@
+package target_registrar_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+)
+
+var _ = Describe("Test target redaction", func() {
+	DescribeTable("test formatting a target never reveals its credentials",
+		func(target target_registrar.Target, secret string, expectedSafeString string) {
+			Expect(target_registrar.SafeString(target)).To(Equal(expectedSafeString))
+			for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+				Expect(formatTarget(format, target)).NotTo(ContainSubstring(secret))
+			}
+		},
+		Entry("a username/password target",
+			target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter", Username: "user1", Password: "pass1"},
+			"pass1", "vcenter/Moid1"),
+		Entry("a raw target",
+			target_registrar.RawTarget{Id: "Moid1", Probetype: "vcenter", Kind: "UserPass", Version: "v1", Data: []byte("password: pass1")},
+			"pass1", "vcenter/Moid1 (UserPass/v1)"),
+		Entry("a target without a safe string of its own",
+			tokenTargetV2{Id: "Moid1", Probetype: "pure", Token: "token1"},
+			"never-formatted", "pure/Moid1"),
+	)
+})
+
+// formatTarget formats the target with the given verb
+func formatTarget(format string, target target_registrar.Target) string {
+	return fmt.Sprintf(format, target)
+}
@@ -2,10 +2,18 @@ package target_registrar
 
 // Registrar declares the interface to register and unregister target info
 type Registrar interface {
-	// RegisterTarget registers the target, usually keeping the target info somewhere.  It returns true if there is at
-	// least one target for the probe, which should always be true unless there is an error.
-	RegisterTarget(target Target) (bool, error)
-	// UnregisterTarget unregisters the target.  It returns true if there is no more target for this probe, or false
-	// otherwise.
-	UnregisterTarget(target Target) (bool, error)
-}
\ No newline at end of file
+	// RegisterTarget registers the target, usually keeping the target info somewhere.  It returns whether the target
+	// has been created, updated or left unchanged, along with the number of targets of the probe type before and after.
+	RegisterTarget(target Target) (RegistrationResult, error)
+	// UnregisterTarget unregisters the target.  It returns whether the target has been deleted or was not registered,
+	// along with the number of targets of the probe type before and after.
+	UnregisterTarget(target Target) (RegistrationResult, error)
+	// ListProbeTypes returns the probe types that this registrar currently keeps target info for
+	ListProbeTypes() ([]string, error)
+	// CountTargets returns the number of targets registered for the given probe type
+	CountTargets(probeType string) (int, error)
+	// ListTargets returns all the targets registered for the given probe type, sorted by the target id
+	ListTargets(probeType string) ([]Target, error)
+	// GetTarget returns the target of the given probe type and id, or nil if no such target is registered
+	GetTarget(probeType string, id string) (Target, error)
+}
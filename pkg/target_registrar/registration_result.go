@@ -0,0 +1,58 @@
+package target_registrar
+
+import "bytes"
+
+// RegistrationChange tells what a registration or an unregistration has done to the target info kept by a registrar
+type RegistrationChange string
+
+const (
+	// RegistrationCreated means the target was not registered before
+	RegistrationCreated RegistrationChange = "Created"
+	// RegistrationUpdated means the target was registered before with different target info
+	RegistrationUpdated RegistrationChange = "Updated"
+	// RegistrationDeleted means the target was registered before and has been removed
+	RegistrationDeleted RegistrationChange = "Deleted"
+	// RegistrationUnchanged means the target info kept by the registrar is the same as before: a target registered
+	// again with the same info, or a target unregistered that was not registered
+	RegistrationUnchanged RegistrationChange = "Unchanged"
+)
+
+// RegistrationResult reports what a registration or an unregistration has done, along with the number of targets of
+// the probe type before and after it, from which the probe lifecycle transitions are worked out
+type RegistrationResult struct {
+	Change        RegistrationChange
+	PreviousCount int
+	NewCount      int
+}
+
+// IsFirstTarget returns true if the probe type had no target before and has one now, i.e. the probe should start
+func (r RegistrationResult) IsFirstTarget() bool {
+	return r.PreviousCount == 0 && r.NewCount > 0
+}
+
+// IsLastTarget returns true if the probe type had targets before and has none now, i.e. the probe should stop
+func (r RegistrationResult) IsLastTarget() bool {
+	return r.PreviousCount > 0 && r.NewCount == 0
+}
+
+// NewRegisterResult works out the result of registering a target, given the encoded target info kept before if the
+// target existed, the encoded target info to keep now, and the number of targets of the probe type before
+func NewRegisterResult(previousData []byte, existed bool, newData []byte, previousCount int) RegistrationResult {
+	switch {
+	case !existed:
+		return RegistrationResult{Change: RegistrationCreated, PreviousCount: previousCount, NewCount: previousCount + 1}
+	case bytes.Equal(previousData, newData):
+		return RegistrationResult{Change: RegistrationUnchanged, PreviousCount: previousCount, NewCount: previousCount}
+	default:
+		return RegistrationResult{Change: RegistrationUpdated, PreviousCount: previousCount, NewCount: previousCount}
+	}
+}
+
+// NewUnregisterResult works out the result of unregistering a target, given whether the target existed and the number
+// of targets of the probe type before
+func NewUnregisterResult(existed bool, previousCount int) RegistrationResult {
+	if !existed {
+		return RegistrationResult{Change: RegistrationUnchanged, PreviousCount: previousCount, NewCount: previousCount}
+	}
+	return RegistrationResult{Change: RegistrationDeleted, PreviousCount: previousCount, NewCount: previousCount - 1}
+}
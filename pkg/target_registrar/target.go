@@ -8,4 +8,23 @@ type Target interface {
 	GetId() string
 	// Bytes returns a byte array encoding this target's info
 	Bytes() ([]byte, error)
-}
\ No newline at end of file
+}
+
+// SafeStringer is the redaction contract for targets: a target implementing it describes itself without revealing any
+// credentials.  Targets not implementing it are described by their probe type and id only.
+type SafeStringer interface {
+	// SafeString returns a description of the target that is safe to appear in errors and logs
+	SafeString() string
+}
+
+// SafeString returns a description of the target that is safe to appear in errors and logs.  All errors and logs
+// about a target should describe it through this function rather than formatting the target itself.
+func SafeString(target Target) string {
+	if target == nil {
+		return "<nil>"
+	}
+	if safeStringer, ok := target.(SafeStringer); ok {
+		return safeStringer.SafeString()
+	}
+	return safeString(target.GetProbeType(), target.GetId())
+}
@@ -0,0 +1,65 @@
+package target_registrar
+
+import "gopkg.in/yaml.v2"
+
+// TokenTarget describes a structure for targets authenticating with an API token
+type TokenTarget struct {
+	Id        string
+	Probetype string
+	Token     string
+}
+
+func (t TokenTarget) GetId() string {
+	return t.Id
+}
+
+func (t TokenTarget) GetProbeType() string {
+	return t.Probetype
+}
+
+func (t TokenTarget) Bytes() ([]byte, error) {
+	return yaml.Marshal(&t)
+}
+
+// Validate checks that the token is set
+func (t TokenTarget) Validate() error {
+	return aggregate(requiredFields{{"Token", t.Token}}.validate())
+}
+
+// SafeString describes the target by its probe type and id, leaving out the token
+func (t TokenTarget) SafeString() string {
+	return safeString(t.Probetype, t.Id)
+}
+
+// String redacts the target when formatted with %v or %s
+func (t TokenTarget) String() string {
+	return t.SafeString()
+}
+
+// GoString redacts the target when formatted with %#v
+func (t TokenTarget) GoString() string {
+	return t.SafeString()
+}
+
+// Make sure TokenTarget implements the Target interface, or a compilation error will result
+var _ Target = (*TokenTarget)(nil)
+var _ SafeStringer = (*TokenTarget)(nil)
+var _ Validator = (*TokenTarget)(nil)
+
+// TokenKind is the kind under which TokenTarget is registered with the default codec registry
+const TokenKind = "Token"
+
+func init() {
+	if err := RegisterTargetKind(TokenKind, "v1", TokenTarget{}, func(bytes []byte) (Target, error) {
+		var target TokenTarget
+		err := TokenTargetFromBytes(bytes, &target)
+		return target, err
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// Unmarshal the input byte array into a TokenTarget object
+func TokenTargetFromBytes(bytes []byte, target *TokenTarget) error {
+	return yaml.Unmarshal(bytes, target)
+}
@@ -22,8 +22,43 @@ func (t UserPassTarget) Bytes() ([]byte, error) {
 	return yaml.Marshal(&t)
 }
 
+// Validate checks that the username and the password are both set
+func (t UserPassTarget) Validate() error {
+	return aggregate(requiredFields{{"Username", t.Username}, {"Password", t.Password}}.validate())
+}
+
+// SafeString describes the target by its probe type and id, leaving out the credentials
+func (t UserPassTarget) SafeString() string {
+	return safeString(t.Probetype, t.Id)
+}
+
+// String redacts the target when formatted with %v or %s, so that the password never ends up in errors and logs
+func (t UserPassTarget) String() string {
+	return t.SafeString()
+}
+
+// GoString redacts the target when formatted with %#v
+func (t UserPassTarget) GoString() string {
+	return t.SafeString()
+}
+
 // Make sure UserPassTarget implements the Target interface, or a compilation error will result
 var _ Target = (*UserPassTarget)(nil)
+var _ SafeStringer = (*UserPassTarget)(nil)
+var _ Validator = (*UserPassTarget)(nil)
+
+// UserPassKind is the kind under which UserPassTarget is registered with the default codec registry
+const UserPassKind = "UserPass"
+
+func init() {
+	if err := RegisterTargetKind(UserPassKind, "v1", UserPassTarget{}, func(bytes []byte) (Target, error) {
+		var target UserPassTarget
+		err := UserPassTargetFromBytes(bytes, &target)
+		return target, err
+	}); err != nil {
+		panic(err)
+	}
+}
 
 // Unmarshal the input byte array into a UserPassTarget object
 func UserPassTargetFromBytes(bytes []byte, target *UserPassTarget) (error) {
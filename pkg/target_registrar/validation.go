@@ -0,0 +1,146 @@
+package target_registrar
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Validator is implemented by targets that can check their own fields before being registered
+type Validator interface {
+	// Validate returns ValidationErrors listing every invalid field of the target, or nil if the target is valid
+	Validate() error
+}
+
+// ValidatingRegistrar is implemented by registrars that put extra constraints on the targets they can keep, e.g. on
+// the characters allowed in the probe type or the id
+type ValidatingRegistrar interface {
+	// ValidateTarget returns ValidationErrors listing every constraint of this registrar that the target violates
+	ValidateTarget(target Target) error
+}
+
+// ValidationError describes an invalid field of a target.  It never carries the value of the field, so that it is safe
+// to return to the client as is, e.g. in a 400 response.
+type ValidationError struct {
+	// ProbeType and TargetId identify the invalid target; they are filled in by ValidateTarget
+	ProbeType string
+	TargetId  string
+	// Field is the name of the invalid field
+	Field string
+	// Reason explains why the field is invalid
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.ProbeType == "" && e.TargetId == "" {
+		return fmt.Sprintf("%v: %v", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("invalid target %v: %v: %v", safeString(e.ProbeType, e.TargetId), e.Field, e.Reason)
+}
+
+// ValidationErrors lists all the invalid fields of a target
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	messages := make([]string, 0, len(errs))
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+// AsValidationErrors returns the ValidationErrors carried by the given error, or nil if it is not a validation error
+func AsValidationErrors(err error) ValidationErrors {
+	var validationErrs ValidationErrors
+	if errors.As(err, &validationErrs) {
+		return validationErrs
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return ValidationErrors{validationErr}
+	}
+	return nil
+}
+
+// IsValidationError returns true if the given error reports an invalid target
+func IsValidationError(err error) bool {
+	return AsValidationErrors(err) != nil
+}
+
+// ValidateTarget checks that the target has a probe type and an id, and then runs its own validation if it implements
+// the Validator interface.  It returns ValidationErrors listing every invalid field, or nil if the target is valid.
+func ValidateTarget(target Target) error {
+	if target == nil {
+		return ValidationErrors{{Field: "Target", Reason: "target is nil"}}
+	}
+	errs := keyErrors(target)
+	if validator, ok := target.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			fieldErrs := AsValidationErrors(err)
+			if fieldErrs == nil {
+				fieldErrs = ValidationErrors{{Field: "Target", Reason: err.Error()}}
+			}
+			errs = append(errs, fieldErrs...)
+		}
+	}
+	return IdentifyValidationErrors(target, errs)
+}
+
+// ValidateTargetKey checks that the target has a probe type and an id, leaving its own validation alone, e.g. for a
+// target to delete of which only the probe type and the id are known.  It returns ValidationErrors listing every
+// missing field, or nil if the target can be looked up.
+func ValidateTargetKey(target Target) error {
+	if target == nil {
+		return ValidationErrors{{Field: "Target", Reason: "target is nil"}}
+	}
+	return IdentifyValidationErrors(target, keyErrors(target))
+}
+
+// keyErrors returns a validation error for the probe type and the id of the target if left empty
+func keyErrors(target Target) ValidationErrors {
+	return requiredFields{{"Probetype", target.GetProbeType()}, {"Id", target.GetId()}}.validate()
+}
+
+// IdentifyValidationErrors fills in the probe type and id of the target in each of the given validation errors, and
+// returns them as a single error, or nil if there is none
+func IdentifyValidationErrors(target Target, errs ValidationErrors) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, err := range errs {
+		err.ProbeType, err.TargetId = target.GetProbeType(), target.GetId()
+	}
+	return errs
+}
+
+// fieldError returns a validation error about the given field of a target
+func fieldError(field string, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// requiredFields is an ordered list of fields that must not be empty, each paired with its value
+type requiredFields [][2]string
+
+// validate returns a validation error for every required field left empty
+func (fields requiredFields) validate() ValidationErrors {
+	var errs ValidationErrors
+	for _, field := range fields {
+		if field[1] == "" {
+			errs = append(errs, fieldError(field[0], "required field is empty"))
+		}
+	}
+	return errs
+}
+
+// aggregate returns the errors found by a validation as a single error, or nil if there is none
+func aggregate(errs ValidationErrors) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// safeString describes a target by its probe type and id only
+func safeString(probeType string, id string) string {
+	return fmt.Sprintf("%v/%v", probeType, id)
+}
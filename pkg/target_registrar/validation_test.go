@@ -0,0 +1,56 @@
+package target_registrar_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+)
+
+var _ = Describe("Test target validation", func() {
+	DescribeTable("test validating a target",
+		func(target target_registrar.Target, expectedFields []string) {
+			err := target_registrar.ValidateTarget(target)
+			if len(expectedFields) == 0 {
+				Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			Expect(target_registrar.IsValidationError(err)).To(BeTrue())
+			validationErrs := target_registrar.AsValidationErrors(err)
+			var fields []string
+			for _, validationErr := range validationErrs {
+				Expect(validationErr.ProbeType).To(Equal(target.GetProbeType()))
+				Expect(validationErr.TargetId).To(Equal(target.GetId()))
+				fields = append(fields, validationErr.Field)
+			}
+			Expect(fields).To(Equal(expectedFields))
+
+			// The typed errors survive wrapping
+			wrappedErr := fmt.Errorf("failed to add target: %w", err)
+			Expect(target_registrar.AsValidationErrors(wrappedErr)).To(Equal(validationErrs))
+		},
+		Entry("a valid target", target_registrar.UserPassTarget{Id: "Moid1", Probetype: "vcenter",
+			Username: "user1", Password: "pass1"}, nil),
+		Entry("a target without a probe type and an id", target_registrar.UserPassTarget{Username: "user1",
+			Password: "pass1"}, []string{"Probetype", "Id"}),
+		Entry("a target missing its own required fields", target_registrar.UserPassTarget{Id: "Moid1",
+			Probetype: "vcenter"}, []string{"Username", "Password"}),
+		Entry("a target without its own validation", tokenTargetV2{Id: "Moid1"}, []string{"Probetype"}),
+	)
+
+	It("checks only the probe type and the id of a target to look up", func() {
+		Expect(target_registrar.ValidateTargetKey(target_registrar.UserPassTarget{Id: "Moid1",
+			Probetype: "vcenter"})).To(Succeed())
+		err := target_registrar.ValidateTargetKey(target_registrar.UserPassTarget{Id: "Moid1", Username: "user1"})
+		validationErrs := target_registrar.AsValidationErrors(err)
+		Expect(validationErrs).To(HaveLen(1))
+		Expect(validationErrs[0].Field).To(Equal("Probetype"))
+		Expect(target_registrar.IsValidationError(target_registrar.ValidateTargetKey(nil))).To(BeTrue())
+	})
+
+	It("does not mistake other errors for validation errors", func() {
+		Expect(target_registrar.IsValidationError(fmt.Errorf("api server is down"))).To(BeFalse())
+		Expect(target_registrar.IsValidationError(nil)).To(BeFalse())
+	})
+})
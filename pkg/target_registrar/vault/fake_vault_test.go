@@ -0,0 +1,165 @@
+package vault_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// fakeSecret is a secret kept by the fake Vault, with its current version
+type fakeSecret struct {
+	version int
+	data    map[string]string
+}
+
+// fakeVault is a stand-in for the subset of the Vault API used by the Vault registrar: the KV v2 secrets engine
+// mounted at "secret" and the AppRole auth method mounted at "approle"
+type fakeVault struct {
+	lock    sync.Mutex
+	secrets map[string]*fakeSecret
+	// tokens lists the valid client tokens
+	tokens map[string]bool
+	// roleId and secretId are the AppRole credentials to log in with
+	roleId   string
+	secretId string
+	logins   int
+	// requests counts the requests served, logins included
+	requests int
+	// beforeWrite is called before each write is checked and applied, e.g. to simulate a concurrent writer
+	beforeWrite func(path string)
+}
+
+func newFakeVault(tokens ...string) *fakeVault {
+	v := &fakeVault{secrets: map[string]*fakeSecret{}, tokens: map[string]bool{}}
+	for _, token := range tokens {
+		v.tokens[token] = true
+	}
+	return v
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.lock.Lock()
+	v.requests++
+	v.lock.Unlock()
+	if r.URL.Path == "/v1/auth/approle/login" {
+		v.login(w, r)
+		return
+	}
+	v.lock.Lock()
+	authorized := v.tokens[r.Header.Get("X-Vault-Token")]
+	v.lock.Unlock()
+	if !authorized {
+		respond(w, http.StatusForbidden, map[string]interface{}{"errors": []string{"permission denied"}})
+		return
+	}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		path := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+		switch r.Method {
+		case http.MethodGet:
+			v.read(w, path)
+		case http.MethodPost, http.MethodPut:
+			v.write(w, r, path)
+		}
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/"):
+		path := strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("list") == "true":
+			v.list(w, path)
+		case r.Method == http.MethodDelete:
+			v.lock.Lock()
+			delete(v.secrets, path)
+			v.lock.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+	}
+}
+
+func (v *fakeVault) login(w http.ResponseWriter, r *http.Request) {
+	var body map[string]string
+	json.NewDecoder(r.Body).Decode(&body)
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.roleId == "" || body["role_id"] != v.roleId || body["secret_id"] != v.secretId {
+		respond(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+		return
+	}
+	v.logins++
+	token := "approle-token-" + strconv.Itoa(v.logins)
+	v.tokens[token] = true
+	respond(w, http.StatusOK, map[string]interface{}{"auth": map[string]string{"client_token": token}})
+}
+
+func (v *fakeVault) read(w http.ResponseWriter, path string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	secret, found := v.secrets[path]
+	if !found {
+		respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		return
+	}
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+		"data": secret.data, "metadata": map[string]int{"version": secret.version}}})
+}
+
+func (v *fakeVault) write(w http.ResponseWriter, r *http.Request, path string) {
+	var body struct {
+		Options map[string]int    `json:"options"`
+		Data    map[string]string `json:"data"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	if v.beforeWrite != nil {
+		v.beforeWrite(path)
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	version := 0
+	if secret, found := v.secrets[path]; found {
+		version = secret.version
+	}
+	if cas, found := body.Options["cas"]; found && cas != version {
+		respond(w, http.StatusBadRequest, map[string]interface{}{"errors": []string{
+			"check-and-set parameter did not match the current version"}})
+		return
+	}
+	v.secrets[path] = &fakeSecret{version: version + 1, data: body.Data}
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string]int{"version": version + 1}})
+}
+
+func (v *fakeVault) list(w http.ResponseWriter, path string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	unique := map[string]bool{}
+	for secretPath := range v.secrets {
+		if !strings.HasPrefix(secretPath, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(secretPath, prefix)
+		if i := strings.Index(rest, "/"); i >= 0 {
+			rest = rest[:i+1]
+		}
+		unique[rest] = true
+	}
+	if len(unique) == 0 {
+		respond(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+		return
+	}
+	var keys []string
+	for key := range unique {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	respond(w, http.StatusOK, map[string]interface{}{"data": map[string][]string{"keys": keys}})
+}
+
+func respond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
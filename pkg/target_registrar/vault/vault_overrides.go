@@ -0,0 +1,84 @@
+package vault
+
+import (
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"net/http"
+)
+
+// overridesName is the name of the secret under the path prefix keeping the override modes of the probes.  The '.' is
+// escaped in every probe type segment, so the name never clashes with the path of a probe type.
+const overridesName = ".overrides"
+
+// SetOverrideMode keeps the override mode of the given probe type in the overrides secret, which maps the probe types
+// to their modes.  Writes use the check-and-set version of the secret like the writes of the targets.
+func (r *VaultRegistrar) SetOverrideMode(probeType string, mode target_registrar.OverrideMode) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = r.writeOverrideMode(probeType, mode)
+		if !isCASMismatch(err) || attempt == maxCASRetries {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set the override mode of probe %v to %v in Vault at %v\n%v", probeType, mode,
+			r.overridesPath(), err)
+	}
+	return nil
+}
+
+// writeOverrideMode reads the overrides secret and writes it back with the override mode of the given probe type, with
+// the version read as the check-and-set parameter
+func (r *VaultRegistrar) writeOverrideMode(probeType string, mode target_registrar.OverrideMode) error {
+	overrides, version, err := r.readSecret(r.overridesPath())
+	if err != nil {
+		return err
+	}
+	if overrides == nil {
+		overrides = map[string]string{}
+	}
+	if mode == target_registrar.OverrideAuto {
+		if _, found := overrides[probeType]; !found {
+			return nil
+		}
+		delete(overrides, probeType)
+	} else {
+		overrides[probeType] = string(mode)
+	}
+	body := map[string]interface{}{"options": map[string]int{"cas": version}, "data": overrides}
+	_, err = r.do(http.MethodPost, r.apiPath("data", r.overridesPath()), body)
+	return err
+}
+
+// GetOverrideMode returns the override mode of the given probe type, or OverrideAuto if none is kept
+func (r *VaultRegistrar) GetOverrideMode(probeType string) (target_registrar.OverrideMode, error) {
+	overrides, err := r.ListOverrideModes()
+	if err != nil {
+		return "", err
+	}
+	if mode, found := overrides[probeType]; found {
+		return mode, nil
+	}
+	return target_registrar.OverrideAuto, nil
+}
+
+// ListOverrideModes returns the override modes kept in the overrides secret
+func (r *VaultRegistrar) ListOverrideModes() (map[string]target_registrar.OverrideMode, error) {
+	secret, _, err := r.readSecret(r.overridesPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the override modes from Vault at %v\n%v", r.overridesPath(), err)
+	}
+	overrides := make(map[string]target_registrar.OverrideMode, len(secret))
+	for probeType, mode := range secret {
+		overrides[probeType] = target_registrar.OverrideMode(mode)
+	}
+	return overrides, nil
+}
+
+// overridesPath returns the secret path of the overrides secret
+func (r *VaultRegistrar) overridesPath() string {
+	return r.config.PathPrefix + "/" + overridesName
+}
+
+// Make sure VaultRegistrar implements the OverrideRegistrar interface, or a compilation error will result
+var _ target_registrar.OverrideRegistrar = (*VaultRegistrar)(nil)
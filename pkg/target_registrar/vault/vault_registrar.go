@@ -0,0 +1,412 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMount is the default mount path of the KV v2 secrets engine
+	DefaultMount = "secret"
+	// DefaultPathPrefix is the default path under the mount where the probe types are kept
+	DefaultPathPrefix = "probes"
+	// DefaultAppRoleMount is the default mount path of the AppRole auth method
+	DefaultAppRoleMount = "approle"
+	// maxCASRetries is the number of times a write is retried after losing a check-and-set race
+	maxCASRetries = 5
+)
+
+// VaultConfig configures the connection to Vault and the location of the targets.  Either Token or both
+// AppRoleRoleId and AppRoleSecretId must be set.
+type VaultConfig struct {
+	// Address is the address of the Vault server, e.g. https://vault.example.com:8200
+	Address string
+	// Mount is the mount path of the KV v2 secrets engine; it defaults to DefaultMount
+	Mount string
+	// PathPrefix is the path under the mount where the targets are kept as <PathPrefix>/<probeType>/<targetId>; it
+	// defaults to DefaultPathPrefix
+	PathPrefix string
+	// Token authenticates with a Vault token
+	Token string
+	// AppRoleRoleId and AppRoleSecretId authenticate with the AppRole auth method mounted at AppRoleMount, which
+	// defaults to DefaultAppRoleMount
+	AppRoleRoleId   string
+	AppRoleSecretId string
+	AppRoleMount    string
+	// HTTPClient is the client to talk to Vault with; it defaults to a client with a 30 second timeout
+	HTTPClient *http.Client
+}
+
+// VaultRegistrar implements the Registrar interface keeping each target in the KV v2 secrets engine of Vault, under
+// <mount>/<pathPrefix>/<probeType>/<targetId>.  Writes use check-and-set versions, and whether a probe type has any
+// target is worked out from listing the path of the probe type.
+type VaultRegistrar struct {
+	config VaultConfig
+	client *http.Client
+	// lock guards the token, which is renewed by logging in again when AppRole auth is configured
+	lock  sync.Mutex
+	token string
+}
+
+// vaultResponse is the part of the Vault responses used by this registrar
+type vaultResponse struct {
+	Errors []string `json:"errors"`
+	Data   struct {
+		Data     map[string]string `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+		Keys []string `json:"keys"`
+	} `json:"data"`
+	Auth struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+}
+
+// vaultError is returned for a request that Vault has responded to with an error status
+type vaultError struct {
+	StatusCode int
+	Errors     []string
+}
+
+func (e *vaultError) Error() string {
+	return fmt.Sprintf("vault responded with status %d: %v", e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+// isCASMismatch returns true if the error reports a write that has lost a check-and-set race
+func isCASMismatch(err error) bool {
+	vaultErr, ok := err.(*vaultError)
+	return ok && vaultErr.StatusCode == http.StatusBadRequest &&
+		strings.Contains(strings.Join(vaultErr.Errors, " "), "check-and-set")
+}
+
+// NewVaultRegistrar constructs a VaultRegistrar from the given config
+func NewVaultRegistrar(config VaultConfig) (*VaultRegistrar, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("the address of the Vault server is not set")
+	}
+	if config.Token == "" && (config.AppRoleRoleId == "" || config.AppRoleSecretId == "") {
+		return nil, fmt.Errorf("neither a Vault token nor an AppRole role id and secret id are set")
+	}
+	if config.Mount == "" {
+		config.Mount = DefaultMount
+	}
+	if config.PathPrefix == "" {
+		config.PathPrefix = DefaultPathPrefix
+	}
+	if config.AppRoleMount == "" {
+		config.AppRoleMount = DefaultAppRoleMount
+	}
+	client := config.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &VaultRegistrar{config: config, client: client, token: config.Token}, nil
+}
+
+// RegisterTarget writes the target to its path in Vault, with the check-and-set version of the current secret so that
+// concurrent writes are not lost.  The write is skipped if the secret keeps the same target info already.
+func (r *VaultRegistrar) RegisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	if err := target_registrar.CheckPathKey(target.GetProbeType(), target.GetId()); err != nil {
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to store target %v in Vault\n%v",
+			target_registrar.SafeString(target), err)
+	}
+	data, err := target_registrar.EncodeTarget(target)
+	if err != nil {
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to encode target %v\n%v",
+			target_registrar.SafeString(target), err)
+	}
+	secret := map[string]string{"probeType": target.GetProbeType(), "id": target.GetId(), "target": string(data)}
+	path := r.targetPath(target.GetProbeType(), target.GetId())
+	var result target_registrar.RegistrationResult
+	for attempt := 0; ; attempt++ {
+		result, err = r.writeTarget(target.GetProbeType(), path, secret)
+		if !isCASMismatch(err) || attempt == maxCASRetries {
+			break
+		}
+	}
+	if err != nil {
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to store target %v in Vault at %v\n%v",
+			target_registrar.SafeString(target), path, err)
+	}
+	return result, nil
+}
+
+// UnregisterTarget deletes all the versions of the target from Vault
+func (r *VaultRegistrar) UnregisterTarget(target target_registrar.Target) (target_registrar.RegistrationResult, error) {
+	if err := target_registrar.CheckPathKey(target.GetProbeType(), target.GetId()); err != nil {
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to remove target %v from Vault\n%v",
+			target_registrar.SafeString(target), err)
+	}
+	path := r.targetPath(target.GetProbeType(), target.GetId())
+	keys, err := r.listTargetKeys(target.GetProbeType())
+	if err == nil {
+		_, err = r.do(http.MethodDelete, r.apiPath("metadata", path), nil)
+	}
+	if err != nil && !isNotFound(err) {
+		return target_registrar.RegistrationResult{}, fmt.Errorf("failed to remove target %v from Vault at %v\n%v",
+			target_registrar.SafeString(target), path, err)
+	}
+	existed := false
+	for _, key := range keys {
+		existed = existed || key == target_registrar.EscapeName(target.GetId())
+	}
+	return target_registrar.NewUnregisterResult(existed, len(keys)), nil
+}
+
+// ListProbeTypes returns the probe types having at least one target, sorted
+func (r *VaultRegistrar) ListProbeTypes() ([]string, error) {
+	keys, err := r.list(r.config.PathPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the probe types in Vault at %v\n%v", r.config.PathPrefix, err)
+	}
+	var probeTypes []string
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			continue
+		}
+		probeType, err := url.PathUnescape(strings.TrimSuffix(key, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode probe type %v in Vault at %v\n%v", key, r.config.PathPrefix, err)
+		}
+		probeTypes = append(probeTypes, probeType)
+	}
+	sort.Strings(probeTypes)
+	return probeTypes, nil
+}
+
+// CountTargets returns the number of targets listed under the path of the given probe type
+func (r *VaultRegistrar) CountTargets(probeType string) (int, error) {
+	keys, err := r.listTargetKeys(probeType)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the targets of probe type %v in Vault\n%v", probeType, err)
+	}
+	return len(keys), nil
+}
+
+// ListTargets returns the targets of the given probe type, sorted by the target id
+func (r *VaultRegistrar) ListTargets(probeType string) ([]target_registrar.Target, error) {
+	keys, err := r.listTargetKeys(probeType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the targets of probe type %v in Vault\n%v", probeType, err)
+	}
+	targets := make([]target_registrar.Target, 0, len(keys))
+	for _, key := range keys {
+		secret, _, err := r.readSecret(r.probeTypePath(probeType) + "/" + key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the targets of probe type %v in Vault\n%v", probeType, err)
+		}
+		if secret == nil {
+			// deleted since listed
+			continue
+		}
+		target, err := target_registrar.DecodeTarget(secret["probeType"], secret["id"], []byte(secret["target"]))
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].GetId() < targets[j].GetId()
+	})
+	return targets, nil
+}
+
+// GetTarget returns the target of the given probe type and id, or nil if not found
+func (r *VaultRegistrar) GetTarget(probeType string, id string) (target_registrar.Target, error) {
+	if err := target_registrar.CheckPathKey(probeType, id); err != nil {
+		return nil, fmt.Errorf("failed to read target %v/%v from Vault\n%v", probeType, id, err)
+	}
+	path := r.targetPath(probeType, id)
+	secret, _, err := r.readSecret(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target %v/%v from Vault at %v\n%v", probeType, id, path, err)
+	}
+	if secret == nil {
+		return nil, nil
+	}
+	return target_registrar.DecodeTarget(secret["probeType"], secret["id"], []byte(secret["target"]))
+}
+
+// writeTarget reads the current version of the secret of the target at the given path, and writes the secret with
+// that version as the check-and-set parameter, so that the write fails if another writer has got in between
+func (r *VaultRegistrar) writeTarget(probeType string, path string,
+	secret map[string]string) (target_registrar.RegistrationResult, error) {
+	var result target_registrar.RegistrationResult
+	keys, err := r.listTargetKeys(probeType)
+	if err != nil {
+		return result, err
+	}
+	previousSecret, version, err := r.readSecret(path)
+	if err != nil {
+		return result, err
+	}
+	result = target_registrar.NewRegisterResult([]byte(previousSecret["target"]), previousSecret != nil,
+		[]byte(secret["target"]), len(keys))
+	if result.Change == target_registrar.RegistrationUnchanged {
+		return result, nil
+	}
+	body := map[string]interface{}{"options": map[string]int{"cas": version}, "data": secret}
+	_, err = r.do(http.MethodPost, r.apiPath("data", path), body)
+	return result, err
+}
+
+// readSecret returns the data and the version of the secret at the given path, or nil and version 0 if not found
+func (r *VaultRegistrar) readSecret(path string) (map[string]string, int, error) {
+	response, err := r.do(http.MethodGet, r.apiPath("data", path), nil)
+	if isNotFound(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return response.Data.Data, response.Data.Metadata.Version, nil
+}
+
+// listTargetKeys returns the keys of the targets under the path of the given probe type
+func (r *VaultRegistrar) listTargetKeys(probeType string) ([]string, error) {
+	keys, err := r.list(r.probeTypePath(probeType))
+	if err != nil {
+		return nil, err
+	}
+	var targetKeys []string
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			targetKeys = append(targetKeys, key)
+		}
+	}
+	return targetKeys, nil
+}
+
+// list returns the keys under the given path, or none if the path does not exist
+func (r *VaultRegistrar) list(path string) ([]string, error) {
+	response, err := r.do(http.MethodGet, r.apiPath("metadata", path)+"?list=true", nil)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return response.Data.Keys, nil
+}
+
+// do sends a request to Vault, logging in first if no token is available yet, and logging in again once if the token
+// is rejected and AppRole auth is configured
+func (r *VaultRegistrar) do(method string, apiPath string, body interface{}) (*vaultResponse, error) {
+	token, err := r.getToken(false)
+	if err != nil {
+		return nil, err
+	}
+	response, err := r.send(method, apiPath, token, body)
+	if vaultErr, ok := err.(*vaultError); ok && vaultErr.StatusCode == http.StatusForbidden && r.usesAppRole() {
+		if token, err = r.getToken(true); err != nil {
+			return nil, err
+		}
+		response, err = r.send(method, apiPath, token, body)
+	}
+	return response, err
+}
+
+// getToken returns the token to authenticate with, logging in with AppRole if there is none yet or if asked to renew it
+func (r *VaultRegistrar) getToken(renew bool) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.token != "" && !renew {
+		return r.token, nil
+	}
+	if !r.usesAppRole() {
+		return r.token, nil
+	}
+	body := map[string]string{"role_id": r.config.AppRoleRoleId, "secret_id": r.config.AppRoleSecretId}
+	response, err := r.send(http.MethodPost, "auth/"+r.config.AppRoleMount+"/login", "", body)
+	if err != nil {
+		return "", fmt.Errorf("failed to log in to Vault with AppRole\n%v", err)
+	}
+	if response.Auth.ClientToken == "" {
+		return "", fmt.Errorf("failed to log in to Vault with AppRole: no client token returned")
+	}
+	r.token = response.Auth.ClientToken
+	return r.token, nil
+}
+
+// usesAppRole returns true if AppRole auth is configured
+func (r *VaultRegistrar) usesAppRole() bool {
+	return r.config.AppRoleRoleId != "" && r.config.AppRoleSecretId != ""
+}
+
+// send sends a single request to the Vault API and decodes the response
+func (r *VaultRegistrar) send(method string, apiPath string, token string, body interface{}) (*vaultResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, strings.TrimSuffix(r.config.Address, "/")+"/v1/"+apiPath, reader)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		request.Header.Set("X-Vault-Token", token)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	httpResponse, err := r.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	content, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return nil, err
+	}
+	var response vaultResponse
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, &response); err != nil {
+			return nil, fmt.Errorf("failed to decode the response of Vault with status %d\n%v",
+				httpResponse.StatusCode, err)
+		}
+	}
+	if httpResponse.StatusCode >= 300 {
+		return nil, &vaultError{StatusCode: httpResponse.StatusCode, Errors: response.Errors}
+	}
+	return &response, nil
+}
+
+// isNotFound returns true if the error reports a path not found in Vault
+func isNotFound(err error) bool {
+	vaultErr, ok := err.(*vaultError)
+	return ok && vaultErr.StatusCode == http.StatusNotFound
+}
+
+// apiPath returns the path of the KV v2 API of the given kind, i.e. data or metadata, for the given secret path, with
+// the escaped probe types and ids escaped once more for the URL
+func (r *VaultRegistrar) apiPath(kind string, path string) string {
+	return r.config.Mount + "/" + kind + "/" + strings.ReplaceAll(path, "%", "%25")
+}
+
+// probeTypePath returns the secret path under which the targets of the given probe type are kept
+func (r *VaultRegistrar) probeTypePath(probeType string) string {
+	return r.config.PathPrefix + "/" + target_registrar.EscapeName(probeType)
+}
+
+// targetPath returns the secret path of the given target
+func (r *VaultRegistrar) targetPath(probeType string, id string) string {
+	return r.probeTypePath(probeType) + "/" + target_registrar.EscapeName(id)
+}
+
+// Make sure VaultRegistrar implements the Registrar interface, or a compilation error will result
+var _ target_registrar.Registrar = (*VaultRegistrar)(nil)
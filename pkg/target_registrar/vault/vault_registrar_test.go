@@ -0,0 +1,177 @@
+package vault_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar"
+	"github.com/turbonomic/probe-lifecycle-manager/pkg/target_registrar/vault"
+	"net/http/httptest"
+)
+
+func newTarget(probeType string, id string) target_registrar.UserPassTarget {
+	return target_registrar.UserPassTarget{Id: id, Probetype: probeType, Username: "user-" + id, Password: "pass-" + id}
+}
+
+var _ = Describe("Vault registrar", func() {
+	var fake *fakeVault
+	var server *httptest.Server
+	var registrar *vault.VaultRegistrar
+
+	BeforeEach(func() {
+		fake = newFakeVault("root-token")
+		server = httptest.NewServer(fake)
+		var err error
+		registrar, err = vault.NewVaultRegistrar(vault.VaultConfig{Address: server.URL, Token: "root-token"})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	DescribeTable("registering and unregistering targets",
+		func(registered []string, toUnregister []string, expectedLastTarget bool, expectedCount int) {
+			for _, id := range registered {
+				result, err := registrar.RegisterTarget(newTarget("vcenter", id))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.NewCount).To(BeNumerically(">", 0))
+			}
+			var result target_registrar.RegistrationResult
+			for _, id := range toUnregister {
+				var err error
+				result, err = registrar.UnregisterTarget(newTarget("vcenter", id))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(result.IsLastTarget()).To(Equal(expectedLastTarget))
+			count, err := registrar.CountTargets("vcenter")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(count).To(Equal(expectedCount))
+		},
+		Entry("unregister the only target", []string{"t1"}, []string{"t1"}, true, 0),
+		Entry("unregister one of two targets", []string{"t1", "t2"}, []string{"t1"}, false, 1),
+		Entry("unregister both targets", []string{"t1", "t2"}, []string{"t2", "t1"}, true, 0),
+		Entry("unregister a target never registered", []string{"t1"}, []string{"t2"}, false, 1),
+		Entry("unregister from a probe type never registered", []string{}, []string{"t1"}, false, 0),
+		Entry("register the same target twice", []string{"t1", "t1"}, []string{"t1"}, true, 0),
+	)
+
+	DescribeTable("rejecting a target without a probe type or an id before sending any request",
+		func(probeType string, id string) {
+			_, err := registrar.RegisterTarget(newTarget(probeType, id))
+			Expect(err).To(HaveOccurred())
+			_, err = registrar.UnregisterTarget(newTarget(probeType, id))
+			Expect(err).To(HaveOccurred())
+			_, err = registrar.GetTarget(probeType, id)
+			Expect(err).To(HaveOccurred())
+			fake.lock.Lock()
+			defer fake.lock.Unlock()
+			Expect(fake.requests).To(BeZero())
+			Expect(fake.secrets).To(BeEmpty())
+		},
+		Entry("a target without an id, whose path would be the folder of its probe type", "vcenter", ""),
+		Entry("a target without a probe type, whose path would be right under the prefix", "", "t1"),
+	)
+
+	It("keeps each target under its own path and reads the targets back", func() {
+		for _, target := range []target_registrar.Target{newTarget("vcenter", "https://10.10.10.10/sdk"),
+			newTarget("vcenter", "t1"), newTarget("aws/ec2", "t1")} {
+			_, err := registrar.RegisterTarget(target)
+			Expect(err).NotTo(HaveOccurred())
+		}
+		Expect(fake.secrets).To(HaveKey("probes/vcenter/t1"))
+		Expect(fake.secrets).To(HaveKey("probes/vcenter/https%3A%2F%2F10%2E10%2E10%2E10%2Fsdk"))
+
+		probeTypes, err := registrar.ListProbeTypes()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(probeTypes).To(Equal([]string{"aws/ec2", "vcenter"}))
+		targets, err := registrar.ListTargets("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targets).To(Equal([]target_registrar.Target{newTarget("vcenter", "https://10.10.10.10/sdk"),
+			newTarget("vcenter", "t1")}))
+		target, err := registrar.GetTarget("aws/ec2", "t1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(Equal(newTarget("aws/ec2", "t1")))
+		target, err = registrar.GetTarget("vcenter", "missing")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target).To(BeNil())
+	})
+
+	It("skips the write of a target registered again with the same info", func() {
+		result, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.Change).To(Equal(target_registrar.RegistrationCreated))
+		result, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result).To(Equal(target_registrar.RegistrationResult{
+			Change: target_registrar.RegistrationUnchanged, PreviousCount: 1, NewCount: 1}))
+		Expect(fake.secrets["probes/vcenter/t1"].version).To(Equal(1))
+	})
+
+	It("retries a write that has lost a check-and-set race", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		races := 2
+		fake.beforeWrite = func(path string) {
+			if races > 0 {
+				races--
+				fake.lock.Lock()
+				fake.secrets[path].version++
+				fake.lock.Unlock()
+			}
+		}
+		updatedTarget := newTarget("vcenter", "t1")
+		updatedTarget.Password = "new-password"
+		_, err = registrar.RegisterTarget(updatedTarget)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(races).To(Equal(0))
+		Expect(fake.secrets["probes/vcenter/t1"].version).To(Equal(4))
+	})
+
+	It("keeps the override modes next to the targets without mistaking them for a probe type", func() {
+		_, err := registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(registrar.SetOverrideMode("vcenter", target_registrar.OverrideForceDisabled)).To(Succeed())
+		Expect(registrar.SetOverrideMode("pure", target_registrar.OverrideForceEnabled)).To(Succeed())
+		Expect(fake.secrets).To(HaveKey("probes/.overrides"))
+		Expect(registrar.ListProbeTypes()).To(Equal([]string{"vcenter"}))
+
+		Expect(registrar.GetOverrideMode("vcenter")).To(Equal(target_registrar.OverrideForceDisabled))
+		Expect(registrar.GetOverrideMode("aws")).To(Equal(target_registrar.OverrideAuto))
+		Expect(registrar.SetOverrideMode("vcenter", target_registrar.OverrideAuto)).To(Succeed())
+		Expect(registrar.ListOverrideModes()).To(Equal(map[string]target_registrar.OverrideMode{
+			"pure": target_registrar.OverrideForceEnabled,
+		}))
+	})
+
+	It("logs in with AppRole, and logs in again when the token is rejected", func() {
+		fake.roleId, fake.secretId = "role", "secret"
+		registrar, err := vault.NewVaultRegistrar(vault.VaultConfig{Address: server.URL, AppRoleRoleId: "role",
+			AppRoleSecretId: "secret"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(fake.logins).To(Equal(1))
+
+		fake.lock.Lock()
+		fake.tokens = map[string]bool{}
+		fake.lock.Unlock()
+		count, err := registrar.CountTargets("vcenter")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(count).To(Equal(1))
+		Expect(fake.logins).To(Equal(2))
+	})
+
+	It("reports the errors of Vault without revealing the credentials", func() {
+		registrar, err := vault.NewVaultRegistrar(vault.VaultConfig{Address: server.URL, Token: "bad-token"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = registrar.RegisterTarget(newTarget("vcenter", "t1"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("permission denied"))
+		Expect(err.Error()).NotTo(ContainSubstring("pass-t1"))
+		Expect(err.Error()).NotTo(ContainSubstring("bad-token"))
+
+		_, err = vault.NewVaultRegistrar(vault.VaultConfig{Address: server.URL})
+		Expect(err).To(HaveOccurred())
+	})
+})